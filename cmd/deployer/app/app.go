@@ -12,6 +12,10 @@ import (
 	"github.com/golang/glog"
 	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
 	"github.com/knative-sample/tekton-serving/pkg/deployer"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +23,7 @@ import (
 func NewCommandStartServer(stopCh <-chan struct{}) *cobra.Command {
 	ops := &options.Options{}
 	mainCmd := &cobra.Command{
+		Use:   "deployer",
 		Short: "Knative Deployer",
 		Long:  "Knative Deployer ",
 		RunE: func(c *cobra.Command, args []string) error {
@@ -29,43 +34,174 @@ func NewCommandStartServer(stopCh <-chan struct{}) *cobra.Command {
 	}
 
 	ops.SetOps(mainCmd)
+	mainCmd.AddCommand(NewCommandGC())
+	mainCmd.AddCommand(NewCommandHistory())
+	mainCmd.AddCommand(NewCommandTrace())
+	mainCmd.AddCommand(NewCommandApply())
+	mainCmd.AddCommand(NewCommandPromoteEnv())
+	mainCmd.AddCommand(NewCommandDiff())
 	return mainCmd
 }
 
 func run(stopCh <-chan struct{}, ops *options.Options) {
-	if ops.Image == "" {
+	dp := newDeployer(ops)
+	dp.DryRun = ops.DryRun.Enabled
+
+	go func() {
+		<-stopCh
+		time.Sleep(time.Second)
+		os.Exit(0)
+	}()
+
+	// run deployer
+	bts, _ := json.Marshal(dp)
+	glog.Infof("start to deployer: %s", bts)
+	err := dp.Run()
+	dp.Notifier.Wait()
+	if err != nil {
+		glog.Fatalf("deployer:%s error:%s", bts, err)
+	}
+	glog.Infof("end to deployer: %s", bts)
+}
+
+// newDeployer builds the Deployer of the flags, invalid flags are fatal
+func newDeployer(ops *options.Options) *deployer.Deployer {
+	if len(ops.Images) == 0 {
 		glog.Fatalf("--image is empty")
 	}
+	images, err := deployer.ParseImages(ops.Images, ops.Container)
+	if err != nil {
+		glog.Fatalf("parse --image error:%s", err)
+	}
 	if ops.ServiceName == "" {
 		glog.Fatalf("--service-name is empty")
 	}
-
-	ns := ops.Namespace
-	if ns == "" {
-		ns = os.Getenv("NAMESPACE")
-		if ns == "" {
-			glog.Fatalf("--namespace and NAMESPACE ENV is empty")
-		}
+	if err := deployer.ValidKind(ops.Kind); err != nil {
+		glog.Fatalf("%s", err)
 	}
 
-	dp := deployer.Deployer{
+	ns := namespace(ops.Namespace)
+
+	dp := &deployer.Deployer{
+		Kind:        ops.Kind,
 		Namespace:   ns,
 		ServiceName: ops.ServiceName,
-		Image:       ops.Image,
+		Images:      images,
+		Container:   ops.Container,
 		Port:        ops.Port,
+
+		ServiceTemplate: ops.ServiceTemplate,
+		MergePolicy:     ops.MergePolicy,
+		Env:             ops.Env,
+		EnvFromSecret:   ops.EnvFromSecret,
+		MinScale:        ops.MinScale,
+		MaxScale:        ops.MaxScale,
+		Concurrency:     ops.Concurrency,
+
+		AllowMutableTags:   ops.AllowMutableTags,
+		InsecureRegistries: ops.InsecureRegistries,
+
+		KeepRevisions:   ops.Retention.KeepRevisions,
+		DeleteRevisions: ops.Retention.DeleteRevisions,
+
+		IngressAddress: ops.IngressAddress,
+		Promote:        ops.Promote,
+		ReadyTimeout:   ops.ReadyTimeout,
+
+		Provenance: provenance.Provenance{
+			Commit:      ops.Provenance.Commit,
+			Repo:        ops.Provenance.Repo,
+			PullRequest: ops.Provenance.PullRequest,
+			PipelineRun: ops.Provenance.PipelineRun,
+			Delivery:    ops.Provenance.Delivery,
+			Author:      ops.Provenance.Author,
+
+			CommitTime:    ops.Provenance.CommitTime,
+			BuildSequence: ops.Provenance.BuildSequence,
+		},
+		HistoryLimit: ops.HistoryLimit,
+
+		RequireApproval: ops.Approval.Require,
+		ApprovalTimeout: ops.Approval.Timeout,
+
+		FreezePolicy:   ops.Freeze.Policy,
+		OverrideFreeze: ops.Freeze.Override,
+
+		LockTimeout:     ops.Lock.Timeout,
+		AllowOlderBuild: ops.Lock.AllowOlderBuild,
+
+		ServerDryRun: ops.DryRun.Server,
+
+		ResultFile: ops.Result.File,
+		ResultsDir: ops.Result.Dir,
+	}
+	dp.Events = newEmitter(ops.Events.Sink)
+	dp.Notifier = newNotifier(ops.Events.NotifyConfig)
+	dp.Provenance.FromEnv()
+	selectEnvironment(dp, ops.Environment)
+	if ops.SmokeConfig != "" {
+		smoke, err := deployer.LoadSmokeConfig(ops.SmokeConfig)
+		if err != nil {
+			glog.Fatalf("load --smoke-config error:%s", err)
+		}
+		dp.Smoke = smoke
+	}
+	if ops.AnalysisConfig != "" {
+		analysis, err := deployer.LoadAnalysisConfig(ops.AnalysisConfig)
+		if err != nil {
+			glog.Fatalf("load --analysis-config error:%s", err)
+		}
+		dp.Analysis = analysis
+	}
+	if ops.HooksConfig != "" {
+		hooks, err := deployer.LoadHooksConfig(ops.HooksConfig)
+		if err != nil {
+			glog.Fatalf("load --hooks-config error:%s", err)
+		}
+		dp.Hooks = hooks
 	}
+	if ops.GitOps.Repo != "" {
+		dp.GitOps = &gitops.Config{
+			Repo:       ops.GitOps.Repo,
+			Branch:     ops.GitOps.Branch,
+			Path:       ops.GitOps.Path,
+			ImagePath:  ops.GitOps.ImagePath,
+			Kustomize:  ops.GitOps.Kustomize,
+			PushBranch: ops.GitOps.PushBranch,
+			Author:     ops.GitOps.Author,
+		}
+		if err := dp.GitOps.Validate(); err != nil {
+			glog.Fatalf("%s", err)
+		}
+	}
+	return dp
+}
 
-	go func() {
-		<-stopCh
-		time.Sleep(time.Second)
-		os.Exit(0)
-	}()
+// newEmitter builds the Emitter of --event-sink, nil when there is no sink
+func newEmitter(sink string) *events.Emitter {
+	e, err := events.NewEmitter(sink, "tekton-serving/deployer")
+	if err != nil {
+		glog.Fatalf("create the event client of --event-sink error:%s", err)
+	}
+	return e
+}
 
-	// run deployer
-	bts, _ := json.Marshal(dp)
-	glog.Infof("start to deployer: %s", bts)
-	if err := dp.Run(); err != nil {
-		glog.Fatalf("deployer:%s error:%s", bts, err)
+// newNotifier loads the --notify-config, nil when it is empty
+func newNotifier(path string) *notify.Notifier {
+	n, err := notify.New(path)
+	if err != nil {
+		glog.Fatalf("load --notify-config error:%s", err)
 	}
-	glog.Infof("end to deployer: %s", bts)
+	return n
+}
+
+// namespace falls back to the NAMESPACE env when --namespace is empty
+func namespace(ns string) string {
+	if ns == "" {
+		ns = os.Getenv("NAMESPACE")
+		if ns == "" {
+			glog.Fatalf("--namespace and NAMESPACE ENV is empty")
+		}
+	}
+	return ns
 }
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/knative-sample/tekton-serving/pkg/deployer"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/spf13/cobra"
+)
+
+// NewCommandApply rolls out the services of a release manifest together
+func NewCommandApply() *cobra.Command {
+	ops := &options.ApplyOptions{}
+	applyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Roll out the services of a release manifest in order, rolling them all back on failure",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("NewCommandApply main:%s", strings.Join(args, " "))
+			return runApply(ops)
+		},
+	}
+
+	ops.SetOps(applyCmd)
+	return applyCmd
+}
+
+func runApply(ops *options.ApplyOptions) error {
+	if ops.File == "" {
+		glog.Fatalf("--file is empty")
+	}
+	release, err := deployer.LoadRelease(ops.File)
+	if err != nil {
+		glog.Fatalf("load --file error:%s", err)
+	}
+
+	dp := deployer.Deployer{
+		Namespace:          namespace(ops.Namespace),
+		AllowMutableTags:   ops.AllowMutableTags,
+		InsecureRegistries: ops.InsecureRegistries,
+		KeepRevisions:      ops.Retention.KeepRevisions,
+		DeleteRevisions:    ops.Retention.DeleteRevisions,
+		ReadyTimeout:       ops.ReadyTimeout,
+		Concurrency:        -1,
+
+		Provenance: provenance.Provenance{
+			Commit:      ops.Provenance.Commit,
+			Repo:        ops.Provenance.Repo,
+			PullRequest: ops.Provenance.PullRequest,
+			PipelineRun: ops.Provenance.PipelineRun,
+			Delivery:    ops.Provenance.Delivery,
+			Author:      ops.Provenance.Author,
+
+			CommitTime:    ops.Provenance.CommitTime,
+			BuildSequence: ops.Provenance.BuildSequence,
+		},
+		HistoryLimit: ops.HistoryLimit,
+
+		RequireApproval: ops.Approval.Require,
+		ApprovalTimeout: ops.Approval.Timeout,
+
+		FreezePolicy:   ops.Freeze.Policy,
+		OverrideFreeze: ops.Freeze.Override,
+
+		LockTimeout:     ops.Lock.Timeout,
+		AllowOlderBuild: ops.Lock.AllowOlderBuild,
+
+		ResultFile: ops.Result.File,
+		ResultsDir: ops.Result.Dir,
+	}
+	dp.Events = newEmitter(ops.Events.Sink)
+	dp.Notifier = newNotifier(ops.Events.NotifyConfig)
+	dp.Provenance.FromEnv()
+	selectEnvironment(&dp, ops.Environment)
+	err = dp.Apply(release)
+	dp.Notifier.Wait()
+	return err
+}
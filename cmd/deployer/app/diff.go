@@ -0,0 +1,45 @@
+package app
+
+import (
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/spf13/cobra"
+)
+
+// NewCommandDiff prints what a deploy would change
+func NewCommandDiff() *cobra.Command {
+	ops := &options.Options{}
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Print the diff of a deploy against the live object, exit 1 when it changes it",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("NewCommandDiff main:%s", strings.Join(args, " "))
+			return runDiff(ops)
+		},
+	}
+
+	ops.SetOps(diffCmd)
+	diffCmd.Flags().MarkHidden("dry-run")
+	return diffCmd
+}
+
+func runDiff(ops *options.Options) error {
+	dp := newDeployer(ops)
+	dp.DryRun = true
+	plan, err := dp.Diff()
+	if err != nil {
+		return err
+	}
+	plan.Print(os.Stdout)
+	if err := plan.Err(); err != nil {
+		return err
+	}
+	if plan.Changed() {
+		glog.Flush()
+		os.Exit(1)
+	}
+	return nil
+}
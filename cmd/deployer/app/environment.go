@@ -0,0 +1,53 @@
+package app
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/knative-sample/tekton-serving/pkg/deployer"
+	"github.com/knative-sample/tekton-serving/pkg/environment"
+)
+
+// loadEnvironments reads --environments-config, DEPLOYER_ENVIRONMENTS when it is empty
+func loadEnvironments(path string) *environment.Config {
+	if path == "" {
+		path = os.Getenv("DEPLOYER_ENVIRONMENTS")
+	}
+	if path == "" {
+		glog.Fatalf("--environments-config is empty")
+	}
+	cfg, err := environment.Load(path)
+	if err != nil {
+		glog.Fatalf("load --environments-config error:%s", err)
+	}
+	return cfg
+}
+
+// useEnvironment points the deployer at the cluster and the namespace of the environment
+func useEnvironment(dp *deployer.Deployer, env *environment.Environment) {
+	restConfig, err := env.RestConfig()
+	if err != nil {
+		glog.Fatalf("get kubeconfig of environment %s error:%s", env.Name, err)
+	}
+	dp.Environment = env.Name
+	dp.RestConfig = restConfig
+	if env.Namespace != "" {
+		dp.Namespace = env.Namespace
+	}
+	if env.RequireApproval {
+		dp.RequireApproval = true
+	}
+}
+
+// selectEnvironment applies --environment when it is set
+func selectEnvironment(dp *deployer.Deployer, ops options.EnvironmentOptions) {
+	if ops.Name == "" {
+		return
+	}
+	env, err := loadEnvironments(ops.Config).Get(ops.Name)
+	if err != nil {
+		glog.Fatalf("%s", err)
+	}
+	useEnvironment(dp, env)
+}
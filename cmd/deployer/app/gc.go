@@ -0,0 +1,40 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/knative-sample/tekton-serving/pkg/deployer"
+	"github.com/spf13/cobra"
+)
+
+// NewCommandGC drops the stale tagged revisions of a Service once
+func NewCommandGC() *cobra.Command {
+	ops := &options.GCOptions{}
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Garbage-collect stale tagged revisions of a Knative Service",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("NewCommandGC main:%s", strings.Join(args, " "))
+			return runGC(ops)
+		},
+	}
+
+	ops.SetOps(gcCmd)
+	return gcCmd
+}
+
+func runGC(ops *options.GCOptions) error {
+	if ops.ServiceName == "" {
+		glog.Fatalf("--service-name is empty")
+	}
+
+	dp := deployer.Deployer{
+		Namespace:       namespace(ops.Namespace),
+		ServiceName:     ops.ServiceName,
+		KeepRevisions:   ops.Retention.KeepRevisions,
+		DeleteRevisions: ops.Retention.DeleteRevisions,
+	}
+	return dp.GC()
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/knative-sample/tekton-serving/pkg/deployer"
+	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NewCommandHistory lists the deploy history of a Service
+func NewCommandHistory() *cobra.Command {
+	ops := &options.HistoryOptions{}
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List the deploy history of a Knative Service",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("NewCommandHistory main:%s", strings.Join(args, " "))
+			return runHistory(ops)
+		},
+	}
+
+	ops.SetOps(historyCmd)
+	return historyCmd
+}
+
+func runHistory(ops *options.HistoryOptions) error {
+	if ops.ServiceName == "" {
+		glog.Fatalf("--service-name is empty")
+	}
+
+	cfg, err := kube.GetKubeconfig()
+	if err != nil {
+		glog.Errorf("get kubeconfig error:%s ", err)
+		return err
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building kubernetes clientset: %v", err)
+	}
+
+	records, err := deployer.History(kubeClient, namespace(ops.Namespace), ops.ServiceName)
+	if err != nil {
+		return err
+	}
+
+	switch ops.Output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "table", "":
+		return deployer.PrintHistory(os.Stdout, records)
+	default:
+		return fmt.Errorf("unknown --output %q, expected table or json", ops.Output)
+	}
+}
@@ -1,19 +1,333 @@
 package options
 
 import (
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
 type Options struct {
-	Image       string
+	Kind        string
+	Images      []string
+	Container   string
 	Namespace   string
 	ServiceName string
 	Port        string
+
+	ServiceTemplate string
+	MergePolicy     string
+	Env             []string
+	EnvFromSecret   []string
+	MinScale        string
+	MaxScale        string
+	Concurrency     int64
+
+	AllowMutableTags   bool
+	InsecureRegistries []string
+
+	Retention RetentionOptions
+
+	SmokeConfig    string
+	IngressAddress string
+	Promote        bool
+	ReadyTimeout   time.Duration
+	AnalysisConfig string
+	HooksConfig    string
+
+	Provenance   ProvenanceOptions
+	HistoryLimit int
+
+	Environment EnvironmentOptions
+	Approval    ApprovalOptions
+	Freeze      FreezeOptions
+	Lock        LockOptions
+	GitOps      GitOpsOptions
+	DryRun      DryRunOptions
+	Result      ResultOptions
+	Events      EventOptions
+}
+
+// EnvironmentOptions select the environment deployed to
+type EnvironmentOptions struct {
+	Config string
+	Name   string
+}
+
+func (s *EnvironmentOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Config, "environments-config", s.Config, "yaml file of the environments and their clusters, DEPLOYER_ENVIRONMENTS ENV when empty")
+	ac.Flags().StringVar(&s.Name, "environment", s.Name, "environment of --environments-config to deploy to, the kubeconfig cluster when empty. It is not --env, which sets the container env")
+}
+
+// ApprovalOptions gate the deploy on a manual approval
+type ApprovalOptions struct {
+	Require bool
+	Timeout time.Duration
+}
+
+func (s *ApprovalOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().BoolVar(&s.Require, "require-approval", false, "create an approval request and wait for a human to approve the deploy")
+	ac.Flags().DurationVar(&s.Timeout, "approval-timeout", time.Hour, "time to wait for the approval, the deploy is aborted after")
+}
+
+// FreezeOptions are the deploy freeze windows
+type FreezeOptions struct {
+	Policy   string
+	Override string
+}
+
+func (s *FreezeOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Policy, "freeze-policy", s.Policy, "namespace/name of the ConfigMap of the deploy freeze policy, such as default/deploy-freeze, no freeze window when empty")
+	ac.Flags().StringVar(&s.Override, "override-freeze", s.Override, "reason to deploy during a freeze, recorded in the deploy history")
+}
+
+// LockOptions serialize the deploys of a Service
+type LockOptions struct {
+	Timeout         time.Duration
+	AllowOlderBuild bool
+}
+
+func (s *LockOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().DurationVar(&s.Timeout, "lock-timeout", 10*time.Minute, "time to wait for another deployer of the service to finish, 0 deploys without the <service>-deploy-lock Lease")
+	ac.Flags().BoolVar(&s.AllowOlderBuild, "allow-older-build", false, "deploy a build older than the one running, by build sequence or commit time")
+}
+
+// GitOpsOptions write the images to a git repository instead of updating the cluster
+type GitOpsOptions struct {
+	Repo       string
+	Branch     string
+	Path       string
+	ImagePath  string
+	Kustomize  bool
+	PushBranch string
+	Author     string
+}
+
+func (s *GitOpsOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Repo, "gitops-repo", s.Repo, "git repository the images are committed to instead of updating the cluster, GitOps mode is off when empty")
+	ac.Flags().StringVar(&s.Branch, "gitops-branch", s.Branch, "branch of the manifests, the default branch when empty")
+	ac.Flags().StringVar(&s.Path, "gitops-path", s.Path, "path of the Service manifest, or of the kustomization.yaml with --gitops-kustomize, in the repository")
+	ac.Flags().StringVar(&s.ImagePath, "gitops-image-path", s.ImagePath, "YAML path of the image in the manifest, such as spec.template.spec.containers[name={container}].image")
+	ac.Flags().BoolVar(&s.Kustomize, "gitops-kustomize", false, "update the images entry of a kustomization instead of the manifest")
+	ac.Flags().StringVar(&s.PushBranch, "gitops-push-branch", s.PushBranch, "branch pushed to for a pull request instead of --gitops-branch, placeholders: {service} {commit}")
+	ac.Flags().StringVar(&s.Author, "gitops-author", s.Author, "\"Name <email>\" of the commits, deployer <deployer@tekton-serving.dev> when empty")
+}
+
+// DryRunOptions show what the deploy would change without changing it
+type DryRunOptions struct {
+	Enabled bool
+	Server  bool
+}
+
+func (s *DryRunOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().BoolVar(&s.Enabled, "dry-run", false, "run the checks and print the diff of the deploy against the live object, nothing is written")
+	ac.Flags().BoolVar(&s.Server, "server-dry-run", s.Server, "with --dry-run and diff, send the update to the API server with dryRun=All to surface admission errors, it needs the permission to update the object")
+}
+
+// ResultOptions are where the JSON result of the deploy is written besides stdout
+type ResultOptions struct {
+	File string
+	Dir  string
+}
+
+func (s *ResultOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.File, "result-file", s.File, "file the JSON result of the deploy is written to")
+	ac.Flags().StringVar(&s.Dir, "results-dir", s.Dir, "Tekton results directory, such as /tekton/results, a file is written per field of the result")
+}
+
+// EventOptions are where the CloudEvents and the notifications of the deploy are sent
+type EventOptions struct {
+	Sink         string
+	NotifyConfig string
+}
+
+func (s *EventOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Sink, "event-sink", s.Sink, "URL of the broker or service the deploy CloudEvents are sent to, K_SINK ENV when empty, no events when both are empty")
+	ac.Flags().StringVar(&s.NotifyConfig, "notify-config", s.NotifyConfig, "yaml file of the Slack, DingTalk, Teams and webhook routes the outcome of the deploy is posted to")
+}
+
+// ProvenanceOptions are the origin of the deployed revision, each flag falls back to an env
+type ProvenanceOptions struct {
+	Commit      string
+	Repo        string
+	PullRequest string
+	PipelineRun string
+	Delivery    string
+	Author      string
+
+	CommitTime    string
+	BuildSequence string
+}
+
+func (s *ProvenanceOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Commit, "commit-sha", s.Commit, "commit sha of the image, COMMIT_SHA ENV when empty")
+	ac.Flags().StringVar(&s.Repo, "repo", s.Repo, "repository of the commit, REPO_URL ENV when empty")
+	ac.Flags().StringVar(&s.PullRequest, "pr-url", s.PullRequest, "pull request of the commit, PR_URL ENV when empty")
+	ac.Flags().StringVar(&s.PipelineRun, "pipelinerun", s.PipelineRun, "PipelineRun deploying the image, PIPELINERUN_NAME ENV when empty")
+	ac.Flags().StringVar(&s.Delivery, "delivery-id", s.Delivery, "delivery ID of the triggering GitHub event, DELIVERY_ID ENV when empty")
+	ac.Flags().StringVar(&s.Author, "author", s.Author, "person who produced the change, AUTHOR ENV when empty")
+	ac.Flags().StringVar(&s.CommitTime, "commit-time", s.CommitTime, "RFC3339 time of the commit, COMMIT_TIME ENV when empty")
+	ac.Flags().StringVar(&s.BuildSequence, "build-sequence", s.BuildSequence, "increasing number of the build, BUILD_SEQUENCE ENV when empty")
+}
+
+// HistoryOptions are the options of the history command
+type HistoryOptions struct {
+	Namespace   string
+	ServiceName string
+	Output      string
+}
+
+func (s *HistoryOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Namespace, "namespace", "default", "namespace")
+	ac.Flags().StringVar(&s.ServiceName, "serivce-name", s.ServiceName, "Knative service name")
+	ac.Flags().StringVarP(&s.Output, "output", "o", "table", "output format: table or json")
+}
+
+// RetentionOptions are the options of the tagged revision garbage collection
+type RetentionOptions struct {
+	KeepRevisions   int
+	DeleteRevisions bool
+}
+
+func (s *RetentionOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().IntVar(&s.KeepRevisions, "keep-revisions", 5, "number of tagged revisions kept in the traffic besides the ones serving traffic, negative keeps all")
+	ac.Flags().BoolVar(&s.DeleteRevisions, "delete-revisions", false, "delete the Revisions whose tags are dropped from the traffic")
+}
+
+// GCOptions are the options of the gc command
+type GCOptions struct {
+	Namespace   string
+	ServiceName string
+	Retention   RetentionOptions
+}
+
+func (s *GCOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Namespace, "namespace", "default", "namespace")
+	ac.Flags().StringVar(&s.ServiceName, "serivce-name", s.ServiceName, "Knative service name")
+	s.Retention.SetOps(ac)
 }
 
 func (s *Options) SetOps(ac *cobra.Command) {
-	ac.Flags().StringVar(&s.Image, "image", s.Image, "image")
+	ac.Flags().StringVar(&s.Kind, "kind", "service", "kind of the workload to deploy: service, configuration, deployment, statefulset or daemonset")
+	ac.Flags().StringArrayVar(&s.Images, "image", s.Images, "image ref for the --container container, or name=ref for a named container, can be repeated")
+	ac.Flags().StringVar(&s.Container, "container", s.Container, "name of the container to update, the first container when empty")
+	ac.Flags().StringVar(&s.Namespace, "namespace", "default", "namespace")
+	ac.Flags().StringVar(&s.ServiceName, "serivce-name", s.ServiceName, "Knative service name, or name of the --kind workload")
+	ac.Flags().StringVar(&s.Port, "port", s.Port, "port of the --container container, or of the first --image container, the template or Knative default is used when empty")
+	ac.Flags().StringVar(&s.ServiceTemplate, "service-template", s.ServiceTemplate, "Knative Service yaml template, placeholders: {{.Image}} {{.Namespace}} {{.ServiceName}} {{.Port}}")
+	ac.Flags().StringVar(&s.MergePolicy, "template-merge-policy", "image", "how --service-template is applied to an existing Service: image, merge or replace")
+	ac.Flags().StringArrayVar(&s.Env, "env", s.Env, "env NAME=VALUE of the --container container, or of the first --image container, can be repeated")
+	ac.Flags().StringArrayVar(&s.EnvFromSecret, "env-from-secret", s.EnvFromSecret, "secret name to load the env of the --container container from, or of the first --image container, can be repeated")
+	ac.Flags().StringVar(&s.MinScale, "min-scale", s.MinScale, "autoscaling.knative.dev/minScale of the revision")
+	ac.Flags().StringVar(&s.MaxScale, "max-scale", s.MaxScale, "autoscaling.knative.dev/maxScale of the revision")
+	ac.Flags().Int64Var(&s.Concurrency, "concurrency", -1, "container concurrency of the revision, negative means unset")
+	ac.Flags().BoolVar(&s.AllowMutableTags, "allow-mutable-tags", false, "deploy the image tag as is when it can't be resolved to a digest")
+	ac.Flags().StringArrayVar(&s.InsecureRegistries, "insecure-registry", s.InsecureRegistries, "registry accessed over plain http, can be repeated")
+	s.Retention.SetOps(ac)
+	ac.Flags().StringVar(&s.SmokeConfig, "smoke-config", s.SmokeConfig, "yaml file of the HTTP checks run against the tag URL of the new revision")
+	ac.Flags().StringVar(&s.IngressAddress, "ingress-address", s.IngressAddress, "send the smoke checks to this address with the tag host as Host header")
+	ac.Flags().BoolVar(&s.Promote, "promote", false, "send all the traffic to the new revision once it is ready and passed the checks")
+	ac.Flags().DurationVar(&s.ReadyTimeout, "ready-timeout", 5*time.Minute, "time to wait for the new revision to be ready")
+	ac.Flags().StringVar(&s.AnalysisConfig, "analysis-config", s.AnalysisConfig, "yaml file of the canary steps and the Prometheus metric checks run at each step")
+	ac.Flags().StringVar(&s.HooksConfig, "hooks-config", s.HooksConfig, "yaml file of the Jobs run before the deploy and after the new revision is ready")
+	s.Provenance.SetOps(ac)
+	ac.Flags().IntVar(&s.HistoryLimit, "history-limit", 20, "number of deploys kept in the <service>-deploy-history ConfigMap, 0 disables the history")
+	s.Environment.SetOps(ac)
+	s.Approval.SetOps(ac)
+	s.Freeze.SetOps(ac)
+	s.Lock.SetOps(ac)
+	s.GitOps.SetOps(ac)
+	s.DryRun.SetOps(ac)
+	s.Result.SetOps(ac)
+	s.Events.SetOps(ac)
+}
+
+// TraceOptions are the options of the trace command
+type TraceOptions struct {
+	Namespace string
+	Output    string
+}
+
+func (s *TraceOptions) SetOps(ac *cobra.Command) {
 	ac.Flags().StringVar(&s.Namespace, "namespace", "default", "namespace")
+	ac.Flags().StringVarP(&s.Output, "output", "o", "tree", "output format: tree or json")
+}
+
+// ApplyOptions are the options of the apply command
+type ApplyOptions struct {
+	File      string
+	Namespace string
+
+	AllowMutableTags   bool
+	InsecureRegistries []string
+	Retention          RetentionOptions
+	ReadyTimeout       time.Duration
+
+	Provenance   ProvenanceOptions
+	HistoryLimit int
+
+	Environment EnvironmentOptions
+	Approval    ApprovalOptions
+	Freeze      FreezeOptions
+	Lock        LockOptions
+	Result      ResultOptions
+	Events      EventOptions
+}
+
+func (s *ApplyOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVarP(&s.File, "file", "f", s.File, "release manifest listing the services, their images and dependencies")
+	ac.Flags().StringVar(&s.Namespace, "namespace", "default", "namespace of the services which don't set one")
+	ac.Flags().BoolVar(&s.AllowMutableTags, "allow-mutable-tags", false, "deploy the image tag as is when it can't be resolved to a digest")
+	ac.Flags().StringArrayVar(&s.InsecureRegistries, "insecure-registry", s.InsecureRegistries, "registry accessed over plain http, can be repeated")
+	s.Retention.SetOps(ac)
+	ac.Flags().DurationVar(&s.ReadyTimeout, "ready-timeout", 5*time.Minute, "time to wait for each service to be ready")
+	s.Provenance.SetOps(ac)
+	ac.Flags().IntVar(&s.HistoryLimit, "history-limit", 20, "number of deploys kept in the <service>-deploy-history ConfigMap, 0 disables the history")
+	s.Environment.SetOps(ac)
+	s.Approval.SetOps(ac)
+	s.Freeze.SetOps(ac)
+	s.Lock.SetOps(ac)
+	s.Result.SetOps(ac)
+	s.Events.SetOps(ac)
+}
+
+// PromoteEnvOptions are the options of the promote-env command
+type PromoteEnvOptions struct {
+	Config      string
+	From        string
+	To          string
+	Kind        string
+	Namespace   string
+	ServiceName string
+
+	AllowMutableTags bool
+	Retention        RetentionOptions
+	Promote          bool
+	ReadyTimeout     time.Duration
+	HooksConfig      string
+	HistoryLimit     int
+	Approval         ApprovalOptions
+	Freeze           FreezeOptions
+	Lock             LockOptions
+	Result           ResultOptions
+	Events           EventOptions
+}
+
+func (s *PromoteEnvOptions) SetOps(ac *cobra.Command) {
+	ac.Flags().StringVar(&s.Config, "environments-config", s.Config, "yaml file of the environments and their clusters, DEPLOYER_ENVIRONMENTS ENV when empty")
+	ac.Flags().StringVar(&s.From, "from", s.From, "environment the serving revision is read from")
+	ac.Flags().StringVar(&s.To, "to", s.To, "environment to deploy to, the one after --from when empty")
+	ac.Flags().StringVar(&s.Kind, "kind", "service", "kind of the workload in the --to environment: service, configuration, deployment, statefulset or daemonset")
+	ac.Flags().StringVar(&s.Namespace, "namespace", "default", "namespace of the environments which don't set one")
 	ac.Flags().StringVar(&s.ServiceName, "serivce-name", s.ServiceName, "Knative service name")
-	ac.Flags().StringVar(&s.Port, "port", "8080", "port")
+	ac.Flags().BoolVar(&s.AllowMutableTags, "allow-mutable-tags", false, "promote images which are not pinned to a digest")
+	s.Retention.SetOps(ac)
+	ac.Flags().BoolVar(&s.Promote, "promote", false, "send all the traffic to the new revision once it is ready")
+	ac.Flags().DurationVar(&s.ReadyTimeout, "ready-timeout", 5*time.Minute, "time to wait for the new revision to be ready")
+	ac.Flags().StringVar(&s.HooksConfig, "hooks-config", s.HooksConfig, "yaml file of the Jobs run before the deploy and after the new revision is ready")
+	ac.Flags().IntVar(&s.HistoryLimit, "history-limit", 20, "number of deploys kept in the <service>-deploy-history ConfigMap, 0 disables the history")
+	s.Approval.SetOps(ac)
+	s.Freeze.SetOps(ac)
+	s.Lock.SetOps(ac)
+	s.Result.SetOps(ac)
+	s.Events.SetOps(ac)
 }
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/knative-sample/tekton-serving/pkg/deployer"
+	"github.com/spf13/cobra"
+)
+
+// NewCommandPromoteEnv deploys the revision serving in one environment to the next one
+func NewCommandPromoteEnv() *cobra.Command {
+	ops := &options.PromoteEnvOptions{}
+	promoteCmd := &cobra.Command{
+		Use:   "promote-env",
+		Short: "Deploy the image digest serving in one environment to the next one",
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("NewCommandPromoteEnv main:%s", strings.Join(args, " "))
+			return runPromoteEnv(ops)
+		},
+	}
+
+	ops.SetOps(promoteCmd)
+	return promoteCmd
+}
+
+func runPromoteEnv(ops *options.PromoteEnvOptions) error {
+	if ops.ServiceName == "" {
+		glog.Fatalf("--service-name is empty")
+	}
+	if ops.From == "" {
+		glog.Fatalf("--from is empty")
+	}
+	if err := deployer.ValidKind(ops.Kind); err != nil {
+		glog.Fatalf("%s", err)
+	}
+
+	cfg := loadEnvironments(ops.Config)
+	from, err := cfg.Get(ops.From)
+	if err != nil {
+		glog.Fatalf("%s", err)
+	}
+	to, err := cfg.Next(ops.From)
+	if ops.To != "" {
+		to, err = cfg.Get(ops.To)
+	}
+	if err != nil {
+		glog.Fatalf("%s", err)
+	}
+
+	ns := namespace(ops.Namespace)
+	source := &deployer.Deployer{
+		Namespace:   ns,
+		ServiceName: ops.ServiceName,
+	}
+	useEnvironment(source, from)
+
+	dp := &deployer.Deployer{
+		Kind:             ops.Kind,
+		Namespace:        ns,
+		ServiceName:      ops.ServiceName,
+		Concurrency:      -1,
+		AllowMutableTags: ops.AllowMutableTags,
+		KeepRevisions:    ops.Retention.KeepRevisions,
+		DeleteRevisions:  ops.Retention.DeleteRevisions,
+		Promote:          ops.Promote,
+		ReadyTimeout:     ops.ReadyTimeout,
+		HistoryLimit:     ops.HistoryLimit,
+		RequireApproval:  ops.Approval.Require,
+		ApprovalTimeout:  ops.Approval.Timeout,
+		FreezePolicy:     ops.Freeze.Policy,
+		OverrideFreeze:   ops.Freeze.Override,
+		LockTimeout:      ops.Lock.Timeout,
+		AllowOlderBuild:  ops.Lock.AllowOlderBuild,
+		ResultFile:       ops.Result.File,
+		ResultsDir:       ops.Result.Dir,
+	}
+	dp.Events = newEmitter(ops.Events.Sink)
+	dp.Notifier = newNotifier(ops.Events.NotifyConfig)
+	if ops.HooksConfig != "" {
+		hooks, err := deployer.LoadHooksConfig(ops.HooksConfig)
+		if err != nil {
+			glog.Fatalf("load --hooks-config error:%s", err)
+		}
+		dp.Hooks = hooks
+	}
+	useEnvironment(dp, to)
+	return dp.PromoteFrom(source, to.Gates)
+}
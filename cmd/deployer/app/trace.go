@@ -0,0 +1,70 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/cmd/deployer/app/options"
+	"github.com/knative-sample/tekton-serving/pkg/trace"
+	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	"github.com/spf13/cobra"
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+)
+
+// NewCommandTrace follows a commit, a Service or a Revision through the pipeline to the traffic
+func NewCommandTrace() *cobra.Command {
+	ops := &options.TraceOptions{}
+	traceCmd := &cobra.Command{
+		Use:   "trace <commit|service|revision>",
+		Short: "Trace a commit, Knative Service or Revision from the GitHub event to the traffic",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			glog.V(2).Infof("NewCommandTrace main:%s", strings.Join(args, " "))
+			return runTrace(ops, args[0])
+		},
+	}
+
+	ops.SetOps(traceCmd)
+	return traceCmd
+}
+
+func runTrace(ops *options.TraceOptions, query string) error {
+	cfg, err := kube.GetKubeconfig()
+	if err != nil {
+		glog.Errorf("get kubeconfig error:%s ", err)
+		return err
+	}
+	servingClient, err := servingclientset.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building Serving clientset: %v", err)
+	}
+	tektonClient, err := tektonclientset.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building Build clientset: %v", err)
+	}
+
+	tracer := &trace.Tracer{
+		Namespace: namespace(ops.Namespace),
+		Serving:   servingClient,
+		Tekton:    tektonClient,
+	}
+	tr, err := tracer.Trace(query)
+	if err != nil {
+		return err
+	}
+
+	switch ops.Output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tr)
+	case "tree", "":
+		return trace.PrintTree(os.Stdout, tr)
+	default:
+		return fmt.Errorf("unknown --output %q, expected tree or json", ops.Output)
+	}
+}
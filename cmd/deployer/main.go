@@ -28,7 +28,7 @@ func main() {
 	glog.Flush()
 	// Start runner
 	cmd := app.NewCommandStartServer(stopCh)
-	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 	flag.CommandLine.Parse([]string{})
 
 	if err := cmd.Execute(); err != nil {
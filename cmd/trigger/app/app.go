@@ -36,7 +36,16 @@ func run(stopCh <-chan struct{}, ops *options.Options) {
 	}
 
 	tg := trigger.Trigger{
-		TriggerConfig: ops.TriggerConfig,
+		TriggerConfig:     ops.TriggerConfig,
+		Approvers:         ops.Approvers,
+		AllowSelfApproval: ops.AllowSelfApproval,
+		AdminAddress:      ops.AdminAddress,
+		AdminTokens:       ops.AdminTokens,
+		EventSink:         ops.EventSink,
+		NotifyConfig:      ops.NotifyConfig,
+		RetryConfig:       ops.RetryConfig,
+		ChatOpsConfig:     ops.ChatOpsConfig,
+		GitHubAPI:         ops.GitHubAPI,
 	}
 
 	go func() {
@@ -5,9 +5,27 @@ import (
 )
 
 type Options struct {
-	TriggerConfig string
+	TriggerConfig     string
+	Approvers         []string
+	AllowSelfApproval bool
+	AdminAddress      string
+	AdminTokens       string
+	EventSink         string
+	NotifyConfig      string
+	RetryConfig       string
+	ChatOpsConfig     string
+	GitHubAPI         string
 }
 
 func (s *Options) SetOps(ac *cobra.Command) {
 	ac.Flags().StringVar(&s.TriggerConfig, "trigger-config", s.TriggerConfig, "trigger config")
+	ac.Flags().StringSliceVar(&s.Approvers, "approvers", s.Approvers, "GitHub users allowed to /approve deploys, the owners, members and collaborators of the repository when empty")
+	ac.Flags().BoolVar(&s.AllowSelfApproval, "allow-self-approval", s.AllowSelfApproval, "let the author of a pull request /approve its own deploys")
+	ac.Flags().StringVar(&s.AdminAddress, "admin-address", s.AdminAddress, "address of the approvals http endpoint, such as :8081, disabled when empty")
+	ac.Flags().StringVar(&s.AdminTokens, "admin-tokens", s.AdminTokens, "file of user=token lines authenticating the approvals http endpoint")
+	ac.Flags().StringVar(&s.EventSink, "event-sink", s.EventSink, "URL of the broker or service the pipelinerun.created CloudEvents are sent to, K_SINK ENV when empty")
+	ac.Flags().StringVar(&s.NotifyConfig, "notify-config", s.NotifyConfig, "yaml file of the Slack, DingTalk, Teams and webhook routes the builds are posted to")
+	ac.Flags().StringVar(&s.RetryConfig, "retry-config", s.RetryConfig, "yaml file of the policy retrying the PipelineRuns which fail for infrastructure reasons, no retry when empty")
+	ac.Flags().StringVar(&s.ChatOpsConfig, "chatops-config", s.ChatOpsConfig, "yaml file of the /retest, /deploy, /rollback and /cancel comment commands, disabled when empty. /deploy and /rollback on an issue use the head of the default branch, /retest and /cancel only run on pull requests")
+	ac.Flags().StringVar(&s.GitHubAPI, "github-api-url", s.GitHubAPI, "base URL of the GitHub API acknowledging the commands, https://api.github.com when empty")
 }
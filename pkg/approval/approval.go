@@ -0,0 +1,208 @@
+package approval
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// Label marks the approval requests, its value is the Service
+	Label = provenance.GroupName + "/approval"
+
+	// StateKey is the annotation holding the decision, a human approves a request with
+	// kubectl annotate configmap <name> tekton-serving.dev/approval-state=approved \
+	//   tekton-serving.dev/approved-by=<who> --overwrite
+	StateKey = provenance.GroupName + "/approval-state"
+	// ByKey is the annotation naming who decided
+	ByKey = provenance.GroupName + "/approved-by"
+	// SourceKey is the annotation telling how the decision was made
+	SourceKey = provenance.GroupName + "/approval-source"
+	// ReasonKey is the annotation holding the reason of the decision
+	ReasonKey = provenance.GroupName + "/approval-reason"
+	// TimeKey is the annotation holding the time of the decision
+	TimeKey = provenance.GroupName + "/approval-time"
+
+	StatePending  = "pending"
+	StateApproved = "approved"
+	StateRejected = "rejected"
+	StateExpired  = "expired"
+
+	SourceAnnotation = "annotation"
+	SourceComment    = "comment"
+	SourceHTTP       = "http"
+)
+
+// Request is what is asked to be approved
+type Request struct {
+	Namespace   string
+	Service     string
+	Environment string
+	// Images are the images to deploy, as container=image
+	Images []string
+	// Diff is the change of the images, one line per container
+	Diff       string
+	Provenance provenance.Provenance
+}
+
+// Decision is the outcome of an approval request
+type Decision struct {
+	State  string    `json:"state"`
+	By     string    `json:"by,omitempty"`
+	Source string    `json:"source,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+}
+
+// Create creates the approval request ConfigMap in the namespace of the Service, the
+// random suffix of its name keeps two requests of the same second apart
+func Create(client kubernetes.Interface, req *Request) (*corev1.ConfigMap, error) {
+	images, err := json.Marshal(req.Images)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{Label: req.Service}
+	for k, v := range req.Provenance.Labels() {
+		labels[k] = v
+	}
+	annotations := req.Provenance.Annotations()
+	annotations[StateKey] = StatePending
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-approval-%d-%s", req.Service, time.Now().Unix(), utilrand.String(5)),
+			Namespace:   req.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Data: map[string]string{
+			"service":     req.Service,
+			"environment": req.Environment,
+			"images":      string(images),
+			"diff":        req.Diff,
+			"commit":      req.Provenance.Commit,
+			"pullRequest": req.Provenance.PullRequest,
+		},
+	}
+	return client.CoreV1().ConfigMaps(req.Namespace).Create(cm)
+}
+
+// DecisionOf reads the decision of an approval request
+func DecisionOf(cm *corev1.ConfigMap) Decision {
+	d := Decision{
+		State:  cm.Annotations[StateKey],
+		By:     cm.Annotations[ByKey],
+		Source: cm.Annotations[SourceKey],
+		Reason: cm.Annotations[ReasonKey],
+	}
+	if d.State == "" {
+		d.State = StatePending
+	}
+	if d.State != StatePending && d.Source == "" {
+		d.Source = SourceAnnotation
+	}
+	if t, err := time.Parse(time.RFC3339, cm.Annotations[TimeKey]); err == nil {
+		d.Time = t
+	}
+	return d
+}
+
+// Decide records the decision on a pending request, deciding a request twice fails
+func Decide(client kubernetes.Interface, namespace, name string, d Decision) error {
+	if d.Time.IsZero() {
+		d.Time = time.Now()
+	}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cm, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if _, ok := cm.Labels[Label]; !ok {
+			return fmt.Errorf("configmap %s/%s is not an approval request", namespace, name)
+		}
+		if current := DecisionOf(cm); current.State != StatePending {
+			return fmt.Errorf("approval %s/%s is already %s by %s", namespace, name, current.State, current.By)
+		}
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Annotations[StateKey] = d.State
+		cm.Annotations[ByKey] = d.By
+		cm.Annotations[SourceKey] = d.Source
+		cm.Annotations[ReasonKey] = d.Reason
+		cm.Annotations[TimeKey] = d.Time.UTC().Format(time.RFC3339)
+		_, err = client.CoreV1().ConfigMaps(namespace).Update(cm)
+		return err
+	})
+}
+
+// Wait polls the request until it is decided. A request still pending after the timeout
+// is marked expired.
+func Wait(client kubernetes.Interface, namespace, name string, timeout time.Duration) (*Decision, error) {
+	var decision Decision
+	err := wait.PollImmediate(5*time.Second, timeout, func() (bool, error) {
+		cm, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		decision = DecisionOf(cm)
+		return decision.State != StatePending, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		decision = Decision{State: StateExpired, Reason: fmt.Sprintf("not approved within %s", timeout)}
+		if err := Decide(client, namespace, name, decision); err != nil {
+			glog.Warningf("expire approval %s/%s error:%s", namespace, name, err)
+		}
+		return &decision, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// Pending returns the pending requests of the namespace, of every namespace when it is empty
+func Pending(client kubernetes.Interface, namespace string) ([]corev1.ConfigMap, error) {
+	list, err := client.CoreV1().ConfigMaps(namespace).List(metav1.ListOptions{LabelSelector: Label})
+	if err != nil {
+		return nil, err
+	}
+	pending := []corev1.ConfigMap{}
+	for _, cm := range list.Items {
+		if DecisionOf(&cm).State == StatePending {
+			pending = append(pending, cm)
+		}
+	}
+	return pending, nil
+}
+
+// ForPullRequest returns the pending requests of the deploys of the pull request
+func ForPullRequest(client kubernetes.Interface, pullRequest string) ([]corev1.ConfigMap, error) {
+	pending, err := Pending(client, "")
+	if err != nil {
+		return nil, err
+	}
+	matched := []corev1.ConfigMap{}
+	for _, cm := range pending {
+		if pullRequest != "" && strings.TrimSuffix(cm.Data["pullRequest"], "/") == strings.TrimSuffix(pullRequest, "/") {
+			matched = append(matched, cm)
+		}
+	}
+	return matched, nil
+}
+
+// IsNotFound tells the approval request doesn't exist
+func IsNotFound(err error) bool {
+	return errors.IsNotFound(err)
+}
@@ -0,0 +1,35 @@
+package approval
+
+import (
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCreateTwiceInASecond(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	req := &Request{Namespace: "default", Service: "app", Images: []string{"app=app:v2"}}
+
+	first, err := Create(client, req)
+	if err != nil {
+		t.Fatalf("create approval error:%s", err)
+	}
+	second, err := Create(client, req)
+	if err != nil {
+		t.Fatalf("create a second approval error:%s", err)
+	}
+	if first.Name == second.Name {
+		t.Errorf("both approvals are named %s", first.Name)
+	}
+	if d := DecisionOf(second); d.State != StatePending {
+		t.Errorf("decision = %+v, want pending", d)
+	}
+
+	pending, err := Pending(client, "default")
+	if err != nil {
+		t.Fatalf("list approvals error:%s", err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("%d pending approvals, want 2", len(pending))
+	}
+}
@@ -0,0 +1,216 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+// Verdict is the result of the analysis of a canary step
+type Verdict string
+
+const (
+	VerdictPass         Verdict = "pass"
+	VerdictFail         Verdict = "fail"
+	VerdictInconclusive Verdict = "inconclusive"
+)
+
+// Analyzer judges the canary revision during a canary step
+type Analyzer interface {
+	Analyze(canary, stable string) (Verdict, error)
+}
+
+// AnalysisConfig is the --analysis-config file
+type AnalysisConfig struct {
+	// Prometheus is the address of the Prometheus compatible HTTP API
+	Prometheus string `json:"prometheus"`
+	// Steps are the traffic percents of the canary, such as [10, 50]
+	Steps []int `json:"steps"`
+	// Interval is the time the canary serves a step before it is analyzed, 1m by default
+	Interval string `json:"interval,omitempty"`
+	// InconclusiveLimit is the number of inconclusive analyses tolerated per step
+	// before the canary fails, 0 by default
+	InconclusiveLimit int           `json:"inconclusiveLimit,omitempty"`
+	Metrics           []MetricCheck `json:"metrics"`
+}
+
+// MetricCheck is one PromQL query compared with thresholds. The query is a template
+// with {{.Revision}}, {{.Namespace}} and {{.Service}}.
+type MetricCheck struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Max and Min are absolute thresholds
+	Max *float64 `json:"max,omitempty"`
+	Min *float64 `json:"min,omitempty"`
+	// MaxStableRatio fails the canary when its value is above the stable value times the ratio
+	MaxStableRatio *float64 `json:"maxStableRatio,omitempty"`
+}
+
+// LoadAnalysisConfig reads an AnalysisConfig yaml file
+func LoadAnalysisConfig(path string) (*AnalysisConfig, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &AnalysisConfig{}
+	if err := yaml.Unmarshal(bts, cfg); err != nil {
+		return nil, fmt.Errorf("parse analysis config %s error:%s", path, err)
+	}
+	for _, step := range cfg.Steps {
+		if step <= 0 || step >= 100 {
+			return nil, fmt.Errorf("analysis config %s: canary step %d is not between 1 and 99", path, step)
+		}
+	}
+	return cfg, nil
+}
+
+// interval is the time a step is served before it is analyzed
+func (cfg *AnalysisConfig) interval() (time.Duration, error) {
+	if cfg.Interval == "" {
+		return time.Minute, nil
+	}
+	return time.ParseDuration(cfg.Interval)
+}
+
+// PrometheusAnalyzer runs the metric checks against a Prometheus compatible HTTP API
+type PrometheusAnalyzer struct {
+	Address   string
+	Namespace string
+	Service   string
+	Metrics   []MetricCheck
+	Client    *http.Client
+}
+
+// queryArgs are the values available to the PromQL templates
+type queryArgs struct {
+	Revision  string
+	Namespace string
+	Service   string
+}
+
+// Analyze fails when any metric fails, and is inconclusive when no metric fails but
+// one of them has no data
+func (a *PrometheusAnalyzer) Analyze(canary, stable string) (Verdict, error) {
+	verdict := VerdictPass
+	for _, m := range a.Metrics {
+		value, ok, err := a.query(m, canary)
+		if err != nil {
+			return VerdictInconclusive, err
+		}
+		if !ok {
+			glog.Warningf("metric %s of revision %s has no data", m.Name, canary)
+			verdict = VerdictInconclusive
+			continue
+		}
+
+		if m.Max != nil && value > *m.Max {
+			glog.Warningf("metric %s of revision %s is %v, above %v", m.Name, canary, value, *m.Max)
+			return VerdictFail, nil
+		}
+		if m.Min != nil && value < *m.Min {
+			glog.Warningf("metric %s of revision %s is %v, below %v", m.Name, canary, value, *m.Min)
+			return VerdictFail, nil
+		}
+		if m.MaxStableRatio != nil && stable != "" {
+			stableValue, ok, err := a.query(m, stable)
+			if err != nil {
+				return VerdictInconclusive, err
+			}
+			if !ok {
+				glog.Warningf("metric %s of stable revision %s has no data", m.Name, stable)
+				verdict = VerdictInconclusive
+				continue
+			}
+			if value > stableValue*(*m.MaxStableRatio) {
+				glog.Warningf("metric %s of revision %s is %v, above %v times the stable %v", m.Name, canary, value, *m.MaxStableRatio, stableValue)
+				return VerdictFail, nil
+			}
+		}
+		glog.Infof("metric %s of revision %s is %v", m.Name, canary, value)
+	}
+	return verdict, nil
+}
+
+// query runs the instant query of the metric for the revision, it returns false when the
+// result is empty or not a number
+func (a *PrometheusAnalyzer) query(m MetricCheck, revision string) (float64, bool, error) {
+	tmpl, err := template.New(m.Name).Parse(m.Query)
+	if err != nil {
+		return 0, false, fmt.Errorf("parse query of metric %s error:%s", m.Name, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, &queryArgs{Revision: revision, Namespace: a.Namespace, Service: a.Service}); err != nil {
+		return 0, false, fmt.Errorf("render query of metric %s error:%s", m.Name, err)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	u := strings.TrimSuffix(a.Address, "/") + "/api/v1/query?" + url.Values{"query": {buf.String()}}.Encode()
+	resp, err := client.Get(u)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	result := &struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return 0, false, fmt.Errorf("decode query of metric %s error:%s", m.Name, err)
+	}
+	if result.Status != "success" {
+		return 0, false, fmt.Errorf("query of metric %s failed: %s %s", m.Name, resp.Status, result.Error)
+	}
+
+	// a scalar is [ts, "value"], a vector is [{"metric":{}, "value":[ts, "value"]}]
+	var sample []interface{}
+	switch result.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(result.Data.Result, &sample); err != nil {
+			return 0, false, err
+		}
+	case "vector":
+		vector := []struct {
+			Value []interface{} `json:"value"`
+		}{}
+		if err := json.Unmarshal(result.Data.Result, &vector); err != nil {
+			return 0, false, err
+		}
+		if len(vector) > 0 {
+			sample = vector[0].Value
+		}
+	default:
+		return 0, false, fmt.Errorf("query of metric %s returned a %s, expected a vector or a scalar", m.Name, result.Data.ResultType)
+	}
+	if len(sample) != 2 {
+		return 0, false, nil
+	}
+
+	str, ok := sample[1].(string)
+	if !ok {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false, nil
+	}
+	return value, true, nil
+}
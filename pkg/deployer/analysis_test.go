@@ -0,0 +1,127 @@
+package deployer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakePrometheus answers the instant queries with the values of the map, a query missing
+// from the map gets an empty vector and a "scalar:" value is answered as a scalar
+func fakePrometheus(t *testing.T, values map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query().Get("query")
+		if query == "broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","error":"parse error"}`)
+			return
+		}
+		v, ok := values[query]
+		switch {
+		case !ok:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		case len(v) > 7 && v[:7] == "scalar:":
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"scalar","result":[1600000000,%q]}}`, v[7:])
+		default:
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1600000000,%q]}]}}`, v)
+		}
+	}))
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestPrometheusAnalyzer(t *testing.T) {
+	errors := MetricCheck{Name: "errors", Query: `errors{namespace="{{.Namespace}}",service="{{.Service}}",revision="{{.Revision}}"}`, Max: float(0.05)}
+	latency := MetricCheck{Name: "latency", Query: `latency{revision="{{.Revision}}"}`, MaxStableRatio: float(1.5)}
+	rps := MetricCheck{Name: "rps", Query: `rps{revision="{{.Revision}}"}`, Min: float(1)}
+
+	cases := []struct {
+		name    string
+		metrics []MetricCheck
+		values  map[string]string
+		stable  string
+		want    Verdict
+		wantErr bool
+	}{{
+		name:    "pass",
+		metrics: []MetricCheck{errors, rps},
+		values: map[string]string{
+			`errors{namespace="default",service="app",revision="app-2"}`: "0.01",
+			`rps{revision="app-2"}`: "scalar:12",
+		},
+		want: VerdictPass,
+	}, {
+		name:    "fail above max",
+		metrics: []MetricCheck{errors},
+		values:  map[string]string{`errors{namespace="default",service="app",revision="app-2"}`: "0.2"},
+		want:    VerdictFail,
+	}, {
+		name:    "fail below min",
+		metrics: []MetricCheck{rps},
+		values:  map[string]string{`rps{revision="app-2"}`: "0.5"},
+		want:    VerdictFail,
+	}, {
+		name:    "inconclusive without data",
+		metrics: []MetricCheck{errors},
+		want:    VerdictInconclusive,
+	}, {
+		name:    "inconclusive on NaN",
+		metrics: []MetricCheck{errors},
+		values:  map[string]string{`errors{namespace="default",service="app",revision="app-2"}`: "NaN"},
+		want:    VerdictInconclusive,
+	}, {
+		name:    "fail wins over inconclusive",
+		metrics: []MetricCheck{rps, errors},
+		values:  map[string]string{`errors{namespace="default",service="app",revision="app-2"}`: "0.2"},
+		want:    VerdictFail,
+	}, {
+		name:    "within the stable ratio",
+		metrics: []MetricCheck{latency},
+		values:  map[string]string{`latency{revision="app-2"}`: "140", `latency{revision="app-1"}`: "100"},
+		stable:  "app-1",
+		want:    VerdictPass,
+	}, {
+		name:    "above the stable ratio",
+		metrics: []MetricCheck{latency},
+		values:  map[string]string{`latency{revision="app-2"}`: "160", `latency{revision="app-1"}`: "100"},
+		stable:  "app-1",
+		want:    VerdictFail,
+	}, {
+		name:    "stable without data",
+		metrics: []MetricCheck{latency},
+		values:  map[string]string{`latency{revision="app-2"}`: "160"},
+		stable:  "app-1",
+		want:    VerdictInconclusive,
+	}, {
+		name:    "no stable revision",
+		metrics: []MetricCheck{latency},
+		values:  map[string]string{`latency{revision="app-2"}`: "160"},
+		want:    VerdictPass,
+	}, {
+		name:    "query error",
+		metrics: []MetricCheck{{Name: "broken", Query: "broken", Max: float(1)}},
+		want:    VerdictInconclusive,
+		wantErr: true,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := fakePrometheus(t, c.values)
+			defer srv.Close()
+			a := &PrometheusAnalyzer{Address: srv.URL + "/", Namespace: "default", Service: "app", Metrics: c.metrics}
+			got, err := a.Analyze("app-2", c.stable)
+			if c.wantErr != (err != nil) {
+				t.Fatalf("Analyze error:%v, want an error: %v", err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("Analyze = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/approval"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// approve asks for the approval of the deploy when RequireApproval is set and waits for
+// the decision, the deploy only goes on once it is approved
+func (dp *Deployer) approve(kubeClient kubernetes.Interface, target Target, rel *release) error {
+	if !dp.RequireApproval {
+		return nil
+	}
+	current, err := target.Get()
+	if err != nil && !apierrors.IsNotFound(err) {
+		glog.Errorf("get %s %s/%s error:%s", dp.kind(), dp.Namespace, dp.ServiceName, err)
+		return err
+	}
+
+	req := &approval.Request{
+		Namespace:   dp.Namespace,
+		Service:     dp.ServiceName,
+		Environment: dp.Environment,
+		Images:      []string{},
+		Diff:        imagesDiff(current, dp.Images),
+		Provenance:  dp.Provenance,
+	}
+	for _, ci := range dp.Images {
+		if ci.Container == "" {
+			req.Images = append(req.Images, ci.Image)
+			continue
+		}
+		req.Images = append(req.Images, ci.Container+"="+ci.Image)
+	}
+	cm, err := approval.Create(kubeClient, req)
+	if err != nil {
+		glog.Errorf("create approval request of %s/%s error:%s", dp.Namespace, dp.ServiceName, err)
+		return err
+	}
+
+	timeout := dp.ApprovalTimeout
+	if timeout <= 0 {
+		timeout = time.Hour
+	}
+	glog.Infof("waiting up to %s for the approval of %s/%s:\n%s", timeout, cm.Namespace, cm.Name, req.Diff)
+	decision, err := approval.Wait(kubeClient, cm.Namespace, cm.Name, timeout)
+	if err != nil {
+		glog.Errorf("wait for approval %s/%s error:%s", cm.Namespace, cm.Name, err)
+		return err
+	}
+	rel.approval = decision
+
+	switch decision.State {
+	case approval.StateApproved:
+		glog.Infof("approval %s/%s approved by %s through %s", cm.Namespace, cm.Name, decision.By, decision.Source)
+		return nil
+	case approval.StateExpired:
+		return fmt.Errorf("approval %s/%s expired: %s", cm.Namespace, cm.Name, decision.Reason)
+	default:
+		msg := fmt.Sprintf("approval %s/%s %s by %s", cm.Namespace, cm.Name, decision.State, decision.By)
+		if decision.Reason != "" {
+			msg += ": " + decision.Reason
+		}
+		return fmt.Errorf("%s", msg)
+	}
+}
+
+// imagesDiff shows the change of the image of each container
+func imagesDiff(current, images []ContainerImage) string {
+	old := map[string]string{}
+	for _, ci := range current {
+		old[ci.Container] = ci.Image
+	}
+	lines := []string{}
+	for _, ci := range images {
+		name := ci.Container
+		from, ok := old[name]
+		if name == "" && len(current) > 0 {
+			// the first container
+			name, from, ok = current[0].Container, current[0].Image, true
+		}
+		switch {
+		case !ok:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", name, ci.Image))
+		case from == ci.Image:
+			lines = append(lines, fmt.Sprintf("  %s: %s", name, ci.Image))
+		default:
+			lines = append(lines, fmt.Sprintf("- %s: %s", name, from), fmt.Sprintf("+ %s: %s", name, ci.Image))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,149 @@
+package deployer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// configurationTarget is a Knative Configuration without a Route, each update stamps a
+// new named revision
+type configurationTarget struct {
+	dp       *Deployer
+	client   servingclientset.Interface
+	rel      *release
+	previous *v1alpha1.RevisionTemplateSpec
+}
+
+func (t *configurationTarget) get() (*v1alpha1.Configuration, error) {
+	return t.client.ServingV1alpha1().Configurations(t.dp.Namespace).Get(t.dp.ServiceName, metav1.GetOptions{})
+}
+
+func (t *configurationTarget) Get() ([]ContainerImage, error) {
+	cfg, err := t.get()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Spec.Template == nil {
+		return []ContainerImage{}, nil
+	}
+	return containerImages(cfg.Spec.Template.Spec.Containers), nil
+}
+
+func (t *configurationTarget) Provenance() (provenance.Provenance, error) {
+	cfg, err := t.get()
+	if err != nil || cfg.Spec.Template == nil {
+		return provenance.Provenance{}, err
+	}
+	return provenance.FromAnnotations(cfg.Spec.Template.Annotations), nil
+}
+
+func (t *configurationTarget) UpdateImage(images []ContainerImage) error {
+	t.dp.Images = images
+	return t.patch(func(cfg *v1alpha1.Configuration) error {
+		if cfg.Spec.Template != nil {
+			t.previous = cfg.Spec.Template.DeepCopy()
+		}
+		if err := t.mutate(cfg); err != nil {
+			return err
+		}
+		t.rel.Revision = cfg.Spec.Template.Name
+		return nil
+	})
+}
+
+// mutate stamps a new named revision with the images on the template
+func (t *configurationTarget) mutate(cfg *v1alpha1.Configuration) error {
+	dp := t.dp
+	if cfg.Spec.Template == nil {
+		return fmt.Errorf("configuration %s/%s has no template", dp.Namespace, dp.ServiceName)
+	}
+	rt := cfg.Spec.Template
+	if rt.Annotations == nil {
+		rt.Annotations = map[string]string{}
+	}
+	rt.Annotations["updated"] = fmt.Sprintf("%v", time.Now().Unix())
+	if err := dp.setImages(rt.Spec.Containers); err != nil {
+		return err
+	}
+	if err := dp.applyOverrides(rt); err != nil {
+		return err
+	}
+	dp.stampProvenance(&rt.ObjectMeta)
+	rt.Name = fmt.Sprintf("%s-%v", dp.ServiceName, time.Now().Unix())
+	return nil
+}
+
+// DryRun computes the Configuration the deploy would write
+func (t *configurationTarget) DryRun(images []ContainerImage) (*Plan, error) {
+	t.dp.Images = images
+	cfg, err := t.get()
+	if err != nil {
+		return nil, err
+	}
+	desired := cfg.DeepCopy()
+	if err := t.mutate(desired); err != nil {
+		return nil, err
+	}
+	plan, err := newPlan(t.dp.kind(), cfg, desired)
+	if err != nil {
+		return nil, err
+	}
+	return plan, t.dp.serverDryRun(plan, t.client.ServingV1alpha1().RESTClient(), "configurations", cfg, desired)
+}
+
+func (t *configurationTarget) patch(mutate func(cfg *v1alpha1.Configuration) error) error {
+	configurations := t.client.ServingV1alpha1().Configurations(t.dp.Namespace)
+	return patchObject(func() (metav1.Object, error) {
+		return t.get()
+	}, func(obj metav1.Object) error {
+		return mutate(obj.(*v1alpha1.Configuration))
+	}, func(data []byte) error {
+		_, err := configurations.Patch(t.dp.ServiceName, types.MergePatchType, data)
+		return err
+	})
+}
+
+// WaitReady waits until the new revision is the latest ready revision
+func (t *configurationTarget) WaitReady(timeout time.Duration) error {
+	dp, revision := t.dp, t.rel.Revision
+	err := wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		cfg, err := t.get()
+		if err != nil {
+			return false, err
+		}
+		if cfg.Status.ObservedGeneration < cfg.Generation || cfg.Status.LatestCreatedRevisionName != revision {
+			return false, nil
+		}
+		if cond := cfg.Status.GetCondition(v1alpha1.ConfigurationConditionReady); cond != nil && cond.IsFalse() {
+			return false, fmt.Errorf("configuration %s/%s failed: %s %s", dp.Namespace, dp.ServiceName, cond.Reason, cond.Message)
+		}
+		return cfg.Status.LatestReadyRevisionName == revision, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("revision %s of configuration %s/%s is not ready after %s", revision, dp.Namespace, dp.ServiceName, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	glog.Infof("revision %s of configuration %s/%s is ready", revision, dp.Namespace, dp.ServiceName)
+	return nil
+}
+
+// Rollback restores the previous template, the previous revision is ready again
+func (t *configurationTarget) Rollback() error {
+	if t.previous == nil {
+		return ErrNoRollback
+	}
+	return t.patch(func(cfg *v1alpha1.Configuration) error {
+		cfg.Spec.Template = t.previous.DeepCopy()
+		return nil
+	})
+}
@@ -0,0 +1,118 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerImage is the image to deploy to one container of the revision. An empty
+// Container means the first container.
+type ContainerImage struct {
+	Container string `json:"container,omitempty"`
+	Image     string `json:"image"`
+}
+
+// ParseImages parses the --image values. A value is either an image reference, which
+// is deployed to the --container container, or name=ref for a named container.
+func ParseImages(values []string, container string) ([]ContainerImage, error) {
+	images := make([]ContainerImage, 0, len(values))
+	seen := map[string]bool{}
+	for _, v := range values {
+		ci := ContainerImage{Container: container, Image: v}
+		if parts := strings.SplitN(v, "=", 2); len(parts) == 2 {
+			if parts[0] == "" || parts[1] == "" {
+				return nil, fmt.Errorf("invalid --image %q, expected ref or name=ref", v)
+			}
+			ci = ContainerImage{Container: parts[0], Image: parts[1]}
+		}
+		if seen[ci.Container] {
+			if ci.Container == "" {
+				return nil, fmt.Errorf("more than one --image without a container name")
+			}
+			return nil, fmt.Errorf("container %q is given more than one --image", ci.Container)
+		}
+		seen[ci.Container] = true
+		images = append(images, ci)
+	}
+	return images, nil
+}
+
+// primaryImage is the image of the first --image, it is the one exposed to templates
+func (dp *Deployer) primaryImage() string {
+	if len(dp.Images) == 0 {
+		return ""
+	}
+	return dp.Images[0].Image
+}
+
+// primaryContainer is the container the env, port and other overrides are applied to
+func (dp *Deployer) primaryContainer() string {
+	if dp.Container != "" || len(dp.Images) == 0 {
+		return dp.Container
+	}
+	return dp.Images[0].Container
+}
+
+// newContainers builds the containers of a Service created without a template
+func (dp *Deployer) newContainers() []corev1.Container {
+	containers := make([]corev1.Container, 0, len(dp.Images))
+	for _, ci := range dp.Images {
+		containers = append(containers, corev1.Container{Name: ci.Container})
+	}
+	if len(containers) == 0 {
+		containers = append(containers, corev1.Container{})
+	}
+	return containers
+}
+
+// setImages writes the images to the containers of a pod or revision template. Every
+// named container must exist in the template.
+func (dp *Deployer) setImages(containers []corev1.Container) error {
+	for _, ci := range dp.Images {
+		idx, err := dp.containerIndex(containers, ci.Container)
+		if err != nil {
+			return err
+		}
+		containers[idx].Image = ci.Image
+	}
+	return nil
+}
+
+// containerImages returns the image of every container
+func containerImages(containers []corev1.Container) []ContainerImage {
+	images := make([]ContainerImage, 0, len(containers))
+	for _, c := range containers {
+		images = append(images, ContainerImage{Container: c.Name, Image: c.Image})
+	}
+	return images
+}
+
+// containerIndex finds the container by name, an empty name is the first container
+func (dp *Deployer) containerIndex(containers []corev1.Container, name string) (int, error) {
+	if len(containers) == 0 {
+		return -1, fmt.Errorf("%s %s/%s has no container", dp.kind(), dp.Namespace, dp.ServiceName)
+	}
+	if name == "" {
+		return 0, nil
+	}
+	for i, c := range containers {
+		if c.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("container %q not found in %s %s/%s, available containers: %s", name, dp.kind(), dp.Namespace, dp.ServiceName, strings.Join(containerNames(containers), ", "))
+}
+
+func containerNames(containers []corev1.Container) []string {
+	names := make([]string, 0, len(containers))
+	for i, c := range containers {
+		if c.Name == "" {
+			names = append(names, fmt.Sprintf("[%d] (unnamed)", i))
+			continue
+		}
+		names = append(names, c.Name)
+	}
+	return names
+}
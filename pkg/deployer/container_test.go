@@ -0,0 +1,120 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseImages(t *testing.T) {
+	cases := []struct {
+		name      string
+		values    []string
+		container string
+		want      []ContainerImage
+		wantErr   string
+	}{{
+		name:   "image of the first container",
+		values: []string{"registry.example.com/app:v1"},
+		want:   []ContainerImage{{Image: "registry.example.com/app:v1"}},
+	}, {
+		name:      "image of --container",
+		values:    []string{"registry.example.com/app:v1"},
+		container: "app",
+		want:      []ContainerImage{{Container: "app", Image: "registry.example.com/app:v1"}},
+	}, {
+		name:   "named containers",
+		values: []string{"app=registry.example.com/app:v1", "proxy=envoy:1.12"},
+		want:   []ContainerImage{{Container: "app", Image: "registry.example.com/app:v1"}, {Container: "proxy", Image: "envoy:1.12"}},
+	}, {
+		name:      "--container and a named container",
+		values:    []string{"registry.example.com/app:v1", "proxy=envoy:1.12"},
+		container: "app",
+		want:      []ContainerImage{{Container: "app", Image: "registry.example.com/app:v1"}, {Container: "proxy", Image: "envoy:1.12"}},
+	}, {
+		name:    "empty name",
+		values:  []string{"=envoy:1.12"},
+		wantErr: "expected ref or name=ref",
+	}, {
+		name:    "empty ref",
+		values:  []string{"proxy="},
+		wantErr: "expected ref or name=ref",
+	}, {
+		name:    "two images of the first container",
+		values:  []string{"app:v1", "app:v2"},
+		wantErr: "more than one --image without a container name",
+	}, {
+		name:    "two images of a container",
+		values:  []string{"proxy=envoy:1.12", "proxy=envoy:1.13"},
+		wantErr: `container "proxy" is given more than one --image`,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseImages(c.values, c.container)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("ParseImages error:%v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseImages error:%s", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Errorf("ParseImages = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestContainerIndex(t *testing.T) {
+	dp := &Deployer{Namespace: "default", ServiceName: "app"}
+	containers := []corev1.Container{{Name: "app"}, {}, {Name: "proxy"}}
+	cases := []struct {
+		name       string
+		containers []corev1.Container
+		container  string
+		want       int
+		wantErr    string
+	}{{
+		name:       "first container when no name is given",
+		containers: containers,
+		want:       0,
+	}, {
+		name:       "single container",
+		containers: containers[:1],
+		want:       0,
+	}, {
+		name:       "by name",
+		containers: containers,
+		container:  "proxy",
+		want:       2,
+	}, {
+		name:       "unknown name lists the containers",
+		containers: containers,
+		container:  "db",
+		wantErr:    `container "db" not found in service default/app, available containers: app, [1] (unnamed), proxy`,
+	}, {
+		name:    "no container",
+		wantErr: "service default/app has no container",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := dp.containerIndex(c.containers, c.container)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Fatalf("containerIndex error:%v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("containerIndex error:%s", err)
+			}
+			if got != c.want {
+				t.Errorf("containerIndex = %d, want %d", got, c.want)
+			}
+		})
+	}
+}
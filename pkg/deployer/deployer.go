@@ -1,38 +1,287 @@
 package deployer
 
 import (
+	"fmt"
+	"io"
+	"time"
+
 	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"github.com/knative-sample/tekton-serving/pkg/lock"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
 	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
 	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
-	"github.com/knative/serving/pkg/apis/serving/v1beta1"
 	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"time"
-	"fmt"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type Deployer struct {
-	Image       string
+	// Kind is the kind of workload deployed, one of Kinds, a Knative Service when empty.
+	// The template, scale and traffic options only apply to Knative Services.
+	Kind string
+	// Images are the images to deploy, one per container
+	Images []ContainerImage
+	// Container is the container the overrides are applied to, empty means the first one
+	Container   string
 	Namespace   string
 	ServiceName string
 	Port        string
+
+	// ServiceTemplate is the path of a Knative Service yaml used as the base of the Service
+	ServiceTemplate string
+	// MergePolicy decides how ServiceTemplate is reconciled with an existing Service
+	MergePolicy   string
+	Env           []string
+	EnvFromSecret []string
+	MinScale      string
+	MaxScale      string
+	// Concurrency is the container concurrency of the revision, negative means unset
+	Concurrency int64
+
+	// AllowMutableTags deploys the tag as is when it can't be resolved to a digest
+	AllowMutableTags bool
+	// InsecureRegistries are the registries accessed over plain http
+	InsecureRegistries []string
+
+	// KeepRevisions is the number of tagged revisions kept in the traffic besides the
+	// ones serving traffic, negative keeps all of them
+	KeepRevisions int
+	// DeleteRevisions deletes the Revisions whose tags are dropped from the traffic
+	DeleteRevisions bool
+
+	// Smoke are the checks run against the tag URL of the new revision
+	Smoke *SmokeConfig
+	// IngressAddress sends the smoke checks through the ingress with the tag host as Host header
+	IngressAddress string
+	// Promote sends all the traffic to the new revision once it is ready and passed the checks
+	Promote bool
+	// Wait waits for the new revision of a Knative Service to be ready even when it is
+	// not smoke tested, analyzed or promoted
+	Wait bool
+	// ReadyTimeout is the time to wait for the new revision to be ready
+	ReadyTimeout time.Duration
+
+	// Analysis are the canary steps and the metric checks run at each step
+	Analysis *AnalysisConfig
+	// Analyzer judges the canary steps, a PrometheusAnalyzer built from Analysis when nil
+	Analyzer Analyzer `json:"-"`
+
+	// Provenance is stamped on the revision template and recorded in the history
+	Provenance provenance.Provenance
+	// HistoryLimit is the number of records kept in the history ConfigMap, 0 disables the history
+	HistoryLimit int
+
+	// Environment is the name of the environment deployed to, RestConfig its cluster.
+	// A nil RestConfig is the cluster of the kubeconfig.
+	Environment string
+	RestConfig  *rest.Config `json:"-"`
+
+	// RequireApproval waits for a human to approve the deploy before updating the target
+	RequireApproval bool
+	// ApprovalTimeout is the time to wait for the approval, the deploy is aborted after
+	ApprovalTimeout time.Duration
+
+	// FreezePolicy is the namespace/name of the ConfigMap of the freeze policy, empty
+	// disables the freeze windows
+	FreezePolicy string
+	// OverrideFreeze is the reason to deploy during a freeze
+	OverrideFreeze string
+
+	// LockTimeout is the time to wait for another deployer of the Service to finish, 0
+	// deploys without taking the lock
+	LockTimeout time.Duration
+	// AllowOlderBuild deploys a build older than the one the target runs
+	AllowOlderBuild bool
+
+	// Hooks are the Jobs run before the deploy and after the target is ready
+	Hooks *HooksConfig
+
+	// GitOps writes the images to a git repository instead of updating the cluster
+	GitOps *gitops.Config
+
+	// DryRun prints the diff of the target instead of updating it, nothing is written
+	DryRun bool
+	// ServerDryRun sends the update with dryRun=All to surface the admission errors, the
+	// API server checks the permission to update the object then
+	ServerDryRun bool
+	// Out is where the dry-run diff and the result are printed, stdout when nil
+	Out io.Writer `json:"-"`
+	// ResultFile is the file the JSON result is written to
+	ResultFile string
+	// ResultsDir is the Tekton results directory a file per field of the result is
+	// written to
+	ResultsDir string
+
+	// Events sends the deploy.started event and the event of the outcome, nil sends none
+	Events *events.Emitter `json:"-"`
+	// Notifier posts the outcome of the deploy to the chat channels, nil posts nothing
+	Notifier *notify.Notifier `json:"-"`
+
+	// servingClient and kubeClient are used instead of the clients of RestConfig when
+	// kubeClient is set, by the tests
+	servingClient servingclientset.Interface
+	kubeClient    kubernetes.Interface
+	// lease is the deploy Lease of the Service once it is locked
+	lease *lock.Lease
+}
+
+// newClients builds the clients of RestConfig, of the kubeconfig when it is nil
+func (dp *Deployer) newClients() (servingclientset.Interface, kubernetes.Interface, error) {
+	if dp.kubeClient != nil {
+		return dp.servingClient, dp.kubeClient, nil
+	}
+	cfg := dp.RestConfig
+	if cfg == nil {
+		var err error
+		if cfg, err = kube.GetKubeconfig(); err != nil {
+			glog.Errorf("get kubeconfig error:%s ", err)
+			return nil, nil, err
+		}
+	}
+
+	servingClient, err := servingclientset.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building Serving clientset: %v", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building kubernetes clientset: %v", err)
+	}
+	return servingClient, kubeClient, nil
 }
 
 func (dp *Deployer) Run() error {
-	cfg, err := kube.GetKubeconfig()
+	servingClient, kubeClient, err := dp.newClients()
 	if err != nil {
-		glog.Errorf("get kubeconfig error:%s ", err)
 		return err
 	}
 
-	servingClient, err := servingclientset.NewForConfig(cfg)
+	start := time.Now()
+	rel := &release{}
+	if !dp.DryRun {
+		dp.emitStarted()
+	}
+	err = dp.deploy(servingClient, kubeClient, rel)
+	if !dp.DryRun {
+		dp.finish(kubeClient, rel, start, err)
+		dp.writeResults(rel.result)
+	}
+	return err
+}
+
+// Diff computes what the deploy would write, without the freeze, order and approval
+// checks of a dry-run
+func (dp *Deployer) Diff() (*Plan, error) {
+	servingClient, kubeClient, err := dp.newClients()
 	if err != nil {
-		glog.Fatalf("Error building Serving clientset: %v", err)
+		return nil, err
+	}
+	target, err := dp.newTarget(servingClient, kubeClient, &release{})
+	if err != nil {
+		return nil, err
 	}
+	defer closeTarget(target)
+	if err := dp.resolveImages(kubeClient); err != nil {
+		return nil, err
+	}
+	return target.DryRun(dp.Images)
+}
 
-	if svc, err := servingClient.ServingV1alpha1().Services(dp.Namespace).Get(dp.ServiceName, metav1.GetOptions{}); err != nil {
+// dryRun prints the plan of the target, it fails when the server-side dry-run rejects it
+func (dp *Deployer) dryRun(target Target) error {
+	plan, err := target.DryRun(dp.Images)
+	if err != nil {
+		glog.Errorf("dry-run %s %s/%s error:%s", dp.kind(), dp.Namespace, dp.ServiceName, err)
+		return err
+	}
+	plan.Print(dp.out())
+	return plan.Err()
+}
+
+func (dp *Deployer) deploy(servingClient servingclientset.Interface, kubeClient kubernetes.Interface, rel *release) error {
+	override, err := dp.checkFreeze(kubeClient)
+	if err != nil {
+		return err
+	}
+	rel.freezeOverride = override
+
+	target, err := dp.newTarget(servingClient, kubeClient, rel)
+	if err != nil {
+		return err
+	}
+	defer closeTarget(target)
+	if err := dp.checkOrder(target); err != nil {
+		return err
+	}
+	if err := dp.resolveImages(kubeClient); err != nil {
+		return err
+	}
+	// a dry-run writes nothing, not even the lock
+	if dp.DryRun {
+		return dp.dryRun(target)
+	}
+
+	// the lock is only taken once the deploy is approved, waiting for a reviewer doesn't
+	// block the other deploys of the service. One of them may have rolled a newer build
+	// in the meantime, so the order is checked again under the lock.
+	if err := dp.approve(kubeClient, target, rel); err != nil {
+		return err
+	}
+	lease, err := dp.lock(kubeClient)
+	if err != nil {
+		return err
+	}
+	if lease != nil {
+		defer lease.Release()
+		if err := dp.checkOrder(target); err != nil {
+			return err
+		}
+	}
+	if err := dp.runHooks(kubeClient, HookPre); err != nil {
+		return err
+	}
+	return dp.deployTarget(kubeClient, target, rel)
+}
+
+// serviceTarget is a Knative Service, each update rolls a new tagged revision
+type serviceTarget struct {
+	dp     *Deployer
+	client servingclientset.Interface
+	rel    *release
+}
+
+func (t *serviceTarget) Get() ([]ContainerImage, error) {
+	svc, err := t.client.ServingV1alpha1().Services(t.dp.Namespace).Get(t.dp.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if svc.Spec.Template == nil {
+		return []ContainerImage{}, nil
+	}
+	return containerImages(svc.Spec.Template.Spec.Containers), nil
+}
+
+func (t *serviceTarget) Provenance() (provenance.Provenance, error) {
+	svc, err := t.client.ServingV1alpha1().Services(t.dp.Namespace).Get(t.dp.ServiceName, metav1.GetOptions{})
+	if err != nil || svc.Spec.Template == nil {
+		return provenance.Provenance{}, err
+	}
+	return provenance.FromAnnotations(svc.Spec.Template.Annotations), nil
+}
+
+// UpdateImage creates the Service or rolls it to a new revision with the images
+func (t *serviceTarget) UpdateImage(images []ContainerImage) error {
+	dp, servingClient, rel := t.dp, t.client, t.rel
+	dp.Images = images
+
+	if _, err := servingClient.ServingV1alpha1().Services(dp.Namespace).Get(dp.ServiceName, metav1.GetOptions{}); err != nil {
 		// The Build resource may not exist.
 		if !errors.IsNotFound(err) {
 			glog.Errorf("get Serving %s/%s error:%s ", dp.Namespace, dp.ServiceName, err.Error())
@@ -40,69 +289,123 @@ func (dp *Deployer) Run() error {
 		}
 
 		// create Serving
-		newSvc := &v1alpha1.Service{}
-		newSvc.Namespace = dp.Namespace
-		newSvc.Name = dp.ServiceName
-		newSvc.Spec.Template = &v1alpha1.RevisionTemplateSpec{
-			Spec: v1alpha1.RevisionSpec{
-				RevisionSpec: v1beta1.RevisionSpec{
-					PodSpec: v1beta1.PodSpec{
-						Containers: []corev1.Container{
-							{
-								Image:           dp.Image,
-							},
-						},
-					},
-				},
-			},
+		newSvc, err := dp.newService()
+		if err != nil {
+			glog.Errorf("build serving: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
+			return err
+		}
+		created, err := servingClient.ServingV1alpha1().Services(dp.Namespace).Create(newSvc)
+		if err == nil {
+			rel.service = created
+			return nil
 		}
-		if _, err := servingClient.ServingV1alpha1().Services(dp.Namespace).Create(newSvc); err != nil {
+		if !errors.IsAlreadyExists(err) {
 			glog.Errorf("create serving: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
 			return err
 		}
-	} else {
-		// Update Serving
-		if svc.Spec.Template.Annotations == nil {
-			svc.Spec.Template.Annotations = map[string]string{}
+		// created by a concurrent deployer in the meantime, update it instead
+	}
+
+	// Update Serving
+	updated, err := patchService(servingClient, dp.Namespace, dp.ServiceName, func(svc *v1alpha1.Service) error {
+		previous := svc.DeepCopy()
+		r, err := dp.mutate(svc)
+		if r != nil {
+			rel.Revision, rel.Tag, rel.dropped = r.Revision, r.Tag, r.dropped
+			rel.previous = previous
 		}
-		svc.Spec.Template.Name = ""
-		svc.Spec.Template.Annotations["updated"] = fmt.Sprintf("%v", time.Now().Unix())
-		svc.Spec.Template.Spec.Containers[0].Image = dp.Image
-		traffics := make([]v1alpha1.TrafficTarget, 0 )
-		hasLatestRevision := false
-		//for _, traffic := range svc.Spec.Traffic  {
-		//	if *traffic.LatestRevision == true {
-		//		traffic.Tag = fmt.Sprintf("test-%v", time.Now().Unix())
-		//		hasLatestRevision = true
-		//	}
-		//	traffics = append(traffics, traffic)
-		//}
-		for _, traffic := range svc.Status.Traffic  {
-			traffic.URL = nil
-			if *traffic.LatestRevision == true {
-				//traffic.Tag = fmt.Sprintf("test-%v", time.Now().Unix())
-				//hasLatestRevision = true
-				latestRevision := false
-				traffic.LatestRevision = &latestRevision
-			}
-			traffics = append(traffics, traffic)
+		return err
+	})
+	if err != nil {
+		glog.Errorf("update serving: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
+		return err
+	}
+	rel.service = updated
+
+	return dp.deleteRevisions(servingClient, updated, rel.dropped)
+}
+
+// WaitReady rolls out the new revision: the Service only waits when Wait is set or the
+// revision is smoke tested, analyzed or promoted
+func (t *serviceTarget) WaitReady(timeout time.Duration) error {
+	return t.dp.rollout(t.client, t.rel, timeout)
+}
+
+// Rollback restores the template and the traffic of the Service before the deploy
+func (t *serviceTarget) Rollback() error {
+	return t.dp.rollback(t.client, t.rel)
+}
+
+// DryRun computes the Service the deploy would create or update, and sends it to the
+// API server with dryRun=All when ServerDryRun is set
+func (t *serviceTarget) DryRun(images []ContainerImage) (*Plan, error) {
+	dp := t.dp
+	dp.Images = images
+	var current runtime.Object
+	svc, err := t.client.ServingV1alpha1().Services(dp.Namespace).Get(dp.ServiceName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	var desired *v1alpha1.Service
+	if err == nil {
+		current, desired = svc, svc.DeepCopy()
+		if _, err := dp.mutate(desired); err != nil {
+			return nil, err
 		}
-		if !hasLatestRevision {
-			version := fmt.Sprintf("%s-%v", dp.ServiceName,time.Now().Unix())
-			svc.Spec.Template.Name = version
-			tt := v1alpha1.TrafficTarget{}
-			tt.RevisionName = version
-			tt.Tag = fmt.Sprintf("test-%v", time.Now().Unix())
+	} else if desired, err = dp.newService(); err != nil {
+		return nil, err
+	}
+
+	plan, err := newPlan(dp.kind(), current, desired)
+	if err != nil {
+		return nil, err
+	}
+	return plan, dp.serverDryRun(plan, t.client.ServingV1alpha1().RESTClient(), "services", current, desired)
+}
+
+// mutate rolls the Service to a new revision with the images
+func (dp *Deployer) mutate(svc *v1alpha1.Service) (*release, error) {
+	if err := dp.reconcileTemplate(svc); err != nil {
+		glog.Errorf("reconcile serving template: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
+		return nil, err
+	}
+	if svc.Spec.Template == nil {
+		return nil, fmt.Errorf("service %s/%s has no template", dp.Namespace, dp.ServiceName)
+	}
+	if svc.Spec.Template.Annotations == nil {
+		svc.Spec.Template.Annotations = map[string]string{}
+	}
+	svc.Spec.Template.Name = ""
+	svc.Spec.Template.Annotations["updated"] = fmt.Sprintf("%v", time.Now().Unix())
+	if err := dp.setImages(svc.Spec.Template.Spec.Containers); err != nil {
+		glog.Errorf("update serving image: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
+		return nil, err
+	}
+	if err := dp.applyOverrides(svc.Spec.Template); err != nil {
+		glog.Errorf("override serving: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
+		return nil, err
+	}
+	dp.stampProvenance(&svc.Spec.Template.ObjectMeta)
+	traffics := make([]v1alpha1.TrafficTarget, 0)
+	for _, traffic := range svc.Status.Traffic {
+		traffic.URL = nil
+		if traffic.LatestRevision != nil && *traffic.LatestRevision {
 			latestRevision := false
-			tt.LatestRevision = &latestRevision
-			traffics = append(traffics, tt)
-		}
-		svc.Spec.Traffic = traffics
-		if _, err := servingClient.ServingV1alpha1().Services(dp.Namespace).Update(svc); err != nil {
-			glog.Errorf("create serving: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
-			return err
+			traffic.LatestRevision = &latestRevision
 		}
+		traffics = append(traffics, traffic)
 	}
 
-	return nil
+	version := fmt.Sprintf("%s-%v", dp.ServiceName, time.Now().Unix())
+	svc.Spec.Template.Name = version
+	tt := v1alpha1.TrafficTarget{}
+	tt.RevisionName = version
+	tt.Tag = fmt.Sprintf("test-%v", time.Now().Unix())
+	latestRevision := false
+	tt.LatestRevision = &latestRevision
+	traffics = append(traffics, tt)
+
+	traffics, dropped := pruneTraffic(traffics, dp.KeepRevisions)
+	svc.Spec.Traffic = traffics
+	return &release{Revision: version, Tag: tt.Tag, dropped: dropped}, nil
 }
@@ -0,0 +1,100 @@
+package deployer
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
+	"github.com/knative-sample/tekton-serving/pkg/utils/registry"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// resolveImages pins every image to its digest. When the registry can't be reached
+// the deploy is refused unless AllowMutableTags is set.
+func (dp *Deployer) resolveImages(kubeClient kubernetes.Interface) error {
+	keychain := dp.keychain(kubeClient)
+	resolver := registry.NewResolver(keychain, dp.InsecureRegistries)
+	for i, ci := range dp.Images {
+		digest, err := resolver.Resolve(ci.Image)
+		if err != nil {
+			if dp.AllowMutableTags {
+				glog.Warningf("deploy mutable image %s, resolve digest error:%s", ci.Image, err)
+				continue
+			}
+			glog.Errorf("resolve image %s error:%s, use --allow-mutable-tags to deploy the tag", ci.Image, err)
+			return err
+		}
+		glog.Infof("resolve image %s to %s", ci.Image, digest)
+		dp.Images[i].Image = digest
+	}
+	return nil
+}
+
+// keychain loads the registry credentials of the pod the deployer runs in: the pod's
+// imagePullSecrets, the imagePullSecrets of its service account and the local docker config
+func (dp *Deployer) keychain(kubeClient kubernetes.Interface) *registry.Keychain {
+	keychain := registry.NewKeychain()
+
+	// the pod runs in the cluster of the kubeconfig, not in the one of the environment
+	if dp.RestConfig != nil {
+		kubeClient = nil
+		if cfg, err := kube.GetKubeconfig(); err == nil {
+			if client, err := kubernetes.NewForConfig(cfg); err == nil {
+				kubeClient = client
+			}
+		}
+	}
+
+	podName := os.Getenv("POD_NAME")
+	if podName == "" {
+		podName = os.Getenv("HOSTNAME")
+	}
+	podNamespace := os.Getenv("POD_NAMESPACE")
+	if podNamespace == "" {
+		if bts, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil {
+			podNamespace = strings.TrimSpace(string(bts))
+		}
+	}
+
+	if kubeClient != nil && podName != "" && podNamespace != "" {
+		if pod, err := kubeClient.CoreV1().Pods(podNamespace).Get(podName, metav1.GetOptions{}); err != nil {
+			glog.Warningf("get pod %s/%s error:%s, resolve images without its imagePullSecrets", podNamespace, podName, err)
+		} else {
+			secrets := pod.Spec.ImagePullSecrets
+			if sa, err := kubeClient.CoreV1().ServiceAccounts(podNamespace).Get(pod.Spec.ServiceAccountName, metav1.GetOptions{}); err == nil {
+				secrets = append(secrets, sa.ImagePullSecrets...)
+			}
+			for _, ref := range secrets {
+				secret, err := kubeClient.CoreV1().Secrets(podNamespace).Get(ref.Name, metav1.GetOptions{})
+				if err != nil {
+					glog.Warningf("get imagePullSecret %s/%s error:%s", podNamespace, ref.Name, err)
+					continue
+				}
+				if err := addSecret(keychain, secret); err != nil {
+					glog.Warningf("parse imagePullSecret %s/%s error:%s", podNamespace, ref.Name, err)
+				}
+			}
+		}
+	}
+
+	if err := keychain.AddDockerConfigFile(); err != nil {
+		glog.Warningf("read docker config error:%s", err)
+	}
+	return keychain
+}
+
+func addSecret(keychain *registry.Keychain, secret *corev1.Secret) error {
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		return keychain.AddDockerConfigJSON(secret.Data[corev1.DockerConfigJsonKey])
+	case corev1.SecretTypeDockercfg:
+		return keychain.AddDockerCfg(secret.Data[corev1.DockerConfigKey])
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package deployer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveImagesMutableTags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/manifests/v1") {
+			w.Header().Set("Docker-Content-Digest", "sha256:"+strings.Repeat("a", 64))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	cases := []struct {
+		name    string
+		image   string
+		allow   bool
+		want    string
+		wantErr bool
+	}{{
+		name:  "resolved",
+		image: host + "/app:v1",
+		want:  host + "/app@sha256:" + strings.Repeat("a", 64),
+	}, {
+		name:    "unresolved tag refused",
+		image:   host + "/app:v2",
+		wantErr: true,
+	}, {
+		name:  "unresolved tag deployed with --allow-mutable-tags",
+		image: host + "/app:v2",
+		allow: true,
+		want:  host + "/app:v2",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dp := &Deployer{Images: []ContainerImage{{Image: c.image}}, AllowMutableTags: c.allow}
+			err := dp.resolveImages(nil)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("resolveImages deployed %s, want an error", dp.Images[0].Image)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveImages error:%s", err)
+			}
+			if got := dp.Images[0].Image; got != c.want {
+				t.Errorf("image = %s, want %s", got, c.want)
+			}
+		})
+	}
+}
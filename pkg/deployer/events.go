@@ -0,0 +1,64 @@
+package deployer
+
+import (
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+)
+
+// eventTypes are the event types of the deploy outcomes
+var eventTypes = map[string]string{
+	OutcomeSucceeded:  events.TypeDeploySucceeded,
+	OutcomeFailed:     events.TypeDeployFailed,
+	OutcomeRolledBack: events.TypeDeployRolledBack,
+}
+
+// notifyKinds are the notification kinds of the deploy outcomes
+var notifyKinds = map[string]string{
+	OutcomeSucceeded:  notify.KindDeploySucceeded,
+	OutcomeFailed:     notify.KindDeployFailed,
+	OutcomeRolledBack: notify.KindDeployRolledBack,
+}
+
+// subject is the subject of the events of the deploy
+func (dp *Deployer) subject() string {
+	return dp.Namespace + "/" + dp.ServiceName
+}
+
+// emitStarted sends the deploy.started event with the images and the provenance
+func (dp *Deployer) emitStarted() {
+	dp.Events.Emit(events.TypeDeployStarted, dp.subject(), &Result{
+		Service:     dp.ServiceName,
+		Namespace:   dp.Namespace,
+		Kind:        dp.kind(),
+		Environment: dp.Environment,
+		Images:      dp.Images,
+		Provenance:  dp.Provenance,
+	})
+}
+
+// emitFinished sends the event of the outcome of the deploy with its result
+func (dp *Deployer) emitFinished(r *Result) {
+	dp.Events.Emit(eventTypes[r.Outcome], dp.subject(), r)
+}
+
+// notifyFinished posts the outcome of the deploy to the chat channels
+func (dp *Deployer) notifyFinished(r *Result) {
+	m := &notify.Message{
+		Kind:        notifyKinds[r.Outcome],
+		Repo:        r.Provenance.Repo,
+		Commit:      r.Provenance.Commit,
+		PullRequest: r.Provenance.PullRequest,
+		Author:      r.Provenance.Author,
+		PipelineRun: r.Provenance.PipelineRun,
+		Service:     r.Service,
+		Namespace:   r.Namespace,
+		Environment: r.Environment,
+		Revision:    r.Revision,
+		URL:         r.URL,
+		Reason:      r.Message,
+	}
+	for _, t := range r.Traffic {
+		m.Traffic = append(m.Traffic, notify.Traffic{Revision: t.Revision, Tag: t.Tag, Percent: t.Percent})
+	}
+	dp.Notifier.Notify(m)
+}
@@ -0,0 +1,71 @@
+package deployer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+)
+
+// sink records the type, the subject and the data of the events it receives
+type sink struct {
+	types    []string
+	subjects []string
+	results  []*Result
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	result := &Result{}
+	json.Unmarshal(body, result)
+	s.types = append(s.types, r.Header.Get("Ce-Type"))
+	s.subjects = append(s.subjects, r.Header.Get("Ce-Subject"))
+	s.results = append(s.results, result)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestEmitEvents(t *testing.T) {
+	s := &sink{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	emitter, err := events.NewEmitter(server.URL, "tekton-serving/deployer")
+	if err != nil {
+		t.Fatalf("NewEmitter error:%s", err)
+	}
+	p := provenance.Provenance{Repo: "knative-sample/app", Commit: "0123456789abcdef", PipelineRun: "app-build-42"}
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Environment: "staging",
+		Images: []ContainerImage{{Image: "app@sha256:aaaa"}}, Provenance: p, Events: emitter}
+
+	dp.emitStarted()
+	for _, outcome := range []string{OutcomeSucceeded, OutcomeFailed, OutcomeRolledBack} {
+		dp.emitFinished(&Result{Service: "app", Namespace: "default", Revision: "app-00002",
+			Outcome: outcome, Provenance: p})
+	}
+
+	wantTypes := []string{events.TypeDeployStarted, events.TypeDeploySucceeded, events.TypeDeployFailed, events.TypeDeployRolledBack}
+	if len(s.types) != len(wantTypes) {
+		t.Fatalf("events %v, want %v", s.types, wantTypes)
+	}
+	for i, want := range wantTypes {
+		if s.types[i] != want || s.subjects[i] != "default/app" {
+			t.Errorf("event %d is a %s of %s, want a %s of default/app", i, s.types[i], s.subjects[i], want)
+		}
+		if s.results[i].Provenance != p {
+			t.Errorf("%s provenance = %+v, want %+v", s.types[i], s.results[i].Provenance, p)
+		}
+	}
+	started := s.results[0]
+	if started.Kind != KindService || started.Environment != "staging" || len(started.Images) != 1 ||
+		started.Images[0].Image != "app@sha256:aaaa" || started.Outcome != "" {
+		t.Errorf("deploy.started data = %+v, want the images before the outcome", started)
+	}
+	for i, r := range s.results[1:] {
+		if r.Revision != "app-00002" || r.Outcome != []string{OutcomeSucceeded, OutcomeFailed, OutcomeRolledBack}[i] {
+			t.Errorf("%s data = %+v, want the result of the deploy", s.types[i+1], r)
+		}
+	}
+}
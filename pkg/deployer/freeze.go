@@ -0,0 +1,38 @@
+package deployer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/freeze"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FreezeOverride is a deploy made during a freeze
+type FreezeOverride struct {
+	Freeze string `json:"freeze"`
+	Reason string `json:"reason"`
+}
+
+// checkFreeze refuses the deploy during a freeze of FreezePolicy unless OverrideFreeze
+// gives a reason, the override is returned to be recorded in the history
+func (dp *Deployer) checkFreeze(kubeClient kubernetes.Interface) (*FreezeOverride, error) {
+	if dp.FreezePolicy == "" {
+		return nil, nil
+	}
+	policy, err := freeze.Load(kubeClient, dp.FreezePolicy)
+	if err != nil {
+		glog.Errorf("load freeze policy %s error:%s", dp.FreezePolicy, err)
+		return nil, err
+	}
+	f := policy.Check(time.Now(), dp.Namespace, dp.ServiceName)
+	if f == nil {
+		return nil, nil
+	}
+	if dp.OverrideFreeze == "" {
+		return nil, fmt.Errorf("%s/%s is not deployed, %s. Use --override-freeze <reason> to deploy anyway", dp.Namespace, dp.ServiceName, f)
+	}
+	glog.Warningf("%s/%s is deployed during %s, override reason: %s", dp.Namespace, dp.ServiceName, f, dp.OverrideFreeze)
+	return &FreezeOverride{Freeze: f.Name, Reason: dp.OverrideFreeze}, nil
+}
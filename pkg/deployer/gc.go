@@ -0,0 +1,142 @@
+package deployer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GC drops the tagged revisions beyond KeepRevisions from the traffic of the Service,
+// and deletes their Revisions when DeleteRevisions is set
+func (dp *Deployer) GC() error {
+	servingClient, _, err := dp.newClients()
+	if err != nil {
+		return err
+	}
+
+	var dropped []string
+	svc, err := patchService(servingClient, dp.Namespace, dp.ServiceName, func(svc *v1alpha1.Service) error {
+		svc.Spec.Traffic, dropped = pruneTraffic(svc.Spec.Traffic, dp.KeepRevisions)
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("update serving: %s/%s error:%s", dp.Namespace, dp.ServiceName, err.Error())
+		return err
+	}
+	if len(dropped) == 0 {
+		glog.Infof("serving %s/%s has no stale tagged revision", dp.Namespace, dp.ServiceName)
+		return nil
+	}
+	glog.Infof("drop tagged revisions %s from serving %s/%s", strings.Join(dropped, ","), dp.Namespace, dp.ServiceName)
+
+	return dp.deleteRevisions(servingClient, svc, dropped)
+}
+
+// pruneTraffic keeps every target serving traffic, every untagged target and the keep
+// most recent tagged targets. It returns the kept targets and the revisions of the
+// dropped ones. A negative keep disables the pruning.
+func pruneTraffic(traffic []v1alpha1.TrafficTarget, keep int) ([]v1alpha1.TrafficTarget, []string) {
+	if keep < 0 {
+		return traffic, nil
+	}
+
+	type candidate struct {
+		index int
+		ts    int64
+	}
+	candidates := make([]candidate, 0)
+	for i, tt := range traffic {
+		if tt.Tag == "" || tt.Percent > 0 || (tt.LatestRevision != nil && *tt.LatestRevision) {
+			continue
+		}
+		candidates = append(candidates, candidate{index: i, ts: targetTimestamp(tt)})
+	}
+	if len(candidates) <= keep {
+		return traffic, nil
+	}
+
+	// newest first, targets appended later are newer when the timestamps are equal
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].ts != candidates[j].ts {
+			return candidates[i].ts > candidates[j].ts
+		}
+		return candidates[i].index > candidates[j].index
+	})
+	drop := map[int]bool{}
+	for _, c := range candidates[keep:] {
+		drop[c.index] = true
+	}
+
+	kept := make([]v1alpha1.TrafficTarget, 0, len(traffic)-len(drop))
+	dropped := make([]string, 0, len(drop))
+	for i, tt := range traffic {
+		if drop[i] {
+			if tt.RevisionName != "" {
+				dropped = append(dropped, tt.RevisionName)
+			}
+			continue
+		}
+		kept = append(kept, tt)
+	}
+	return kept, dropped
+}
+
+// targetTimestamp reads the unix timestamp of the test-<unix> tag, or of the
+// <service>-<unix> revision name, 0 when there is none
+func targetTimestamp(tt v1alpha1.TrafficTarget) int64 {
+	for _, s := range []string{tt.Tag, tt.RevisionName} {
+		if i := strings.LastIndex(s, "-"); i >= 0 {
+			if ts, err := strconv.ParseInt(s[i+1:], 10, 64); err == nil {
+				return ts
+			}
+		}
+	}
+	return 0
+}
+
+// deleteRevisions deletes the dropped revisions which are still not referenced by the
+// Service, nothing is deleted unless DeleteRevisions is set
+func (dp *Deployer) deleteRevisions(servingClient servingclientset.Interface, svc *v1alpha1.Service, revisions []string) error {
+	if !dp.DeleteRevisions {
+		return nil
+	}
+
+	inUse := map[string]bool{
+		svc.Status.LatestReadyRevisionName:   true,
+		svc.Status.LatestCreatedRevisionName: true,
+	}
+	if svc.Spec.Template != nil {
+		inUse[svc.Spec.Template.Name] = true
+	}
+	for _, tt := range svc.Spec.Traffic {
+		inUse[tt.RevisionName] = true
+	}
+	// the route may not have picked up the pruned spec yet, only the targets
+	// serving traffic in the status are still in use
+	for _, tt := range svc.Status.Traffic {
+		if tt.Percent > 0 {
+			inUse[tt.RevisionName] = true
+		}
+	}
+
+	for _, name := range revisions {
+		if inUse[name] {
+			continue
+		}
+		if err := servingClient.ServingV1alpha1().Revisions(dp.Namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			glog.Errorf("delete revision %s/%s error:%s", dp.Namespace, name, err.Error())
+			return err
+		}
+		glog.Infof("delete revision %s/%s", dp.Namespace, name)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package deployer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1beta1"
+)
+
+func target(tag, revision string, percent int) v1alpha1.TrafficTarget {
+	return v1alpha1.TrafficTarget{TrafficTarget: v1beta1.TrafficTarget{Tag: tag, RevisionName: revision, Percent: percent}}
+}
+
+func TestPruneTraffic(t *testing.T) {
+	latest := true
+	latestTarget := v1alpha1.TrafficTarget{TrafficTarget: v1beta1.TrafficTarget{Tag: "current", LatestRevision: &latest}}
+	traffic := []v1alpha1.TrafficTarget{
+		target("", "app-1600000000", 0),
+		target("test-1600000100", "app-1600000100", 90),
+		target("test-1600000200", "app-1600000200", 0),
+		target("test-1600000300", "app-1600000300", 10),
+		target("test-1600000400", "app-1600000400", 0),
+		target("test-1600000500", "app-1600000500", 0),
+		latestTarget,
+	}
+	names := func(targets []v1alpha1.TrafficTarget) []string {
+		out := []string{}
+		for _, tt := range targets {
+			out = append(out, tt.Tag+"/"+tt.RevisionName)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name        string
+		traffic     []v1alpha1.TrafficTarget
+		keep        int
+		wantKept    []string
+		wantDropped []string
+	}{{
+		name:     "negative keeps everything",
+		traffic:  traffic,
+		keep:     -1,
+		wantKept: names(traffic),
+	}, {
+		name:     "fewer tagged targets than kept",
+		traffic:  traffic,
+		keep:     3,
+		wantKept: names(traffic),
+	}, {
+		name:        "the oldest idle tagged target is dropped",
+		traffic:     traffic,
+		keep:        2,
+		wantKept:    names(append(append([]v1alpha1.TrafficTarget{}, traffic[:2]...), traffic[3:]...)),
+		wantDropped: []string{"app-1600000200"},
+	}, {
+		name:        "targets serving traffic, untagged and latest are kept at 0",
+		traffic:     traffic,
+		keep:        0,
+		wantKept:    names([]v1alpha1.TrafficTarget{traffic[0], traffic[1], traffic[3], latestTarget}),
+		wantDropped: []string{"app-1600000200", "app-1600000400", "app-1600000500"},
+	}, {
+		name: "the revision time is used when the tag has none",
+		traffic: []v1alpha1.TrafficTarget{
+			target("blue", "app-1600000900", 0),
+			target("green", "app-1600000100", 0),
+		},
+		keep:        1,
+		wantKept:    []string{"blue/app-1600000900"},
+		wantDropped: []string{"app-1600000100"},
+	}, {
+		name: "the later target is newer without a time",
+		traffic: []v1alpha1.TrafficTarget{
+			target("blue", "app-blue", 0),
+			target("green", "app-green", 0),
+		},
+		keep:        1,
+		wantKept:    []string{"green/app-green"},
+		wantDropped: []string{"app-blue"},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kept, dropped := pruneTraffic(c.traffic, c.keep)
+			if fmt.Sprint(names(kept)) != fmt.Sprint(c.wantKept) {
+				t.Errorf("kept %v, want %v", names(kept), c.wantKept)
+			}
+			if fmt.Sprint(dropped) != fmt.Sprint(c.wantDropped) && !(len(dropped) == 0 && len(c.wantDropped) == 0) {
+				t.Errorf("dropped %v, want %v", dropped, c.wantDropped)
+			}
+		})
+	}
+}
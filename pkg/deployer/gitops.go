@@ -0,0 +1,230 @@
+package deployer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+)
+
+// gitopsTarget writes the images to a manifest of a git repository, the cluster is
+// updated by the controller syncing the repository
+type gitopsTarget struct {
+	dp   *Deployer
+	cfg  *gitops.Config
+	rel  *release
+	repo *gitops.Repo
+	// branch and commit are the branch pushed to and the commit pushed
+	branch string
+	commit string
+}
+
+// clone clones the repository on first use
+func (t *gitopsTarget) clone() (*gitops.Repo, error) {
+	if t.repo != nil {
+		return t.repo, nil
+	}
+	repo, err := gitops.Clone(t.cfg.Repo, t.cfg.Branch, t.cfg.Author)
+	if err != nil {
+		glog.Errorf("clone %s error:%s", gitops.Redact(t.cfg.Repo), err)
+		return nil, err
+	}
+	t.repo = repo
+	return repo, nil
+}
+
+// Close removes the clone
+func (t *gitopsTarget) Close() error {
+	if t.repo == nil {
+		return nil
+	}
+	return t.repo.Close()
+}
+
+// imagePath is the YAML path of the image of the container
+func (t *gitopsTarget) imagePath(container string) string {
+	path := t.cfg.ImagePath
+	if path == "" {
+		path = "spec.template.spec.containers[name={container}].image"
+		if container == "" {
+			path = "spec.template.spec.containers[0].image"
+		}
+	}
+	return strings.Replace(path, "{container}", container, -1)
+}
+
+func (t *gitopsTarget) Get() ([]ContainerImage, error) {
+	repo, err := t.clone()
+	if err != nil {
+		return nil, err
+	}
+	data, err := repo.ReadFile(t.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	images := []ContainerImage{}
+	if t.cfg.Kustomize {
+		refs, err := gitops.KustomizeImages(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s error:%s", t.cfg.Path, err)
+		}
+		for name, ref := range refs {
+			images = append(images, ContainerImage{Container: name, Image: ref})
+		}
+		sort.Slice(images, func(i, j int) bool { return images[i].Container < images[j].Container })
+		return images, nil
+	}
+	containers := []string{""}
+	if len(t.dp.Images) > 0 {
+		containers = containers[:0]
+		for _, ci := range t.dp.Images {
+			containers = append(containers, ci.Container)
+		}
+	}
+	for _, c := range containers {
+		image, err := gitops.GetImage(data, t.imagePath(c))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", t.cfg.Path, err)
+		}
+		images = append(images, ContainerImage{Container: c, Image: image})
+	}
+	return images, nil
+}
+
+// Provenance reads the provenance of the last commit of the manifest
+func (t *gitopsTarget) Provenance() (provenance.Provenance, error) {
+	repo, err := t.clone()
+	if err != nil {
+		return provenance.Provenance{}, err
+	}
+	message, err := repo.LastMessage(t.cfg.Path)
+	if err != nil {
+		return provenance.Provenance{}, err
+	}
+	return gitops.ProvenanceOf(message), nil
+}
+
+// pushBranch is the branch pushed to, {service} and {commit} are replaced by the name
+// of the service and the commit deployed
+func (t *gitopsTarget) pushBranch() string {
+	if t.cfg.PushBranch == "" {
+		return t.repo.Branch
+	}
+	commit := t.dp.Provenance.Commit
+	if len(commit) > 8 {
+		commit = commit[:8]
+	}
+	return strings.NewReplacer("{service}", t.dp.ServiceName, "{commit}", commit).Replace(t.cfg.PushBranch)
+}
+
+// edit writes the images to the manifest
+func (t *gitopsTarget) edit(data []byte, images []ContainerImage) ([]byte, error) {
+	for _, ci := range images {
+		var err error
+		if t.cfg.Kustomize {
+			data, _, err = gitops.SetKustomizeImage(data, ci.Image)
+		} else {
+			data, _, err = gitops.SetImage(data, t.imagePath(ci.Container), ci.Image)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", t.cfg.Path, err)
+		}
+	}
+	return data, nil
+}
+
+// UpdateImage commits the images to the manifest and pushes the commit
+func (t *gitopsTarget) UpdateImage(images []ContainerImage) error {
+	dp := t.dp
+	dp.Images = images
+	repo, err := t.clone()
+	if err != nil {
+		return err
+	}
+
+	edit := func(data []byte) ([]byte, error) {
+		return t.edit(data, images)
+	}
+	refs := make([]string, 0, len(images))
+	for _, ci := range images {
+		refs = append(refs, ci.Image)
+	}
+	title := fmt.Sprintf("Deploy %s/%s: %s", dp.Namespace, dp.ServiceName, strings.Join(refs, ", "))
+
+	t.branch = t.pushBranch()
+	sha, err := repo.Update(t.cfg.Path, edit, gitops.Message(title, dp.Provenance), t.branch)
+	if err != nil {
+		glog.Errorf("push %s to %s error:%s", t.cfg.Path, gitops.Redact(t.cfg.Repo), err)
+		return err
+	}
+	t.commit = sha
+	if sha == "" {
+		glog.Infof("%s of %s already has %v", t.cfg.Path, gitops.Redact(t.cfg.Repo), refs)
+		if sha, err = repo.Head(); err != nil {
+			return err
+		}
+	}
+	t.rel.gitops = &gitops.Result{Repo: gitops.Redact(t.cfg.Repo), Branch: t.branch, Commit: sha}
+	glog.Infof("%s/%s deployed as commit %s of %s branch %s", dp.Namespace, dp.ServiceName, sha, gitops.Redact(t.cfg.Repo), t.branch)
+	return nil
+}
+
+// WaitReady returns at once, the rollout is up to the controller syncing the repository
+func (t *gitopsTarget) WaitReady(timeout time.Duration) error {
+	return nil
+}
+
+// Rollback reverts the commit pushed to the branch of the manifests, a commit pushed
+// to a pull request branch is left to its pull request
+func (t *gitopsTarget) Rollback() error {
+	if t.commit == "" || t.branch != t.repo.Branch {
+		return ErrNoRollback
+	}
+	revert, err := t.repo.Revert(t.commit)
+	if err != nil {
+		return err
+	}
+	if err := t.repo.Push(t.branch, false); err != nil {
+		return err
+	}
+	glog.Infof("reverted %s as %s", t.commit, revert)
+	return nil
+}
+
+// DryRun computes the manifest the deploy would commit
+func (t *gitopsTarget) DryRun(images []ContainerImage) (*Plan, error) {
+	t.dp.Images = images
+	repo, err := t.clone()
+	if err != nil {
+		return nil, err
+	}
+	data, err := repo.ReadFile(t.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := t.edit(data, images)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{
+		Kind:         "gitops",
+		Namespace:    t.dp.Namespace,
+		Name:         t.dp.ServiceName,
+		Path:         t.cfg.Path,
+		Current:      string(data),
+		Desired:      string(desired),
+		ServerDryRun: ServerDryRunSkipped,
+	}, nil
+}
+
+// closeTarget releases what the target holds, such as a clone
+func closeTarget(target Target) {
+	if c, ok := target.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/approval"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// historyKey is the ConfigMap key holding the records as a json array, newest first
+	historyKey = "history.json"
+	// historyLabel marks the history ConfigMaps
+	historyLabel = provenance.GroupName + "/history"
+
+	OutcomeSucceeded  = "succeeded"
+	OutcomeFailed     = "failed"
+	OutcomeRolledBack = "rolledback"
+)
+
+// Record is one deploy of a Service
+type Record struct {
+	Time        time.Time             `json:"time"`
+	Duration    string                `json:"duration"`
+	Environment string                `json:"environment,omitempty"`
+	Revision    string                `json:"revision,omitempty"`
+	Images      []ContainerImage      `json:"images"`
+	Outcome     string                `json:"outcome"`
+	Message     string                `json:"message,omitempty"`
+	Provenance  provenance.Provenance `json:"provenance"`
+	// Approval is who approved, or rejected, the deploy
+	Approval *approval.Decision `json:"approval,omitempty"`
+	// FreezeOverride is the freeze the deploy overrode
+	FreezeOverride *FreezeOverride `json:"freezeOverride,omitempty"`
+	// GitOps is the commit the images were pushed as
+	GitOps *gitops.Result `json:"gitops,omitempty"`
+}
+
+// historyName is the ConfigMap holding the history of the Service
+func historyName(service string) string {
+	return fmt.Sprintf("%s-deploy-history", service)
+}
+
+// recordHistory appends the outcome of the deploy to the history ConfigMap, failing to
+// record it doesn't fail the deploy
+func (dp *Deployer) recordHistory(kubeClient kubernetes.Interface, rel *release, start time.Time, deployErr error) {
+	if dp.HistoryLimit == 0 {
+		return
+	}
+
+	record := Record{
+		Time:        start.UTC(),
+		Duration:    time.Since(start).Round(time.Second).String(),
+		Environment: dp.Environment,
+		Images:      dp.Images,
+		Provenance:  dp.Provenance,
+	}
+	record.Outcome, record.Message = outcome(rel, deployErr)
+	if rel != nil {
+		record.Revision = rel.Revision
+		record.Approval = rel.approval
+		record.FreezeOverride = rel.freezeOverride
+		record.GitOps = rel.gitops
+	}
+
+	if err := appendHistory(kubeClient, dp.Namespace, dp.ServiceName, record, dp.HistoryLimit); err != nil {
+		glog.Errorf("record deploy history of %s/%s error:%s", dp.Namespace, dp.ServiceName, err)
+	}
+}
+
+// appendHistory prepends the record to the history ConfigMap and keeps at most limit records
+func appendHistory(kubeClient kubernetes.Interface, namespace, service string, record Record, limit int) error {
+	name := historyName(service)
+	return retry.RetryOnConflict(conflictBackoff, func() error {
+		cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels:    map[string]string{historyLabel: service},
+				},
+			}
+		}
+
+		records, err := decodeHistory(cm)
+		if err != nil {
+			glog.Warningf("history %s/%s is corrupted, start a new one: %s", namespace, name, err)
+			records = nil
+		}
+		records = append([]Record{record}, records...)
+		if limit > 0 && len(records) > limit {
+			records = records[:limit]
+		}
+		bts, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[historyKey] = string(bts)
+
+		if cm.ResourceVersion == "" {
+			_, err = kubeClient.CoreV1().ConfigMaps(namespace).Create(cm)
+			if errors.IsAlreadyExists(err) {
+				// created concurrently, retry on the existing one
+				return errors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		_, err = kubeClient.CoreV1().ConfigMaps(namespace).Update(cm)
+		return err
+	})
+}
+
+func decodeHistory(cm *corev1.ConfigMap) ([]Record, error) {
+	records := []Record{}
+	data := cm.Data[historyKey]
+	if data == "" {
+		return records, nil
+	}
+	if err := json.Unmarshal([]byte(data), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// History returns the deploy records of the Service, newest first
+func History(kubeClient kubernetes.Interface, namespace, service string) ([]Record, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(namespace).Get(historyName(service), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return []Record{}, nil
+		}
+		return nil, err
+	}
+	return decodeHistory(cm)
+}
+
+// PrintHistory writes the records as a table
+func PrintHistory(w io.Writer, records []Record) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tREVISION\tOUTCOME\tDURATION\tIMAGES\tCOMMIT\tPIPELINERUN\tAUTHOR\tAPPROVED BY")
+	for _, r := range records {
+		images := make([]string, 0, len(r.Images))
+		for _, ci := range r.Images {
+			if ci.Container != "" {
+				images = append(images, ci.Container+"="+ci.Image)
+				continue
+			}
+			images = append(images, ci.Image)
+		}
+		commit := r.Provenance.Commit
+		if len(commit) > 8 {
+			commit = commit[:8]
+		}
+		approvedBy := ""
+		if r.Approval != nil && r.Approval.State == approval.StateApproved {
+			approvedBy = r.Approval.By
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Format(time.RFC3339), dash(r.Revision), r.Outcome, r.Duration,
+			strings.Join(images, ","), dash(commit), dash(r.Provenance.PipelineRun), dash(r.Provenance.Author), dash(approvedBy))
+	}
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,437 @@
+package deployer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	HookPre  = "pre"
+	HookPost = "post"
+
+	// hookLabel is the Service of a hook Job, hookNameLabel and hookPhaseLabel the hook
+	hookLabel      = provenance.GroupName + "/hook"
+	hookNameLabel  = provenance.GroupName + "/hook-name"
+	hookPhaseLabel = provenance.GroupName + "/hook-phase"
+)
+
+// HooksConfig are the Jobs run before the target is updated and after it is ready. A
+// failing pre hook aborts the deploy, a failing post hook rolls it back.
+type HooksConfig struct {
+	Pre  []Hook `json:"pre,omitempty"`
+	Post []Hook `json:"post,omitempty"`
+	// KeepSucceeded and KeepFailed are the number of Jobs kept per hook, the older ones
+	// are deleted with their pods. 1 and 3 by default, negative keeps all.
+	KeepSucceeded *int `json:"keepSucceeded,omitempty"`
+	KeepFailed    *int `json:"keepFailed,omitempty"`
+}
+
+// Hook is a Job template, the image deployed and the provenance are injected into it
+type Hook struct {
+	Name string `json:"name"`
+	// ImageFrom is the container of the target whose new image the hook runs, the first
+	// deployed image when empty
+	ImageFrom string `json:"imageFrom,omitempty"`
+	// Container is the container of the Job given the image, the first one when empty
+	Container string `json:"container,omitempty"`
+	// Timeout is the time the Job has to complete, 10m by default
+	Timeout string          `json:"timeout,omitempty"`
+	Job     batchv1.JobSpec `json:"job"`
+}
+
+// LoadHooksConfig reads a hooks yaml file
+func LoadHooksConfig(path string) (*HooksConfig, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &HooksConfig{}
+	if err := yaml.Unmarshal(bts, cfg); err != nil {
+		return nil, fmt.Errorf("parse hooks config %s error:%s", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("hooks config %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *HooksConfig) validate() error {
+	seen := map[string]bool{}
+	for _, h := range append(append([]Hook{}, c.Pre...), c.Post...) {
+		if h.Name == "" {
+			return fmt.Errorf("hook without name")
+		}
+		if seen[h.Name] {
+			return fmt.Errorf("hook %s is defined twice", h.Name)
+		}
+		seen[h.Name] = true
+		if len(h.Job.Template.Spec.Containers) == 0 {
+			return fmt.Errorf("hook %s has no container", h.Name)
+		}
+		if _, err := h.timeout(); err != nil {
+			return fmt.Errorf("hook %s: invalid timeout %q", h.Name, h.Timeout)
+		}
+	}
+	return nil
+}
+
+func (h *Hook) timeout() (time.Duration, error) {
+	if h.Timeout == "" {
+		return 10 * time.Minute, nil
+	}
+	return time.ParseDuration(h.Timeout)
+}
+
+func keep(v *int, def int) int {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+// runHooks runs the hooks of the phase one after the other, it stops at the first failure
+func (dp *Deployer) runHooks(kubeClient kubernetes.Interface, phase string) error {
+	if dp.Hooks == nil {
+		return nil
+	}
+	hooks := dp.Hooks.Pre
+	if phase == HookPost {
+		hooks = dp.Hooks.Post
+	}
+	for _, h := range hooks {
+		if err := dp.checkLease(); err != nil {
+			return err
+		}
+		err := dp.runHook(kubeClient, phase, h)
+		dp.cleanupHooks(kubeClient, h.Name)
+		if err != nil {
+			glog.Errorf("%s-deploy hook %s of %s/%s error:%s", phase, h.Name, dp.Namespace, dp.ServiceName, err)
+			return fmt.Errorf("%s-deploy hook %s failed: %s", phase, h.Name, err)
+		}
+	}
+	return nil
+}
+
+// runHook creates the Job of the hook, streams its logs and waits for it to complete
+func (dp *Deployer) runHook(kubeClient kubernetes.Interface, phase string, h Hook) error {
+	job, err := dp.hookJob(phase, h)
+	if err != nil {
+		return err
+	}
+	job, err = kubeClient.BatchV1().Jobs(dp.Namespace).Create(job)
+	if err != nil {
+		return err
+	}
+	glog.Infof("%s-deploy hook %s: Job %s/%s created", phase, h.Name, job.Namespace, job.Name)
+
+	stop := make(chan struct{})
+	streamed := &sync.WaitGroup{}
+	streamed.Add(1)
+	go func() {
+		defer streamed.Done()
+		streamJobLogs(kubeClient, job.Namespace, job.Name, h.Name, stop)
+	}()
+	defer func() {
+		close(stop)
+		streamed.Wait()
+	}()
+
+	timeout, _ := h.timeout()
+	err = wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		j, err := kubeClient.BatchV1().Jobs(job.Namespace).Get(job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, c := range j.Status.Conditions {
+			if c.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch c.Type {
+			case batchv1.JobComplete:
+				return true, nil
+			case batchv1.JobFailed:
+				return false, fmt.Errorf("Job %s failed: %s", job.Name, strings.TrimSpace(c.Reason+" "+c.Message))
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		// the Job would go on running the hook after the deploy gave up on it
+		background := metav1.DeletePropagationBackground
+		if err := kubeClient.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &background}); err != nil {
+			glog.Warningf("delete hook Job %s/%s error:%s", job.Namespace, job.Name, err)
+		}
+		return fmt.Errorf("Job %s did not complete within %s", job.Name, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	glog.Infof("%s-deploy hook %s: Job %s/%s completed", phase, h.Name, job.Namespace, job.Name)
+	return nil
+}
+
+// hookJob builds the Job of the hook: the deployed image is set on its container, the
+// provenance and the deploy are given as labels, annotations and env
+func (dp *Deployer) hookJob(phase string, h Hook) (*batchv1.Job, error) {
+	image, err := dp.hookImage(h)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := h.Job.DeepCopy()
+	tmpl := &spec.Template
+	containers := tmpl.Spec.Containers
+	index := 0
+	if h.Container != "" {
+		index = -1
+		for i := range containers {
+			if containers[i].Name == h.Container {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("hook %s has no container %s", h.Name, h.Container)
+		}
+	}
+	containers[index].Image = image
+
+	env := dp.Provenance.Env()
+	env["DEPLOY_IMAGE"] = image
+	env["DEPLOY_NAMESPACE"] = dp.Namespace
+	env["DEPLOY_SERVICE"] = dp.ServiceName
+	if dp.Environment != "" {
+		env["DEPLOY_ENVIRONMENT"] = dp.Environment
+	}
+	env["HOOK_PHASE"] = phase
+	names := make([]string, 0, len(env))
+	for k := range env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for i := range containers {
+		for _, k := range names {
+			containers[i].Env = setEnv(containers[i].Env, corev1.EnvVar{Name: k, Value: env[k]})
+		}
+	}
+
+	if tmpl.Spec.RestartPolicy == "" {
+		tmpl.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+	if spec.BackoffLimit == nil {
+		// a migration is not retried unless the hook asks for it
+		backoff := int32(0)
+		spec.BackoffLimit = &backoff
+	}
+	if spec.ActiveDeadlineSeconds == nil {
+		// the Job is stopped by Kubernetes as well when the deployer is gone
+		timeout, _ := h.timeout()
+		deadline := int64(timeout / time.Second)
+		spec.ActiveDeadlineSeconds = &deadline
+	}
+
+	labels := map[string]string{hookLabel: dp.ServiceName, hookNameLabel: h.Name, hookPhaseLabel: phase}
+	dp.stampProvenance(&tmpl.ObjectMeta)
+	tmpl.Labels = mergeMap(tmpl.Labels, labels)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        hookJobName(dp.ServiceName, h.Name, time.Now()),
+			Namespace:   dp.Namespace,
+			Labels:      mergeMap(dp.Provenance.Labels(), labels),
+			Annotations: dp.Provenance.Annotations(),
+		},
+		Spec: *spec,
+	}
+	return job, nil
+}
+
+// hookJobName is <service>-<hook>-<unix time>, the service and the hook are cut so the
+// name fits the 63 characters of the job-name label of the pods
+func hookJobName(service, hook string, now time.Time) string {
+	suffix := fmt.Sprintf("-%d", now.Unix())
+	name := service + "-" + hook
+	if len(name)+len(suffix) > 63 {
+		name = strings.TrimRight(name[:63-len(suffix)], "-.")
+	}
+	return name + suffix
+}
+
+// hookImage is the image of the ImageFrom container among the images deployed
+func (dp *Deployer) hookImage(h Hook) (string, error) {
+	if len(dp.Images) == 0 {
+		return "", fmt.Errorf("hook %s: no image deployed", h.Name)
+	}
+	if h.ImageFrom == "" {
+		return dp.Images[0].Image, nil
+	}
+	for _, ci := range dp.Images {
+		if ci.Container == h.ImageFrom {
+			return ci.Image, nil
+		}
+	}
+	return "", fmt.Errorf("hook %s: no image deployed to container %s", h.Name, h.ImageFrom)
+}
+
+// logStreams are the log streams followed, closed when they don't end by themselves
+type logStreams struct {
+	sync.Mutex
+	sync.WaitGroup
+	open map[io.Closer]bool
+}
+
+func (l *logStreams) closeAll() {
+	l.Lock()
+	defer l.Unlock()
+	for c := range l.open {
+		c.Close()
+	}
+}
+
+// streamJobLogs follows the logs of the containers of the pods of the Job until stop is
+// closed, the streams still open 10s later, such as the ones of a Job timing out, are closed
+func streamJobLogs(kubeClient kubernetes.Interface, namespace, job, hook string, stop <-chan struct{}) {
+	pods := kubeClient.CoreV1().Pods(namespace)
+	followed := map[string]bool{}
+	streams := &logStreams{open: map[io.Closer]bool{}}
+
+	follow := func() {
+		list, err := pods.List(metav1.ListOptions{LabelSelector: "job-name=" + job})
+		if err != nil {
+			glog.Warningf("list pods of Job %s/%s error:%s", namespace, job, err)
+			return
+		}
+		for _, pod := range list.Items {
+			if pod.Status.Phase == corev1.PodPending {
+				continue
+			}
+			for _, c := range pod.Spec.Containers {
+				key := pod.Name + "/" + c.Name
+				if followed[key] {
+					continue
+				}
+				followed[key] = true
+				streams.Add(1)
+				go func(pod, container string) {
+					defer streams.Done()
+					streamLogs(kubeClient, namespace, pod, container, hook, streams)
+				}(pod.Name, c.Name)
+			}
+		}
+	}
+
+	for running := true; running; {
+		follow()
+		select {
+		case <-stop:
+			// the pods which ended since the last poll
+			follow()
+			running = false
+		case <-time.After(2 * time.Second):
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		streams.closeAll()
+		<-done
+	}
+}
+
+func streamLogs(kubeClient kubernetes.Interface, namespace, pod, container, hook string, streams *logStreams) {
+	stream, err := kubeClient.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container, Follow: true}).Stream()
+	if err != nil {
+		glog.Warningf("logs of %s/%s %s error:%s", namespace, pod, container, err)
+		return
+	}
+	streams.Lock()
+	streams.open[stream] = true
+	streams.Unlock()
+	defer func() {
+		streams.Lock()
+		delete(streams.open, stream)
+		streams.Unlock()
+		stream.Close()
+	}()
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		glog.Infof("[hook %s %s/%s] %s", hook, pod, container, scanner.Text())
+	}
+}
+
+// cleanupHooks deletes the Jobs of the hook beyond the retention, newest kept first
+func (dp *Deployer) cleanupHooks(kubeClient kubernetes.Interface, hook string) {
+	jobs := kubeClient.BatchV1().Jobs(dp.Namespace)
+	list, err := jobs.List(metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s=%s", hookLabel, dp.ServiceName, hookNameLabel, hook)})
+	if err != nil {
+		glog.Warningf("list Jobs of hook %s error:%s", hook, err)
+		return
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].CreationTimestamp.After(list.Items[j].CreationTimestamp.Time)
+	})
+
+	keepSucceeded, keepFailed := keep(dp.Hooks.KeepSucceeded, 1), keep(dp.Hooks.KeepFailed, 3)
+	succeeded, failed := 0, 0
+	background := metav1.DeletePropagationBackground
+	for _, job := range list.Items {
+		finished, ok := jobFinished(&job)
+		if !finished {
+			// still running, such as the Job of another deploy
+			continue
+		}
+		if ok {
+			succeeded++
+			if keepSucceeded < 0 || succeeded <= keepSucceeded {
+				continue
+			}
+		} else {
+			failed++
+			if keepFailed < 0 || failed <= keepFailed {
+				continue
+			}
+		}
+		if err := jobs.Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &background}); err != nil {
+			glog.Warningf("delete hook Job %s/%s error:%s", dp.Namespace, job.Name, err)
+			continue
+		}
+		glog.Infof("hook Job %s/%s deleted", dp.Namespace, job.Name)
+	}
+}
+
+// jobFinished tells whether the Job has a Complete or Failed condition, and which one
+func jobFinished(job *batchv1.Job) (finished, succeeded bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true
+		case batchv1.JobFailed:
+			return true, false
+		}
+	}
+	return false, false
+}
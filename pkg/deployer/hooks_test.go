@@ -0,0 +1,102 @@
+package deployer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHookJobName(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	if got := hookJobName("app", "migrate", now); got != "app-migrate-1600000000" {
+		t.Errorf("hookJobName = %s, want app-migrate-1600000000", got)
+	}
+	got := hookJobName(strings.Repeat("s", 40), "migrate-"+strings.Repeat("h", 20), now)
+	if len(got) > 63 || !strings.HasSuffix(got, "-1600000000") {
+		t.Errorf("hookJobName = %s (%d characters), want 63 at most with the time", got, len(got))
+	}
+	if got := hookJobName(strings.Repeat("s", 51), "migrate", now); strings.Contains(got, "--") {
+		t.Errorf("hookJobName = %s, want no dash left by the cut", got)
+	}
+}
+
+func TestHookJobDeadline(t *testing.T) {
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Images: []ContainerImage{{Image: "app:v1"}}}
+	h := Hook{Name: "migrate", Timeout: "90s", Job: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "migrate"}}},
+	}}}
+	job, err := dp.hookJob(HookPre, h)
+	if err != nil {
+		t.Fatalf("hookJob error:%s", err)
+	}
+	if d := job.Spec.ActiveDeadlineSeconds; d == nil || *d != 90 {
+		t.Errorf("activeDeadlineSeconds = %v, want 90", d)
+	}
+
+	deadline := int64(30)
+	h.Job.ActiveDeadlineSeconds = &deadline
+	if job, err = dp.hookJob(HookPre, h); err != nil {
+		t.Fatalf("hookJob error:%s", err)
+	}
+	if d := job.Spec.ActiveDeadlineSeconds; d == nil || *d != 30 {
+		t.Errorf("activeDeadlineSeconds = %v, want the 30 of the hook", d)
+	}
+}
+
+func TestCleanupHooks(t *testing.T) {
+	objects := []batchv1.Job{}
+	add := func(name string, age int, condition batchv1.JobConditionType) {
+		job := batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(time.Unix(1600000000-int64(age), 0)),
+			Labels:            map[string]string{hookLabel: "app", hookNameLabel: "migrate"},
+		}}
+		if condition != "" {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue}}
+		}
+		if condition == batchv1.JobComplete {
+			job.Status.Succeeded = 1
+		}
+		objects = append(objects, job)
+	}
+	// a running Job newer than the ones kept is neither deleted nor counted
+	add("running", 0, "")
+	add("succeeded-1", 1, batchv1.JobComplete)
+	add("failed-1", 2, batchv1.JobFailed)
+	add("succeeded-2", 3, batchv1.JobComplete)
+	add("failed-2", 4, batchv1.JobFailed)
+	add("failed-3", 5, batchv1.JobFailed)
+	add("running-old", 6, "")
+
+	kubeClient := fake.NewSimpleClientset()
+	for i := range objects {
+		if _, err := kubeClient.BatchV1().Jobs("default").Create(&objects[i]); err != nil {
+			t.Fatalf("create Job error:%s", err)
+		}
+	}
+	one, two := 1, 2
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Hooks: &HooksConfig{KeepSucceeded: &one, KeepFailed: &two}}
+	dp.cleanupHooks(kubeClient, "migrate")
+
+	list, err := kubeClient.BatchV1().Jobs("default").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list Jobs error:%s", err)
+	}
+	got := []string{}
+	for _, job := range list.Items {
+		got = append(got, job.Name)
+	}
+	sort.Strings(got)
+	want := []string{"failed-1", "failed-2", "running", "running-old", "succeeded-1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Jobs left = %v, want %v", got, want)
+	}
+}
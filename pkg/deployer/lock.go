@@ -0,0 +1,102 @@
+package deployer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/knative-sample/tekton-serving/pkg/lock"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// lockDuration is the duration of the deploy Lease, it is renewed every third of it
+const lockDuration = 30 * time.Second
+
+// lockName is the Lease serializing the deploys of the Service
+func lockName(service string) string {
+	return fmt.Sprintf("%s-deploy-lock", service)
+}
+
+// holder identifies the deployer in the Lease, the PipelineRun deploying when known
+func (dp *Deployer) holder() string {
+	host, _ := os.Hostname()
+	if dp.Provenance.PipelineRun != "" {
+		return dp.Provenance.PipelineRun + "/" + host
+	}
+	return host + "/" + strconv.Itoa(os.Getpid())
+}
+
+// lock takes the deploy Lease of the Service, it returns nil when LockTimeout is 0
+func (dp *Deployer) lock(kubeClient kubernetes.Interface) (*lock.Lease, error) {
+	if dp.LockTimeout <= 0 {
+		return nil, nil
+	}
+	lease := lock.NewLease(kubeClient, dp.Namespace, lockName(dp.ServiceName), dp.holder(), lockDuration)
+	if err := lease.Acquire(dp.LockTimeout); err != nil {
+		return nil, fmt.Errorf("%s/%s is being deployed by another deployer: %s", dp.Namespace, dp.ServiceName, err)
+	}
+	dp.lease = lease
+	return lease, nil
+}
+
+// checkLease fails once the deploy Lease is lost, another deployer may be deploying the
+// Service then, so it is checked before each change of the deploy
+func (dp *Deployer) checkLease() error {
+	if dp.lease == nil {
+		return nil
+	}
+	if err := dp.lease.Err(); err != nil {
+		return fmt.Errorf("%s %s/%s deploy stopped: %s", dp.kind(), dp.Namespace, dp.ServiceName, err)
+	}
+	return nil
+}
+
+// lockAll takes the Leases of the services in namespace/name order, so two releases
+// sharing services can't deadlock. On failure the Leases taken are released.
+func lockAll(kubeClient kubernetes.Interface, deployers []*Deployer) ([]*lock.Lease, error) {
+	sorted := append([]*Deployer{}, deployers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		return a.Namespace+"/"+a.ServiceName < b.Namespace+"/"+b.ServiceName
+	})
+	leases := []*lock.Lease{}
+	for _, sdp := range sorted {
+		lease, err := sdp.lock(kubeClient)
+		if err != nil {
+			unlockAll(leases)
+			return nil, err
+		}
+		if lease != nil {
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
+
+func unlockAll(leases []*lock.Lease) {
+	for i := len(leases) - 1; i >= 0; i-- {
+		leases[i].Release()
+	}
+}
+
+// checkOrder refuses to deploy a build older than the one the target runs, unless
+// AllowOlderBuild is set
+func (dp *Deployer) checkOrder(target Target) error {
+	if dp.AllowOlderBuild {
+		return nil
+	}
+	current, err := target.Provenance()
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if older, why := dp.Provenance.OlderThan(current); older {
+		return fmt.Errorf("%s %s/%s runs a newer build, %s. Use --allow-older-build to deploy it anyway", dp.kind(), dp.Namespace, dp.ServiceName, why)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package deployer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/tekton-serving/pkg/lock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDeployStopsOnLostLease(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(readyDeployment("app", false))
+	patched := 0
+	kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patched++
+		return false, nil, nil
+	})
+
+	// the Lease is renewed every 10ms, it is lost at the first renewal after the takeover
+	lease := lock.NewLease(kubeClient, "default", lockName("app"), "run-2", 30*time.Millisecond)
+	if err := lease.Acquire(time.Second); err != nil {
+		t.Fatalf("Acquire error:%s", err)
+	}
+	defer lease.Release()
+	leases := kubeClient.CoordinationV1().Leases("default")
+	l, err := leases.Get(lockName("app"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease error:%s", err)
+	}
+	other := "run-3"
+	l.Spec.HolderIdentity = &other
+	if _, err := leases.Update(l); err != nil {
+		t.Fatalf("take over the lease error:%s", err)
+	}
+	select {
+	case <-lease.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the lease taken over is not lost")
+	}
+
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Kind: KindDeployment, Concurrency: -1,
+		Images: []ContainerImage{{Image: "app:v2"}}, lease: lease}
+	target, err := dp.newTarget(nil, kubeClient, &release{})
+	if err != nil {
+		t.Fatalf("newTarget error:%s", err)
+	}
+	err = dp.deployTarget(kubeClient, target, &release{})
+	if err == nil || !strings.Contains(err.Error(), "taken over by run-3") {
+		t.Fatalf("deployTarget error:%v, want the lease taken over", err)
+	}
+	if patched != 0 {
+		t.Errorf("deployment patched %d times after the lease was lost", patched)
+	}
+}
@@ -0,0 +1,121 @@
+package deployer
+
+import (
+	"encoding/json"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/golang/glog"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// conflictBackoff is the backoff of the patches rejected because the Service changed
+// since it was read
+var conflictBackoff = wait.Backoff{
+	Steps:    6,
+	Duration: 200 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// patchService reads the latest Service, lets mutate change a copy of it and sends the
+// difference as a merge patch. The patch carries the resourceVersion that was read, so
+// a concurrent change makes it fail with a conflict, it is then retried on a fresh read.
+func patchService(servingClient servingclientset.Interface, namespace, name string, mutate func(svc *v1alpha1.Service) error) (*v1alpha1.Service, error) {
+	var result *v1alpha1.Service
+	err := retry.RetryOnConflict(conflictBackoff, func() error {
+		svc, err := servingClient.ServingV1alpha1().Services(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		desired := svc.DeepCopy()
+		if err := mutate(desired); err != nil {
+			return err
+		}
+
+		patch, err := mergePatch(svc, desired)
+		if err != nil {
+			return err
+		}
+		if patch == nil {
+			result = svc
+			return nil
+		}
+
+		glog.V(2).Infof("patch serving %s/%s: %s", namespace, name, patch)
+		result, err = servingClient.ServingV1alpha1().Services(namespace).Patch(name, types.MergePatchType, patch)
+		if errors.IsConflict(err) {
+			glog.Warningf("serving %s/%s changed since resourceVersion %s, retry", namespace, name, svc.ResourceVersion)
+		}
+		return err
+	})
+	return result, err
+}
+
+// patchObject is patchService for the other kinds: get reads the latest object, mutate
+// changes a copy of it and patch sends the merge patch
+func patchObject(get func() (metav1.Object, error), mutate func(obj metav1.Object) error, patch func(data []byte) error) error {
+	return retry.RetryOnConflict(conflictBackoff, func() error {
+		obj, err := get()
+		if err != nil {
+			return err
+		}
+
+		desired := obj.(runtime.Object).DeepCopyObject().(metav1.Object)
+		if err := mutate(desired); err != nil {
+			return err
+		}
+
+		data, err := mergePatch(obj, desired)
+		if err != nil || data == nil {
+			return err
+		}
+		glog.V(2).Infof("patch %s/%s: %s", obj.GetNamespace(), obj.GetName(), data)
+		err = patch(data)
+		if errors.IsConflict(err) {
+			glog.Warningf("%s/%s changed since resourceVersion %s, retry", obj.GetNamespace(), obj.GetName(), obj.GetResourceVersion())
+		}
+		return err
+	})
+}
+
+// mergePatch computes the merge patch from orig to desired guarded by the resourceVersion
+// of orig, nil when nothing changed
+func mergePatch(orig, desired metav1.Object) ([]byte, error) {
+	origBts, err := json.Marshal(orig)
+	if err != nil {
+		return nil, err
+	}
+	desiredBts, err := json.Marshal(desired)
+	if err != nil {
+		return nil, err
+	}
+	patchBts, err := jsonpatch.CreateMergePatch(origBts, desiredBts)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(patchBts, &patch); err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+
+	metadata, ok := patch["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		patch["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = orig.GetResourceVersion()
+	return json.Marshal(patch)
+}
@@ -0,0 +1,126 @@
+package deployer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func deployment(resourceVersion, image string) *appsv1.Deployment {
+	d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", ResourceVersion: resourceVersion}}
+	d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: image}}
+	return d
+}
+
+func TestMergePatch(t *testing.T) {
+	orig := deployment("42", "app:v1")
+
+	patch, err := mergePatch(orig, orig.DeepCopy())
+	if err != nil || patch != nil {
+		t.Fatalf("mergePatch of an unchanged object = %s, %v, want nothing", patch, err)
+	}
+
+	desired := orig.DeepCopy()
+	desired.Spec.Template.Spec.Containers[0].Image = "app:v2"
+	desired.Labels = map[string]string{"team": "web"}
+	patch, err = mergePatch(orig, desired)
+	if err != nil {
+		t.Fatalf("mergePatch error:%s", err)
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &got); err != nil {
+		t.Fatalf("parse patch %s error:%s", patch, err)
+	}
+	metadata := got["metadata"].(map[string]interface{})
+	if metadata["resourceVersion"] != "42" || metadata["labels"] == nil {
+		t.Errorf("metadata = %v, want the labels guarded by resourceVersion 42", metadata)
+	}
+	if _, ok := got["status"]; ok {
+		t.Errorf("patch %s has fields which didn't change", patch)
+	}
+}
+
+func TestPatchObjectConflict(t *testing.T) {
+	defer func(b wait.Backoff) { conflictBackoff = b }(conflictBackoff)
+	conflictBackoff = wait.Backoff{Steps: 3, Duration: time.Millisecond}
+
+	cases := []struct {
+		name      string
+		conflicts int
+		wantErr   bool
+		wantReads int
+	}{{
+		name:      "no conflict",
+		wantReads: 1,
+	}, {
+		name:      "retried on a fresh read after a conflict",
+		conflicts: 1,
+		wantReads: 2,
+	}, {
+		name:      "gives up when the conflicts go on",
+		conflicts: 3,
+		wantErr:   true,
+		wantReads: 3,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset(deployment("1", "app:v1"))
+			conflicts := c.conflicts
+			patches := []string{}
+			kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				patches = append(patches, string(action.(k8stesting.PatchAction).GetPatch()))
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "app", nil)
+				}
+				return false, nil, nil
+			})
+
+			deployments := kubeClient.AppsV1().Deployments("default")
+			reads := 0
+			err := patchObject(func() (metav1.Object, error) {
+				reads++
+				return deployments.Get("app", metav1.GetOptions{})
+			}, func(obj metav1.Object) error {
+				obj.(*appsv1.Deployment).Spec.Template.Spec.Containers[0].Image = "app:v2"
+				return nil
+			}, func(data []byte) error {
+				_, err := deployments.Patch("app", types.MergePatchType, data)
+				return err
+			})
+			if c.wantErr != (err != nil) {
+				t.Fatalf("patchObject error:%v, want an error: %v", err, c.wantErr)
+			}
+			if reads != c.wantReads {
+				t.Errorf("read %d times, want %d", reads, c.wantReads)
+			}
+			for _, p := range patches {
+				if !json.Valid([]byte(p)) || !strings.Contains(p, `"resourceVersion":"1"`) {
+					t.Errorf("patch %s is not guarded by the resourceVersion read", p)
+				}
+			}
+			if c.wantErr {
+				return
+			}
+			d, err := deployments.Get("app", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get Deployment error:%s", err)
+			}
+			if image := d.Spec.Template.Spec.Containers[0].Image; image != "app:v2" {
+				t.Errorf("image = %s, want app:v2", image)
+			}
+		})
+	}
+}
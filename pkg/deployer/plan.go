@@ -0,0 +1,191 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/utils/diff"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// The outcomes of the server-side dry-run of a plan
+const (
+	ServerDryRunSkipped     = "skipped"
+	ServerDryRunPassed      = "passed"
+	ServerDryRunUnsupported = "unsupported"
+	ServerDryRunRejected    = "rejected"
+)
+
+// Plan is what a deploy would write, nothing is written to compute it
+type Plan struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Path is the file of the object in GitOps mode
+	Path string
+	// Current is the YAML of the live object, empty when the deploy creates it.
+	// Desired is the YAML the deploy writes.
+	Current string
+	Desired string
+	// ServerDryRun is the outcome of the server-side dry-run, Message the admission
+	// error when it is rejected
+	ServerDryRun string
+	Message      string
+}
+
+// Changed tells the deploy changes the object
+func (p *Plan) Changed() bool {
+	return p.Current != p.Desired
+}
+
+// Diff is the unified diff from the live object to the desired one
+func (p *Plan) Diff() string {
+	from, to := "live/"+p.Kind+"/"+p.Namespace+"/"+p.Name, "desired/"+p.Kind+"/"+p.Namespace+"/"+p.Name
+	if p.Path != "" {
+		from, to = "a/"+p.Path, "b/"+p.Path
+	}
+	if p.Current == "" {
+		from = "/dev/null"
+	}
+	return diff.Unified(from, to, p.Current, p.Desired)
+}
+
+// Print writes the diff and the outcome of the server-side dry-run
+func (p *Plan) Print(w io.Writer) {
+	if !p.Changed() {
+		fmt.Fprintf(w, "%s %s/%s is up to date\n", p.Kind, p.Namespace, p.Name)
+	} else {
+		fmt.Fprint(w, p.Diff())
+	}
+	switch p.ServerDryRun {
+	case ServerDryRunRejected:
+		fmt.Fprintf(w, "server dry-run rejected %s %s/%s: %s\n", p.Kind, p.Namespace, p.Name, p.Message)
+	case ServerDryRunUnsupported:
+		fmt.Fprintf(w, "server dry-run is not supported for %s %s/%s: %s\n", p.Kind, p.Namespace, p.Name, p.Message)
+	case ServerDryRunPassed:
+		fmt.Fprintf(w, "server dry-run passed for %s %s/%s\n", p.Kind, p.Namespace, p.Name)
+	}
+}
+
+// Err is the admission error of the server-side dry-run
+func (p *Plan) Err() error {
+	if p.ServerDryRun != ServerDryRunRejected {
+		return nil
+	}
+	return fmt.Errorf("server dry-run rejected %s %s/%s: %s", p.Kind, p.Namespace, p.Name, p.Message)
+}
+
+// out is where the plan is printed
+func (dp *Deployer) out() io.Writer {
+	if dp.Out == nil {
+		return os.Stdout
+	}
+	return dp.Out
+}
+
+// newPlan builds the plan from the live object, nil when it is created, and the desired one
+func newPlan(kind string, current, desired runtime.Object) (*Plan, error) {
+	meta, err := metaOf(desired)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{Kind: kind, Namespace: meta.GetNamespace(), Name: meta.GetName(), ServerDryRun: ServerDryRunSkipped}
+	if current != nil {
+		if p.Current, err = planYaml(current); err != nil {
+			return nil, err
+		}
+	}
+	if p.Desired, err = planYaml(desired); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func metaOf(obj runtime.Object) (metav1.Object, error) {
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, fmt.Errorf("%T has no object metadata", obj)
+	}
+	return meta, nil
+}
+
+// planYaml is the YAML of the object without the status and the fields set by the
+// API server, which the deploy doesn't write
+func planYaml(obj runtime.Object) (string, error) {
+	bts, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return "", err
+	}
+	delete(m, "status")
+	if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+		for _, k := range []string{"resourceVersion", "generation", "uid", "selfLink", "creationTimestamp", "managedFields"} {
+			delete(metadata, k)
+		}
+	}
+	out, err := yaml.Marshal(m)
+	return string(out), err
+}
+
+// serverDryRun sends the desired object to the API server with dryRun=All, as a
+// merge patch from current or as a create when current is nil. The object returned by
+// the server, with the defaults and the mutating webhooks applied, replaces the desired
+// one in the plan.
+func (dp *Deployer) serverDryRun(p *Plan, client rest.Interface, resource string, current, desired runtime.Object) error {
+	if !dp.ServerDryRun {
+		return nil
+	}
+	into := reflect.New(reflect.TypeOf(desired).Elem()).Interface().(runtime.Object)
+	var err error
+	if current == nil {
+		body, merr := json.Marshal(desired)
+		if merr != nil {
+			return merr
+		}
+		err = client.Post().Namespace(p.Namespace).Resource(resource).
+			Param("dryRun", metav1.DryRunAll).Body(body).Do().Into(into)
+	} else {
+		patch, merr := mergePatch(current.(metav1.Object), desired.(metav1.Object))
+		if merr != nil {
+			return merr
+		}
+		if patch == nil {
+			p.ServerDryRun = ServerDryRunPassed
+			return nil
+		}
+		err = client.Patch(types.MergePatchType).Namespace(p.Namespace).Resource(resource).Name(p.Name).
+			Param("dryRun", metav1.DryRunAll).Body(patch).Do().Into(into)
+	}
+
+	switch {
+	case err == nil:
+		p.ServerDryRun = ServerDryRunPassed
+		p.Desired, err = planYaml(into)
+		return err
+	case dryRunUnsupported(err):
+		glog.Warningf("server dry-run of %s %s/%s is not supported: %s", p.Kind, p.Namespace, p.Name, err)
+		p.ServerDryRun, p.Message = ServerDryRunUnsupported, err.Error()
+	default:
+		p.ServerDryRun, p.Message = ServerDryRunRejected, err.Error()
+	}
+	return nil
+}
+
+// dryRunUnsupported tells the API server, or one of its webhooks, can't dry-run
+func dryRunUnsupported(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return apierrors.IsMethodNotSupported(err) || strings.Contains(msg, "dry run") || strings.Contains(msg, "dryrun")
+}
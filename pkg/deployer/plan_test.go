@@ -0,0 +1,214 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestNewPlan(t *testing.T) {
+	current := deployment("42", "app:v1")
+	current.UID = types.UID("0000")
+	current.Generation = 3
+	current.CreationTimestamp = metav1.Now()
+	current.Status.Replicas = 2
+	desired := current.DeepCopy()
+	desired.Spec.Template.Spec.Containers[0].Image = "app:v2"
+
+	p, err := newPlan("deployment", current, desired)
+	if err != nil {
+		t.Fatalf("newPlan error:%s", err)
+	}
+	// the fields of the metadata are indented once, the status is at the top
+	for _, field := range []string{"\n  resourceVersion:", "\n  uid:", "\n  generation:", "\n  creationTimestamp:", "\nstatus:"} {
+		if strings.Contains(p.Current, field) || strings.Contains(p.Desired, field) {
+			t.Errorf("plan has %q, the deploy doesn't write it:\n%s", field, p.Current)
+		}
+	}
+	if !p.Changed() || p.ServerDryRun != ServerDryRunSkipped || p.Err() != nil {
+		t.Errorf("plan = %+v, want a change not dry-run by the server", p)
+	}
+	want := "--- live/deployment/default/app\n+++ desired/deployment/default/app\n"
+	if d := p.Diff(); !strings.HasPrefix(d, want) || !strings.Contains(d, "-      - image: app:v1\n+      - image: app:v2\n") {
+		t.Errorf("diff =\n%s", d)
+	}
+
+	created, err := newPlan("deployment", nil, desired)
+	if err != nil {
+		t.Fatalf("newPlan error:%s", err)
+	}
+	if d := created.Diff(); !strings.HasPrefix(d, "--- /dev/null\n") {
+		t.Errorf("diff of a created object =\n%s", d)
+	}
+
+	unchanged, err := newPlan("deployment", current, current.DeepCopy())
+	if err != nil {
+		t.Fatalf("newPlan error:%s", err)
+	}
+	out := &bytes.Buffer{}
+	unchanged.Print(out)
+	if unchanged.Changed() || out.String() != "deployment default/app is up to date\n" {
+		t.Errorf("plan of an unchanged object printed %q", out)
+	}
+
+	gitopsPlan := &Plan{Kind: "gitops", Namespace: "default", Name: "app", Path: "deploy/app.yaml", Current: "a\n", Desired: "b\n"}
+	if d := gitopsPlan.Diff(); !strings.HasPrefix(d, "--- a/deploy/app.yaml\n+++ b/deploy/app.yaml\n") {
+		t.Errorf("diff of a file =\n%s", d)
+	}
+}
+
+func TestPlanPrint(t *testing.T) {
+	cases := []struct {
+		outcome string
+		want    string
+		wantErr bool
+	}{
+		{outcome: ServerDryRunSkipped},
+		{outcome: ServerDryRunPassed, want: "server dry-run passed for deployment default/app\n"},
+		{outcome: ServerDryRunUnsupported, want: "server dry-run is not supported for deployment default/app: no dry run\n"},
+		{outcome: ServerDryRunRejected, want: "server dry-run rejected deployment default/app: no dry run\n", wantErr: true},
+	}
+	for _, c := range cases {
+		p := &Plan{Kind: "deployment", Namespace: "default", Name: "app", Current: "a\n", Desired: "a\n", ServerDryRun: c.outcome, Message: "no dry run"}
+		out := &bytes.Buffer{}
+		p.Print(out)
+		if want := "deployment default/app is up to date\n" + c.want; out.String() != want {
+			t.Errorf("%s: printed %q, want %q", c.outcome, out, want)
+		}
+		if c.wantErr != (p.Err() != nil) {
+			t.Errorf("%s: Err = %v, want an error: %v", c.outcome, p.Err(), c.wantErr)
+		}
+	}
+}
+
+// apiServer answers the dry-run requests of the deployments with reply, a Deployment or a Status
+type apiServer struct {
+	code     int
+	reply    runtime.Object
+	requests []string
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+" "+string(body))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.code)
+	json.NewEncoder(w).Encode(s.reply)
+}
+
+func TestServerDryRun(t *testing.T) {
+	defaulted := deployment("42", "app:v2")
+	defaulted.Spec.Template.Spec.Containers[0].ImagePullPolicy = "IfNotPresent"
+	status := func(code int32, reason metav1.StatusReason, message string) *metav1.Status {
+		return &metav1.Status{TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}, Status: metav1.StatusFailure,
+			Code: code, Reason: reason, Message: message}
+	}
+
+	cases := []struct {
+		name         string
+		disabled     bool
+		create       bool
+		unchanged    bool
+		code         int
+		reply        runtime.Object
+		want         string
+		wantRequest  string
+		wantDefaults bool
+	}{{
+		name:     "disabled",
+		disabled: true,
+		want:     ServerDryRunSkipped,
+	}, {
+		name:         "patch passed",
+		code:         http.StatusOK,
+		reply:        defaulted,
+		want:         ServerDryRunPassed,
+		wantRequest:  "PATCH /apis/apps/v1/namespaces/default/deployments/app?dryRun=All",
+		wantDefaults: true,
+	}, {
+		name:         "create passed",
+		create:       true,
+		code:         http.StatusCreated,
+		reply:        defaulted,
+		want:         ServerDryRunPassed,
+		wantRequest:  "POST /apis/apps/v1/namespaces/default/deployments?dryRun=All",
+		wantDefaults: true,
+	}, {
+		name:      "nothing to patch",
+		unchanged: true,
+		want:      ServerDryRunPassed,
+	}, {
+		name:        "rejected by a webhook",
+		code:        http.StatusForbidden,
+		reply:       status(http.StatusForbidden, metav1.StatusReasonForbidden, `admission webhook "policy" denied the request: image app:v2 is not signed`),
+		want:        ServerDryRunRejected,
+		wantRequest: "PATCH /apis/apps/v1/namespaces/default/deployments/app?dryRun=All",
+	}, {
+		name:        "webhook without dry run",
+		code:        http.StatusBadRequest,
+		reply:       status(http.StatusBadRequest, metav1.StatusReasonBadRequest, `admission webhook "legacy" does not support dry run`),
+		want:        ServerDryRunUnsupported,
+		wantRequest: "PATCH /apis/apps/v1/namespaces/default/deployments/app?dryRun=All",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &apiServer{code: c.code, reply: c.reply}
+			server := httptest.NewServer(s)
+			defer server.Close()
+			kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			if err != nil {
+				t.Fatalf("new client error:%s", err)
+			}
+
+			var current runtime.Object = deployment("42", "app:v1")
+			desired := deployment("42", "app:v2")
+			if c.create {
+				current = nil
+			}
+			if c.unchanged {
+				desired = current.(*appsv1.Deployment).DeepCopy()
+			}
+			p, err := newPlan("deployment", current, desired)
+			if err != nil {
+				t.Fatalf("newPlan error:%s", err)
+			}
+			dp := &Deployer{ServerDryRun: !c.disabled}
+			if err := dp.serverDryRun(p, kubeClient.AppsV1().RESTClient(), "deployments", current, desired); err != nil {
+				t.Fatalf("serverDryRun error:%s", err)
+			}
+
+			if p.ServerDryRun != c.want {
+				t.Errorf("server dry-run %s (%s), want %s", p.ServerDryRun, p.Message, c.want)
+			}
+			if c.wantRequest == "" {
+				if len(s.requests) > 0 {
+					t.Errorf("requests %v, want none", s.requests)
+				}
+				return
+			}
+			if len(s.requests) != 1 || !strings.HasPrefix(s.requests[0], c.wantRequest) {
+				t.Fatalf("requests %v, want %s", s.requests, c.wantRequest)
+			}
+			if !strings.Contains(s.requests[0], "app:v2") {
+				t.Errorf("request %s doesn't send the new image", s.requests[0])
+			}
+			if c.wantDefaults != strings.Contains(p.Desired, "imagePullPolicy: IfNotPresent") {
+				t.Errorf("desired =\n%s\nwant the defaults of the server: %v", p.Desired, c.wantDefaults)
+			}
+			if c.want == ServerDryRunRejected && !strings.Contains(p.Err().Error(), "is not signed") {
+				t.Errorf("Err = %v, want the admission error", p.Err())
+			}
+		})
+	}
+}
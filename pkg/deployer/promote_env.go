@@ -0,0 +1,98 @@
+package deployer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/environment"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PromoteFrom deploys the images of the revision serving the Service of the source
+// environment, once the gates of the environment of dp pass. The provenance of the
+// source revision is carried over.
+func (dp *Deployer) PromoteFrom(source *Deployer, gates environment.Gates) error {
+	servingClient, _, err := source.newClients()
+	if err != nil {
+		return err
+	}
+	svc, err := servingClient.ServingV1alpha1().Services(source.Namespace).Get(source.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("get serving %s/%s of environment %s error:%s", source.Namespace, source.ServiceName, source.Environment, err)
+		return err
+	}
+
+	revision, percent := servingRevision(svc)
+	if revision == "" {
+		return fmt.Errorf("serving %s/%s of environment %s serves no revision", source.Namespace, source.ServiceName, source.Environment)
+	}
+	rev, err := servingClient.ServingV1alpha1().Revisions(source.Namespace).Get(revision, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("get revision %s/%s of environment %s error:%s", source.Namespace, revision, source.Environment, err)
+		return err
+	}
+	if err := checkGates(gates, svc, rev, percent); err != nil {
+		return fmt.Errorf("promote revision %s of environment %s to %s: %s", revision, source.Environment, dp.Environment, err)
+	}
+
+	images, err := revisionImages(rev)
+	if err != nil && !dp.AllowMutableTags {
+		return err
+	}
+	dp.Images = images
+
+	dp.Provenance.Fill(provenance.FromAnnotations(rev.Annotations))
+	glog.Infof("promote revision %s of environment %s, %d%% of the traffic, to environment %s: %v", revision, source.Environment, percent, dp.Environment, images)
+	return dp.Run()
+}
+
+// servingRevision is the revision serving the most traffic of the Service
+func servingRevision(svc *v1alpha1.Service) (string, int) {
+	revision, max := "", 0
+	for _, tt := range svc.Status.Traffic {
+		if tt.RevisionName != "" && tt.Percent > max {
+			revision, max = tt.RevisionName, tt.Percent
+		}
+	}
+	return revision, max
+}
+
+func checkGates(gates environment.Gates, svc *v1alpha1.Service, rev *v1alpha1.Revision, percent int) error {
+	if !gates.AllowNotReady && !svc.Status.IsReady() {
+		return fmt.Errorf("serving %s/%s is not ready", svc.Namespace, svc.Name)
+	}
+	if gates.FullTraffic && percent < 100 {
+		return fmt.Errorf("revision %s serves %d%% of the traffic, the gate requires 100%%", rev.Name, percent)
+	}
+	minSoak, err := gates.MinSoakDuration()
+	if err != nil {
+		return err
+	}
+	if age := time.Since(rev.CreationTimestamp.Time); age < minSoak {
+		return fmt.Errorf("revision %s is %s old, the gate requires %s", rev.Name, age.Round(time.Second), minSoak)
+	}
+	return nil
+}
+
+// revisionImages returns the images of the revision by container. The error tells an
+// image is not pinned to a digest, the images are returned anyway.
+func revisionImages(rev *v1alpha1.Revision) ([]ContainerImage, error) {
+	images := containerImages(rev.Spec.Containers)
+	if len(images) == 1 && !isDigest(images[0].Image) && rev.Status.ImageDigest != "" {
+		images[0].Image = rev.Status.ImageDigest
+	}
+	for _, ci := range images {
+		if !isDigest(ci.Image) {
+			return images, fmt.Errorf("image %s of revision %s is not pinned to a digest, use --allow-mutable-tags to promote the tag", ci.Image, rev.Name)
+		}
+	}
+	return images, nil
+}
+
+func isDigest(image string) bool {
+	return strings.Contains(image, "@sha256:")
+}
@@ -0,0 +1,328 @@
+package deployer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Release is the manifest of deployer apply, the services are rolled out together
+type Release struct {
+	Name string `json:"name,omitempty"`
+	// Namespace is the namespace of the services which don't set one
+	Namespace string `json:"namespace,omitempty"`
+	// Promote sends all the traffic to the new revisions of the Knative Services
+	Promote  bool             `json:"promote,omitempty"`
+	Services []ReleaseService `json:"services"`
+}
+
+// ReleaseService is one workload of the release
+type ReleaseService struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// Kind is one of Kinds, a Knative Service by default
+	Kind string `json:"kind,omitempty"`
+	// Image is the image of the first container, Images are --image values
+	Image     string   `json:"image,omitempty"`
+	Images    []string `json:"images,omitempty"`
+	Container string   `json:"container,omitempty"`
+	// DependsOn are the names of the services rolled out before this one
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Promote overrides the Promote of the release
+	Promote *bool `json:"promote,omitempty"`
+	// Hooks are the Jobs run before the service is updated and once it is ready
+	Hooks *HooksConfig `json:"hooks,omitempty"`
+	// GitOps is the repository the images of the service are written to
+	GitOps *gitops.Config `json:"gitops,omitempty"`
+}
+
+// LoadRelease reads a Release yaml file and checks its dependency graph
+func LoadRelease(path string) (*Release, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Release{}
+	if err := yaml.Unmarshal(bts, r); err != nil {
+		return nil, fmt.Errorf("parse release %s error:%s", path, err)
+	}
+	if len(r.Services) == 0 {
+		return nil, fmt.Errorf("release %s has no service", path)
+	}
+	for _, s := range r.Services {
+		if s.Name == "" {
+			return nil, fmt.Errorf("release %s has a service without name", path)
+		}
+		if s.Image == "" && len(s.Images) == 0 {
+			return nil, fmt.Errorf("service %s of release %s has no image", s.Name, path)
+		}
+		if s.Kind != "" {
+			if err := ValidKind(s.Kind); err != nil {
+				return nil, fmt.Errorf("service %s of release %s: %s", s.Name, path, err)
+			}
+		}
+		if s.Hooks != nil {
+			if err := s.Hooks.validate(); err != nil {
+				return nil, fmt.Errorf("service %s of release %s: %s", s.Name, path, err)
+			}
+		}
+		if s.GitOps != nil {
+			if err := s.GitOps.Validate(); err != nil {
+				return nil, fmt.Errorf("service %s of release %s: %s", s.Name, path, err)
+			}
+		}
+	}
+	if _, err := r.Order(); err != nil {
+		return nil, fmt.Errorf("release %s: %s", path, err)
+	}
+	return r, nil
+}
+
+// Order sorts the services so every service comes after its dependencies, services
+// without a dependency between them keep the order of the manifest
+func (r *Release) Order() ([]ReleaseService, error) {
+	index := map[string]int{}
+	for i, s := range r.Services {
+		if _, ok := index[s.Name]; ok {
+			return nil, fmt.Errorf("service %s is listed twice", s.Name)
+		}
+		index[s.Name] = i
+	}
+
+	pending := make([]int, len(r.Services))
+	dependents := make([][]int, len(r.Services))
+	for i, s := range r.Services {
+		for _, dep := range s.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("service %s depends on unknown service %s", s.Name, dep)
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	order := make([]ReleaseService, 0, len(r.Services))
+	done := make([]bool, len(r.Services))
+	for len(order) < len(r.Services) {
+		next := -1
+		for i := range r.Services {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			cycle := []string{}
+			for i, s := range r.Services {
+				if !done[i] {
+					cycle = append(cycle, s.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between services %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		order = append(order, r.Services[next])
+		for _, d := range dependents[next] {
+			pending[d]--
+		}
+	}
+	return order, nil
+}
+
+// forService is a copy of the Deployer for one service of the release
+func (dp *Deployer) forService(r *Release, s ReleaseService) (*Deployer, error) {
+	values := s.Images
+	if s.Image != "" {
+		values = append([]string{s.Image}, values...)
+	}
+	images, err := ParseImages(values, s.Container)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %s", s.Name, err)
+	}
+
+	sdp := *dp
+	sdp.Kind = s.Kind
+	sdp.ServiceName = s.Name
+	sdp.Images = images
+	sdp.Container = s.Container
+	sdp.Namespace = s.Namespace
+	if sdp.Namespace == "" {
+		sdp.Namespace = r.Namespace
+	}
+	if sdp.Namespace == "" {
+		sdp.Namespace = dp.Namespace
+	}
+	sdp.Promote = r.Promote
+	if s.Promote != nil {
+		sdp.Promote = *s.Promote
+	}
+	sdp.Hooks = s.Hooks
+	sdp.GitOps = s.GitOps
+	// the next service is only rolled out once this one is ready
+	sdp.Wait = true
+	return &sdp, nil
+}
+
+// applied is a service of the release already rolled out
+type applied struct {
+	dp     *Deployer
+	target Target
+	rel    *release
+	start  time.Time
+}
+
+// Apply rolls out the services of the release one by one in dependency order, each one
+// has to be ready before the next one starts. The services needing an approval are all
+// approved first. When a service fails, the services already updated are rolled back
+// in reverse order.
+func (dp *Deployer) Apply(r *Release) error {
+	order, err := r.Order()
+	if err != nil {
+		return err
+	}
+	deployers := make([]*Deployer, 0, len(order))
+	for _, s := range order {
+		sdp, err := dp.forService(r, s)
+		if err != nil {
+			return fmt.Errorf("release %s: %s", r.Name, err)
+		}
+		deployers = append(deployers, sdp)
+	}
+
+	servingClient, kubeClient, err := dp.newClients()
+	if err != nil {
+		return err
+	}
+
+	// a frozen service blocks the whole release before anything is rolled out
+	overrides := make([]*FreezeOverride, len(deployers))
+	for i, sdp := range deployers {
+		if overrides[i], err = sdp.checkFreeze(kubeClient); err != nil {
+			return fmt.Errorf("release %s: %s", r.Name, err)
+		}
+	}
+
+	// a service running a newer build blocks the release as well
+	pending := make([]applied, 0, len(deployers))
+	defer func() {
+		dp.writeReleaseResults(pending)
+	}()
+	for i, sdp := range deployers {
+		a := applied{dp: sdp, rel: &release{freezeOverride: overrides[i]}}
+		if a.target, err = sdp.newTarget(servingClient, kubeClient, a.rel); err != nil {
+			return fmt.Errorf("release %s: %s", r.Name, err)
+		}
+		defer closeTarget(a.target)
+		if err := sdp.checkOrder(a.target); err != nil {
+			return fmt.Errorf("release %s: %s", r.Name, err)
+		}
+		if err := sdp.resolveImages(kubeClient); err != nil {
+			return fmt.Errorf("release %s: %s", r.Name, err)
+		}
+		pending = append(pending, a)
+	}
+
+	// every service is approved before the Leases are taken and anything is rolled out:
+	// waiting for the reviewers doesn't block the other deploys, and a rejection leaves
+	// the release untouched instead of rolling back the services already live
+	for _, a := range pending {
+		start := time.Now()
+		if err := a.dp.approve(kubeClient, a.target, a.rel); err != nil {
+			a.dp.finish(kubeClient, a.rel, start, err)
+			return fmt.Errorf("release %s: %s %s/%s is not approved: %s", r.Name, a.dp.kind(), a.dp.Namespace, a.dp.ServiceName, err)
+		}
+	}
+
+	leases, err := lockAll(kubeClient, deployers)
+	if err != nil {
+		return fmt.Errorf("release %s: %s", r.Name, err)
+	}
+	defer unlockAll(leases)
+	if len(leases) > 0 {
+		// another deploy may have rolled a newer build during the approvals
+		for _, a := range pending {
+			if err := a.dp.checkOrder(a.target); err != nil {
+				return fmt.Errorf("release %s: %s", r.Name, err)
+			}
+		}
+	}
+
+	done := make([]applied, 0, len(order))
+	for _, a := range pending {
+		sdp := a.dp
+		glog.Infof("release %s: deploy %s %s/%s", r.Name, sdp.kind(), sdp.Namespace, sdp.ServiceName)
+		a.start = time.Now()
+		sdp.emitStarted()
+		err = sdp.runHooks(kubeClient, HookPre)
+		if err == nil {
+			err = sdp.deployTarget(kubeClient, a.target, a.rel)
+		}
+		if err != nil {
+			sdp.finish(kubeClient, a.rel, a.start, err)
+			err = fmt.Errorf("release %s: %s %s/%s failed: %s", r.Name, sdp.kind(), sdp.Namespace, sdp.ServiceName, err)
+			return dp.rollbackRelease(kubeClient, r, done, err)
+		}
+		done = append(done, a)
+	}
+
+	for _, a := range done {
+		a.dp.finish(kubeClient, a.rel, a.start, nil)
+	}
+	glog.Infof("release %s: %d services deployed", r.Name, len(done))
+	return nil
+}
+
+// writeReleaseResults writes the results of the services of the release rolled out,
+// in rollout order
+func (dp *Deployer) writeReleaseResults(pending []applied) {
+	results := []*Result{}
+	for _, a := range pending {
+		if a.rel.result != nil {
+			results = append(results, a.rel.result)
+		}
+	}
+	if len(results) > 0 {
+		dp.writeResults(results)
+	}
+}
+
+// rollbackRelease rolls back the services already updated, newest first, it returns
+// the cause with the services which couldn't be rolled back
+func (dp *Deployer) rollbackRelease(kubeClient kubernetes.Interface, r *Release, done []applied, cause error) error {
+	failed := []string{}
+	for i := len(done) - 1; i >= 0; i-- {
+		a := done[i]
+		name := fmt.Sprintf("%s/%s", a.dp.Namespace, a.dp.ServiceName)
+		glog.Warningf("release %s: rollback %s %s", r.Name, a.dp.kind(), name)
+
+		err := a.dp.checkLease()
+		if err == nil {
+			err = a.target.Rollback()
+		}
+		switch {
+		case err == ErrNoRollback:
+			glog.Warningf("release %s: %s %s was created by the release, it is left in place", r.Name, a.dp.kind(), name)
+			failed = append(failed, name)
+		case err != nil:
+			glog.Errorf("release %s: rollback %s %s error:%s", r.Name, a.dp.kind(), name, err)
+			failed = append(failed, name)
+		default:
+			a.rel.rolledBack = true
+		}
+		a.dp.finish(kubeClient, a.rel, a.start, cause)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s, not rolled back: %s", cause, strings.Join(failed, ", "))
+	}
+	if len(done) > 0 {
+		return fmt.Errorf("%s, %d services rolled back", cause, len(done))
+	}
+	return cause
+}
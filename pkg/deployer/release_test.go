@@ -0,0 +1,99 @@
+package deployer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/tekton-serving/pkg/approval"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// readyDeployment is a Deployment whose rollout is complete, or stuck past its progress
+// deadline when stuck is set
+func readyDeployment(name string, stuck bool) *appsv1.Deployment {
+	d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+	d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Image: name + ":v1"}}
+	d.Status.Replicas, d.Status.UpdatedReplicas, d.Status.AvailableReplicas = 1, 1, 1
+	if stuck {
+		d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"}}
+	}
+	return d
+}
+
+func TestApplyRollbackOrder(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(readyDeployment("db", false), readyDeployment("api", false), readyDeployment("web", true))
+	patched := []string{}
+	kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patched = append(patched, action.(k8stesting.PatchAction).GetName())
+		return false, nil, nil
+	})
+
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	r := &Release{Name: "shop", Namespace: "default", Services: []ReleaseService{
+		{Name: "web", Kind: KindDeployment, Image: "web" + digest, DependsOn: []string{"api"}},
+		{Name: "api", Kind: KindDeployment, Image: "api" + digest, DependsOn: []string{"db"}},
+		{Name: "db", Kind: KindDeployment, Image: "db" + digest},
+	}}
+	dp := &Deployer{Namespace: "default", Concurrency: -1, ReadyTimeout: time.Second, Out: ioutil.Discard, kubeClient: kubeClient}
+	err := dp.Apply(r)
+	if err == nil || !strings.Contains(err.Error(), "deployment default/web failed") {
+		t.Fatalf("Apply error:%v, want the failure of web", err)
+	}
+
+	// web is rolled back by its own deploy, then the services already live newest first
+	want := []string{"db", "api", "web", "web", "api", "db"}
+	if fmt.Sprint(patched) != fmt.Sprint(want) {
+		t.Errorf("patched %v, want %v", patched, want)
+	}
+	for _, name := range []string{"db", "api", "web"} {
+		d, err := kubeClient.AppsV1().Deployments("default").Get(name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get Deployment %s error:%s", name, err)
+		}
+		if image := d.Spec.Template.Spec.Containers[0].Image; image != name+":v1" {
+			t.Errorf("image of %s = %s, want it rolled back to %s:v1", name, image, name)
+		}
+	}
+}
+
+func TestApplyRejectedRelease(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(readyDeployment("db", false), readyDeployment("api", false))
+	patched := []string{}
+	kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patched = append(patched, action.(k8stesting.PatchAction).GetName())
+		return false, nil, nil
+	})
+	// db is approved and api rejected as soon as their requests are created
+	kubeClient.PrependReactor("create", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cm := action.(k8stesting.CreateAction).GetObject().(*corev1.ConfigMap)
+		cm.Annotations[approval.StateKey] = approval.StateApproved
+		if cm.Data["service"] == "api" {
+			cm.Annotations[approval.StateKey] = approval.StateRejected
+		}
+		cm.Annotations[approval.ByKey] = "alice"
+		return false, nil, nil
+	})
+
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	r := &Release{Name: "shop", Namespace: "default", Services: []ReleaseService{
+		{Name: "api", Kind: KindDeployment, Image: "api" + digest, DependsOn: []string{"db"}},
+		{Name: "db", Kind: KindDeployment, Image: "db" + digest},
+	}}
+	dp := &Deployer{Namespace: "default", Concurrency: -1, RequireApproval: true, ApprovalTimeout: time.Minute,
+		Out: ioutil.Discard, kubeClient: kubeClient}
+	err := dp.Apply(r)
+	if err == nil || !strings.Contains(err.Error(), "deployment default/api is not approved") {
+		t.Fatalf("Apply error:%v, want the rejection of api", err)
+	}
+	if len(patched) > 0 {
+		t.Errorf("patched %v before every service was approved", patched)
+	}
+}
@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Result is the machine readable outcome of a deploy, for the tasks following it
+type Result struct {
+	Service     string `json:"service"`
+	Namespace   string `json:"namespace"`
+	Kind        string `json:"kind"`
+	Environment string `json:"environment,omitempty"`
+	// Revision and Tag are the new revision and its tag, URL is its tag URL once routed
+	Revision string           `json:"revision,omitempty"`
+	Tag      string           `json:"tag,omitempty"`
+	URL      string           `json:"url,omitempty"`
+	Images   []ContainerImage `json:"images"`
+	// Digest is the digest of the first image, empty for a mutable tag
+	Digest string `json:"digest,omitempty"`
+	// Traffic is the traffic split of the Service after the deploy
+	Traffic    []TrafficSplit        `json:"traffic,omitempty"`
+	Duration   string                `json:"duration,omitempty"`
+	Outcome    string                `json:"outcome,omitempty"`
+	Message    string                `json:"message,omitempty"`
+	Provenance provenance.Provenance `json:"provenance"`
+	GitOps     *gitops.Result        `json:"gitops,omitempty"`
+}
+
+// TrafficSplit is one traffic target of the Service
+type TrafficSplit struct {
+	Revision string `json:"revision,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Percent  int    `json:"percent"`
+	Latest   bool   `json:"latestRevision,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// outcome is the outcome of the deploy and its message
+func outcome(rel *release, deployErr error) (string, string) {
+	if deployErr == nil {
+		return OutcomeSucceeded, ""
+	}
+	if rel != nil && rel.rolledBack {
+		return OutcomeRolledBack, deployErr.Error()
+	}
+	return OutcomeFailed, deployErr.Error()
+}
+
+// result builds the Result of the deploy, the traffic is the one of the last Service
+// the deploy read or wrote
+func (dp *Deployer) result(rel *release, start time.Time, deployErr error) *Result {
+	r := &Result{
+		Service:     dp.ServiceName,
+		Namespace:   dp.Namespace,
+		Kind:        dp.kind(),
+		Environment: dp.Environment,
+		Images:      dp.Images,
+		Duration:    time.Since(start).Round(time.Second).String(),
+		Provenance:  dp.Provenance,
+	}
+	r.Outcome, r.Message = outcome(rel, deployErr)
+	if parts := strings.SplitN(dp.primaryImage(), "@", 2); len(parts) == 2 {
+		r.Digest = parts[1]
+	}
+	if rel == nil {
+		return r
+	}
+	r.Revision, r.Tag, r.GitOps = rel.Revision, rel.Tag, rel.gitops
+	if svc := rel.service; svc != nil {
+		if rel.Tag != "" {
+			if u := tagURL(svc, rel.Tag); u != nil {
+				r.URL = u.String()
+			}
+		}
+		r.Traffic = trafficSplit(svc)
+	}
+	return r
+}
+
+// trafficSplit is the traffic of the Service spec with the URLs of its status
+func trafficSplit(svc *v1alpha1.Service) []TrafficSplit {
+	split := make([]TrafficSplit, 0, len(svc.Spec.Traffic))
+	for _, tt := range svc.Spec.Traffic {
+		ts := TrafficSplit{Revision: tt.RevisionName, Tag: tt.Tag, Percent: tt.Percent}
+		if tt.LatestRevision != nil {
+			ts.Latest = *tt.LatestRevision
+		}
+		if tt.Tag != "" {
+			if u := tagURL(svc, tt.Tag); u != nil {
+				ts.URL = u.String()
+			}
+		}
+		split = append(split, ts)
+	}
+	return split
+}
+
+// finish records the deploy in the history, keeps its result in rel, sends the event
+// of its outcome and notifies it
+func (dp *Deployer) finish(kubeClient kubernetes.Interface, rel *release, start time.Time, deployErr error) {
+	dp.recordHistory(kubeClient, rel, start, deployErr)
+	rel.result = dp.result(rel, start, deployErr)
+	dp.emitFinished(rel.result)
+	dp.notifyFinished(rel.result)
+}
+
+// writeResults writes the results to Out, to ResultFile and, for a single deploy, to
+// a file per field in ResultsDir. v is the Result of a deploy or the list of an apply.
+// Failing to write them doesn't fail the deploy.
+func (dp *Deployer) writeResults(v interface{}) {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		glog.Errorf("marshal deploy result error:%s", err)
+		return
+	}
+	fmt.Fprintf(dp.out(), "%s\n", bts)
+
+	if dp.ResultFile != "" {
+		if err := ioutil.WriteFile(dp.ResultFile, append(bts, '\n'), 0644); err != nil {
+			glog.Errorf("write deploy result to %s error:%s", dp.ResultFile, err)
+		}
+	}
+	if dp.ResultsDir == "" {
+		return
+	}
+	files := map[string]string{"result": string(bts)}
+	if r, ok := v.(*Result); ok {
+		traffic, _ := json.Marshal(r.Traffic)
+		for name, value := range map[string]string{
+			"service":   r.Service,
+			"namespace": r.Namespace,
+			"revision":  r.Revision,
+			"tag":       r.Tag,
+			"url":       r.URL,
+			"digest":    r.Digest,
+			"traffic":   string(traffic),
+			"duration":  r.Duration,
+			"outcome":   r.Outcome,
+		} {
+			files[name] = value
+		}
+	}
+	if err := os.MkdirAll(dp.ResultsDir, 0755); err != nil {
+		glog.Errorf("create results dir %s error:%s", dp.ResultsDir, err)
+		return
+	}
+	for name, value := range files {
+		// Tekton takes the content of the file as is, without a trailing newline
+		path := filepath.Join(dp.ResultsDir, name)
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			glog.Errorf("write result %s error:%s", path, err)
+		}
+	}
+}
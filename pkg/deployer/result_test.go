@@ -0,0 +1,167 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1beta1"
+)
+
+func TestOutcome(t *testing.T) {
+	failed := errors.New("revision app-00002 is not ready")
+	cases := []struct {
+		name        string
+		rel         *release
+		err         error
+		want        string
+		wantMessage string
+	}{
+		{name: "succeeded", rel: &release{}, want: OutcomeSucceeded},
+		{name: "failed", rel: &release{}, err: failed, want: OutcomeFailed, wantMessage: failed.Error()},
+		{name: "failed before the release", err: failed, want: OutcomeFailed, wantMessage: failed.Error()},
+		{name: "rolled back", rel: &release{rolledBack: true}, err: failed, want: OutcomeRolledBack, wantMessage: failed.Error()},
+	}
+	for _, c := range cases {
+		got, message := outcome(c.rel, c.err)
+		if got != c.want || message != c.wantMessage {
+			t.Errorf("%s: outcome = %s, %q, want %s, %q", c.name, got, message, c.want, c.wantMessage)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	svc := taggedService(t, "rev-2", "http://rev-2-app.default.example.com")
+	latest := true
+	svc.Spec.Traffic = []v1alpha1.TrafficTarget{{
+		TrafficTarget: v1beta1.TrafficTarget{RevisionName: "app-00001", Percent: 90},
+	}, {
+		TrafficTarget: v1beta1.TrafficTarget{Tag: "rev-2", LatestRevision: &latest, Percent: 10},
+	}}
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Environment: "production",
+		Images: []ContainerImage{{Image: "registry.example.com/app@sha256:aaaa"}, {Image: "envoy:1.12"}}}
+	rel := &release{Revision: "app-00002", Tag: "rev-2", service: svc}
+
+	r := dp.result(rel, time.Now().Add(-61*time.Second), nil)
+	if r.Kind != KindService || r.Environment != "production" || r.Outcome != OutcomeSucceeded {
+		t.Errorf("result = %+v", r)
+	}
+	if r.Revision != "app-00002" || r.Tag != "rev-2" || r.URL != "http://rev-2-app.default.example.com" {
+		t.Errorf("revision %s, tag %s, url %s, want the tag URL of app-00002", r.Revision, r.Tag, r.URL)
+	}
+	if r.Digest != "sha256:aaaa" {
+		t.Errorf("digest = %q, want the digest of the first image", r.Digest)
+	}
+	if r.Duration != "1m1s" {
+		t.Errorf("duration = %s, want 1m1s", r.Duration)
+	}
+	want := []TrafficSplit{
+		{Revision: "app-00001", Percent: 90},
+		{Tag: "rev-2", Percent: 10, Latest: true, URL: "http://rev-2-app.default.example.com"},
+	}
+	if len(r.Traffic) != len(want) {
+		t.Fatalf("traffic = %+v, want %+v", r.Traffic, want)
+	}
+	for i := range want {
+		if r.Traffic[i] != want[i] {
+			t.Errorf("traffic[%d] = %+v, want %+v", i, r.Traffic[i], want[i])
+		}
+	}
+
+	dp.Images = []ContainerImage{{Image: "app:v2"}}
+	r = dp.result(nil, time.Now(), errors.New("no such service"))
+	if r.Digest != "" || r.Revision != "" || r.Traffic != nil || r.Outcome != OutcomeFailed {
+		t.Errorf("result of a failed deploy with a mutable tag = %+v", r)
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy-results-")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &Result{Service: "app", Namespace: "default", Kind: KindService, Revision: "app-00002", Tag: "rev-2",
+		URL: "http://rev-2-app.default.example.com", Images: []ContainerImage{{Image: "app@sha256:aaaa"}},
+		Digest: "sha256:aaaa", Traffic: []TrafficSplit{{Revision: "app-00002", Percent: 100}},
+		Duration: "1m1s", Outcome: OutcomeSucceeded}
+	out := &bytes.Buffer{}
+	// the results dir is created with its parents
+	resultsDir := filepath.Join(dir, "tekton", "results")
+	dp := &Deployer{Out: out, ResultFile: filepath.Join(dir, "result.json"), ResultsDir: resultsDir}
+	dp.writeResults(r)
+
+	line := out.String()
+	if !strings.HasSuffix(line, "}\n") || strings.Count(line, "\n") != 1 {
+		t.Errorf("printed %q, want a JSON line", line)
+	}
+	bts, err := ioutil.ReadFile(dp.ResultFile)
+	if err != nil {
+		t.Fatalf("read result file error:%s", err)
+	}
+	if string(bts) != line {
+		t.Errorf("result file = %q, want the printed line %q", bts, line)
+	}
+	got := &Result{}
+	if err := json.Unmarshal(bts, got); err != nil {
+		t.Fatalf("unmarshal result file error:%s", err)
+	}
+	if got.Revision != r.Revision || got.URL != r.URL || got.Digest != r.Digest || len(got.Traffic) != 1 || got.Traffic[0] != r.Traffic[0] {
+		t.Errorf("result file = %+v, want %+v", got, r)
+	}
+
+	want := map[string]string{
+		"result":    strings.TrimSuffix(line, "\n"),
+		"service":   "app",
+		"namespace": "default",
+		"revision":  "app-00002",
+		"tag":       "rev-2",
+		"url":       "http://rev-2-app.default.example.com",
+		"digest":    "sha256:aaaa",
+		"traffic":   `[{"revision":"app-00002","percent":100}]`,
+		"duration":  "1m1s",
+		"outcome":   OutcomeSucceeded,
+	}
+	for name, value := range want {
+		bts, err := ioutil.ReadFile(filepath.Join(resultsDir, name))
+		if err != nil {
+			t.Errorf("read result %s error:%s", name, err)
+			continue
+		}
+		if string(bts) != value {
+			t.Errorf("result %s = %q, want %q", name, bts, value)
+		}
+	}
+
+	// an apply writes its list as the result only
+	listDir := filepath.Join(dir, "list")
+	dp = &Deployer{Out: &bytes.Buffer{}, ResultsDir: listDir}
+	dp.writeResults([]*Result{r, r})
+	files, err := ioutil.ReadDir(listDir)
+	if err != nil {
+		t.Fatalf("read results dir error:%s", err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if strings.Join(names, ",") != "result" {
+		t.Errorf("results of a list = %v, want the result only", names)
+	}
+	bts, err = ioutil.ReadFile(filepath.Join(listDir, "result"))
+	if err != nil {
+		t.Fatalf("read result error:%s", err)
+	}
+	list := []*Result{}
+	if err := json.Unmarshal(bts, &list); err != nil || len(list) != 2 {
+		t.Errorf("result of a list = %s, %v, want the two results", bts, err)
+	}
+}
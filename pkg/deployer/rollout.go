@@ -0,0 +1,302 @@
+package deployer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/approval"
+	"github.com/knative-sample/tekton-serving/pkg/gitops"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// release is the revision rolled out by one deploy
+type release struct {
+	// Revision and Tag are empty when the Service is created
+	Revision string
+	Tag      string
+	// previous is the Service before the deploy, it is restored on rollback
+	previous *v1alpha1.Service
+	// dropped are the revisions dropped from the traffic by the retention
+	dropped []string
+	// rolledBack is set once the previous state of the target is restored
+	rolledBack bool
+	// approval is the decision on the deploy when it required an approval
+	approval *approval.Decision
+	// freezeOverride is set when the deploy overrode a freeze
+	freezeOverride *FreezeOverride
+	// gitops is the commit the images were pushed as in GitOps mode
+	gitops *gitops.Result
+	// service is the last state of the Service the deploy read or wrote
+	service *v1alpha1.Service
+	// result is the outcome of the deploy once it is finished
+	result *Result
+}
+
+// rollout waits for the new revision, runs the smoke checks against its tag URL, walks
+// the canary steps and promotes it when Promote is set. A revision failing to become
+// ready, failing the smoke checks or the canary analysis is rolled back by the caller.
+func (dp *Deployer) rollout(servingClient servingclientset.Interface, rel *release, timeout time.Duration) error {
+	if dp.Smoke == nil && dp.Analysis == nil && !dp.Promote && !dp.Wait {
+		return nil
+	}
+
+	svc, err := dp.waitReady(servingClient, rel, timeout)
+	if err != nil {
+		return err
+	}
+	rel.service = svc
+
+	if dp.Smoke != nil {
+		if err := dp.smoke(svc, rel); err != nil {
+			return err
+		}
+	}
+
+	if dp.Analysis != nil && len(dp.Analysis.Steps) > 0 {
+		if err := dp.canary(servingClient, rel); err != nil {
+			return err
+		}
+	}
+
+	if dp.Promote {
+		return dp.promote(servingClient, rel)
+	}
+	return nil
+}
+
+// canary moves the traffic step by step from the stable revision to the new one, each
+// step is served for the analysis interval and then judged by the Analyzer
+func (dp *Deployer) canary(servingClient servingclientset.Interface, rel *release) error {
+	if rel.Revision == "" {
+		return nil
+	}
+	stable := stableRevision(rel.previous)
+	if stable == "" {
+		glog.Warningf("serving %s/%s has no stable revision, skip the canary steps", dp.Namespace, dp.ServiceName)
+		return nil
+	}
+	interval, err := dp.Analysis.interval()
+	if err != nil {
+		return fmt.Errorf("invalid analysis interval %q: %s", dp.Analysis.Interval, err)
+	}
+
+	analyzer := dp.Analyzer
+	if analyzer == nil {
+		analyzer = &PrometheusAnalyzer{
+			Address:   dp.Analysis.Prometheus,
+			Namespace: dp.Namespace,
+			Service:   dp.ServiceName,
+			Metrics:   dp.Analysis.Metrics,
+		}
+	}
+
+	for _, percent := range dp.Analysis.Steps {
+		if err := dp.checkLease(); err != nil {
+			return err
+		}
+		if err := dp.splitTraffic(servingClient, rel.Revision, stable, percent); err != nil {
+			return err
+		}
+		glog.Infof("canary revision %s of serving %s/%s at %d%%, stable revision %s", rel.Revision, dp.Namespace, dp.ServiceName, percent, stable)
+
+		inconclusive := 0
+		for {
+			time.Sleep(interval)
+			verdict, err := analyzer.Analyze(rel.Revision, stable)
+			if err != nil {
+				glog.Warningf("analyze revision %s error:%s", rel.Revision, err)
+				verdict = VerdictInconclusive
+			}
+			glog.Infof("canary revision %s at %d%%: %s", rel.Revision, percent, verdict)
+
+			if verdict == VerdictPass {
+				break
+			}
+			if verdict == VerdictFail {
+				return fmt.Errorf("canary analysis of revision %s failed at %d%%", rel.Revision, percent)
+			}
+			inconclusive++
+			if inconclusive > dp.Analysis.InconclusiveLimit {
+				return fmt.Errorf("canary analysis of revision %s inconclusive %d times at %d%%", rel.Revision, inconclusive, percent)
+			}
+		}
+	}
+	return nil
+}
+
+// stableRevision is the revision serving the most traffic before the deploy
+func stableRevision(svc *v1alpha1.Service) string {
+	if svc == nil {
+		return ""
+	}
+	stable, _ := servingRevision(svc)
+	return stable
+}
+
+// splitTraffic sends percent of the traffic to the canary and the rest to the stable revision
+func (dp *Deployer) splitTraffic(servingClient servingclientset.Interface, canary, stable string, percent int) error {
+	_, err := patchService(servingClient, dp.Namespace, dp.ServiceName, func(svc *v1alpha1.Service) error {
+		hasCanary, hasStable := false, false
+		for i := range svc.Spec.Traffic {
+			switch svc.Spec.Traffic[i].RevisionName {
+			case canary:
+				svc.Spec.Traffic[i].Percent = percent
+				hasCanary = true
+			case stable:
+				if !hasStable {
+					svc.Spec.Traffic[i].Percent = 100 - percent
+					hasStable = true
+					continue
+				}
+				svc.Spec.Traffic[i].Percent = 0
+			default:
+				svc.Spec.Traffic[i].Percent = 0
+			}
+		}
+		if !hasCanary || !hasStable {
+			return fmt.Errorf("revisions %s and %s are not both in the traffic of serving %s/%s", canary, stable, dp.Namespace, dp.ServiceName)
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("split traffic of serving %s/%s error:%s", dp.Namespace, dp.ServiceName, err)
+	}
+	return err
+}
+
+// waitReady waits until the revision is ready and routed, the Service itself when
+// the release has no revision
+func (dp *Deployer) waitReady(servingClient servingclientset.Interface, rel *release, timeout time.Duration) (*v1alpha1.Service, error) {
+	var svc *v1alpha1.Service
+	err := wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		var err error
+		svc, err = servingClient.ServingV1alpha1().Services(dp.Namespace).Get(dp.ServiceName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if svc.Status.ObservedGeneration < svc.Generation {
+			return false, nil
+		}
+
+		if rel.Revision == "" {
+			return svc.Status.IsReady(), nil
+		}
+
+		rev, err := servingClient.ServingV1alpha1().Revisions(dp.Namespace).Get(rel.Revision, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if cond := rev.Status.GetCondition(v1alpha1.RevisionConditionReady); cond != nil && cond.IsFalse() {
+			return false, fmt.Errorf("revision %s/%s failed: %s %s", dp.Namespace, rel.Revision, cond.Reason, cond.Message)
+		}
+		if !rev.Status.IsReady() {
+			return false, nil
+		}
+		return tagURL(svc, rel.Tag) != nil, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("revision %s of serving %s/%s is not ready after %s", rel.Revision, dp.Namespace, dp.ServiceName, timeout)
+	}
+	if err != nil {
+		glog.Errorf("wait serving %s/%s error:%s", dp.Namespace, dp.ServiceName, err)
+		return nil, err
+	}
+	glog.Infof("revision %s of serving %s/%s is ready", rel.Revision, dp.Namespace, dp.ServiceName)
+	return svc, nil
+}
+
+// tagURL returns the URL of the traffic target with the tag, the Service URL for an empty tag
+func tagURL(svc *v1alpha1.Service, tag string) *url.URL {
+	if tag == "" {
+		if svc.Status.URL == nil {
+			return nil
+		}
+		return (*url.URL)(svc.Status.URL)
+	}
+	for _, tt := range svc.Status.Traffic {
+		if tt.Tag == tag && tt.URL != nil {
+			return (*url.URL)(tt.URL)
+		}
+	}
+	return nil
+}
+
+// smoke runs the smoke checks against the tag URL, or against IngressAddress with the
+// tag host as Host header
+func (dp *Deployer) smoke(svc *v1alpha1.Service, rel *release) error {
+	u := tagURL(svc, rel.Tag)
+	if u == nil {
+		return fmt.Errorf("serving %s/%s has no url for tag %q", dp.Namespace, dp.ServiceName, rel.Tag)
+	}
+
+	tester := &SmokeTester{BaseURL: u.String()}
+	if dp.IngressAddress != "" {
+		tester.BaseURL = dp.IngressAddress
+		if !strings.Contains(tester.BaseURL, "://") {
+			tester.BaseURL = "http://" + tester.BaseURL
+		}
+		tester.Host = u.Host
+	}
+	glog.Infof("smoke revision %s with %d checks at %s host:%s", rel.Revision, len(dp.Smoke.Checks), tester.BaseURL, tester.Host)
+	return tester.Run(dp.Smoke)
+}
+
+// promote sends all the traffic to the new revision, the other targets keep their tags
+func (dp *Deployer) promote(servingClient servingclientset.Interface, rel *release) error {
+	if rel.Revision == "" {
+		return nil
+	}
+	if err := dp.checkLease(); err != nil {
+		return err
+	}
+	svc, err := patchService(servingClient, dp.Namespace, dp.ServiceName, func(svc *v1alpha1.Service) error {
+		found := false
+		for i := range svc.Spec.Traffic {
+			if svc.Spec.Traffic[i].RevisionName == rel.Revision {
+				svc.Spec.Traffic[i].Percent = 100
+				found = true
+				continue
+			}
+			svc.Spec.Traffic[i].Percent = 0
+		}
+		if !found {
+			return fmt.Errorf("revision %s is not in the traffic of serving %s/%s", rel.Revision, dp.Namespace, dp.ServiceName)
+		}
+		return nil
+	})
+	if err != nil {
+		glog.Errorf("promote revision %s of serving %s/%s error:%s", rel.Revision, dp.Namespace, dp.ServiceName, err)
+		return err
+	}
+	rel.service = svc
+	glog.Infof("promote revision %s of serving %s/%s to 100%%", rel.Revision, dp.Namespace, dp.ServiceName)
+	return nil
+}
+
+// rollback restores the template and the traffic of the Service before the deploy
+func (dp *Deployer) rollback(servingClient servingclientset.Interface, rel *release) error {
+	if rel.previous == nil {
+		return ErrNoRollback
+	}
+	svc, err := patchService(servingClient, dp.Namespace, dp.ServiceName, func(svc *v1alpha1.Service) error {
+		svc.Spec.Template = rel.previous.Spec.Template.DeepCopy()
+		svc.Spec.Traffic = rel.previous.Spec.Traffic
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	rel.service = svc
+	glog.Infof("serving %s/%s rolled back from revision %s", dp.Namespace, dp.ServiceName, rel.Revision)
+	return nil
+}
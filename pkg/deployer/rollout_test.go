@@ -0,0 +1,67 @@
+package deployer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/knative/pkg/apis"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1beta1"
+)
+
+// taggedService is a Service routing the tag to the URL
+func taggedService(t *testing.T, tag, rawurl string) *v1alpha1.Service {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatalf("parse %s error:%s", rawurl, err)
+	}
+	svc := &v1alpha1.Service{}
+	svc.Status.Traffic = []v1alpha1.TrafficTarget{{
+		TrafficTarget: v1beta1.TrafficTarget{Tag: tag, RevisionName: "app-00002", URL: (*apis.URL)(u)},
+	}}
+	return svc
+}
+
+func TestSmokeTagURL(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Smoke: &SmokeConfig{Checks: []SmokeCheck{{Path: "/healthz"}}}}
+	rel := &release{Revision: "app-00002", Tag: "test-1"}
+	if err := dp.smoke(taggedService(t, "test-1", srv.URL), rel); err != nil {
+		t.Fatalf("smoke error:%s", err)
+	}
+
+	// through the ingress, the tag host is the Host header
+	dp.IngressAddress = srv.Listener.Addr().String()
+	if err := dp.smoke(taggedService(t, "test-1", "http://test-1-app.default.example.com"), rel); err != nil {
+		t.Fatalf("smoke through the ingress error:%s", err)
+	}
+	if host != "test-1-app.default.example.com" {
+		t.Errorf("Host = %s, want the tag host", host)
+	}
+
+	if err := dp.smoke(taggedService(t, "test-0", srv.URL), rel); err == nil {
+		t.Errorf("smoke of a tag without URL passed")
+	}
+}
+
+func TestSmokeFailingRevision(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	dp := &Deployer{Namespace: "default", ServiceName: "app", Smoke: &SmokeConfig{RetryInterval: "1ms", Checks: []SmokeCheck{{Retries: 1}}}}
+	if err := dp.smoke(taggedService(t, "test-1", srv.URL), &release{Revision: "app-00002", Tag: "test-1"}); err == nil {
+		t.Errorf("smoke of a failing revision passed")
+	}
+}
@@ -0,0 +1,166 @@
+package deployer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+// SmokeConfig is the --smoke-config file
+type SmokeConfig struct {
+	// RetryInterval is the wait between the retries of a failed check, 2s by default
+	RetryInterval string       `json:"retryInterval,omitempty"`
+	Checks        []SmokeCheck `json:"checks"`
+}
+
+// SmokeCheck is one HTTP request sent to the new revision
+type SmokeCheck struct {
+	Name    string            `json:"name,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// ExpectStatus is the expected status code, 200 by default
+	ExpectStatus int `json:"expectStatus,omitempty"`
+	// BodyRegex must match the response body when set
+	BodyRegex string `json:"bodyRegex,omitempty"`
+	// MaxLatency is the latency budget of the request, such as 500ms
+	MaxLatency string `json:"maxLatency,omitempty"`
+	// Retries is the number of times a failed check is retried
+	Retries int `json:"retries,omitempty"`
+	// Warmup is the number of requests sent before the check, their result is ignored
+	Warmup int `json:"warmup,omitempty"`
+}
+
+// LoadSmokeConfig reads a SmokeConfig yaml file
+func LoadSmokeConfig(path string) (*SmokeConfig, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SmokeConfig{}
+	if err := yaml.Unmarshal(bts, cfg); err != nil {
+		return nil, fmt.Errorf("parse smoke config %s error:%s", path, err)
+	}
+	return cfg, nil
+}
+
+// SmokeTester sends the checks to BaseURL. When Host is set it is sent as the Host
+// header, so the checks can go through the ingress address to a tagged revision.
+type SmokeTester struct {
+	BaseURL string
+	Host    string
+	Client  *http.Client
+}
+
+// Run runs every check, it fails on the first check failing after its retries
+func (t *SmokeTester) Run(cfg *SmokeConfig) error {
+	interval := 2 * time.Second
+	if cfg.RetryInterval != "" {
+		d, err := time.ParseDuration(cfg.RetryInterval)
+		if err != nil {
+			return fmt.Errorf("invalid smoke retryInterval %q: %s", cfg.RetryInterval, err)
+		}
+		interval = d
+	}
+
+	for i, check := range cfg.Checks {
+		name := check.Name
+		if name == "" {
+			name = fmt.Sprintf("check-%d", i)
+		}
+
+		for w := 0; w < check.Warmup; w++ {
+			if err := t.check(check); err != nil {
+				glog.V(2).Infof("smoke check %s warmup %d: %s", name, w, err)
+			}
+		}
+
+		var err error
+		for attempt := 0; attempt <= check.Retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(interval)
+			}
+			if err = t.check(check); err == nil {
+				break
+			}
+			glog.Warningf("smoke check %s attempt %d failed: %s", name, attempt+1, err)
+		}
+		if err != nil {
+			return fmt.Errorf("smoke check %s failed: %s", name, err)
+		}
+		glog.Infof("smoke check %s passed", name)
+	}
+	return nil
+}
+
+func (t *SmokeTester) check(check SmokeCheck) error {
+	method := check.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := check.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	expect := check.ExpectStatus
+	if expect == 0 {
+		expect = http.StatusOK
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(t.BaseURL, "/")+path, strings.NewReader(check.Body))
+	if err != nil {
+		return err
+	}
+	if t.Host != "" {
+		req.Host = t.Host
+	}
+	for k, v := range check.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	latency := time.Since(start)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != expect {
+		return fmt.Errorf("%s %s: status %d, expected %d", method, path, resp.StatusCode, expect)
+	}
+	if check.BodyRegex != "" {
+		re, err := regexp.Compile(check.BodyRegex)
+		if err != nil {
+			return fmt.Errorf("invalid bodyRegex %q: %s", check.BodyRegex, err)
+		}
+		if !re.Match(body) {
+			return fmt.Errorf("%s %s: body does not match %q", method, path, check.BodyRegex)
+		}
+	}
+	if check.MaxLatency != "" {
+		budget, err := time.ParseDuration(check.MaxLatency)
+		if err != nil {
+			return fmt.Errorf("invalid maxLatency %q: %s", check.MaxLatency, err)
+		}
+		if latency > budget {
+			return fmt.Errorf("%s %s: latency %s over budget %s", method, path, latency, budget)
+		}
+	}
+	return nil
+}
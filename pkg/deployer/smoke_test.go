@@ -0,0 +1,147 @@
+package deployer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingServer answers the requests with the handler of their number, starting at 1
+type countingServer struct {
+	mu    sync.Mutex
+	count int
+	srv   *httptest.Server
+}
+
+func newCountingServer(handler func(n int, w http.ResponseWriter, r *http.Request)) *countingServer {
+	s := &countingServer{}
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.count++
+		n := s.count
+		s.mu.Unlock()
+		handler(n, w, r)
+	}))
+	return s
+}
+
+func (s *countingServer) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+func TestSmokeChecks(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler func(n int, w http.ResponseWriter, r *http.Request)
+		check   SmokeCheck
+		wantErr string
+		// requests is the number of requests the checks must send, not checked when 0
+		requests int
+	}{{
+		name:    "status",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) {},
+		check:   SmokeCheck{Path: "healthz"},
+	}, {
+		name:    "unexpected status",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) },
+		check:   SmokeCheck{Path: "/healthz"},
+		wantErr: "status 503, expected 200",
+	}, {
+		name: "expected status, method, headers and body",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Method != http.MethodPost || r.Header.Get("X-Smoke") != "1" || string(body) != `{"a":1}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		},
+		check: SmokeCheck{Path: "/items", Method: http.MethodPost, Headers: map[string]string{"X-Smoke": "1"}, Body: `{"a":1}`, ExpectStatus: http.StatusCreated},
+	}, {
+		name:    "body regex",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"status":"ok","version":"v2"}`)) },
+		check:   SmokeCheck{BodyRegex: `"version":"v2"`},
+	}, {
+		name:    "body regex mismatch",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"status":"ok","version":"v1"}`)) },
+		check:   SmokeCheck{BodyRegex: `"version":"v2"`},
+		wantErr: "body does not match",
+	}, {
+		name:    "latency within budget",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) {},
+		check:   SmokeCheck{MaxLatency: "5s"},
+	}, {
+		name:    "latency over budget",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) { time.Sleep(100 * time.Millisecond) },
+		check:   SmokeCheck{MaxLatency: "10ms"},
+		wantErr: "over budget 10ms",
+	}, {
+		name: "passes after retries",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) {
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		},
+		check:    SmokeCheck{Retries: 2},
+		requests: 3,
+	}, {
+		name: "fails when the retries are exhausted",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) {
+			if n < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		},
+		check:    SmokeCheck{Retries: 1},
+		wantErr:  "status 502",
+		requests: 2,
+	}, {
+		name: "warmup failures are ignored",
+		handler: func(n int, w http.ResponseWriter, r *http.Request) {
+			if n <= 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		},
+		check:    SmokeCheck{Warmup: 3},
+		requests: 4,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := newCountingServer(c.handler)
+			defer srv.srv.Close()
+			tester := &SmokeTester{BaseURL: srv.srv.URL}
+			err := tester.Run(&SmokeConfig{RetryInterval: "1ms", Checks: []SmokeCheck{c.check}})
+			switch {
+			case c.wantErr == "" && err != nil:
+				t.Fatalf("Run error:%s", err)
+			case c.wantErr != "" && err == nil:
+				t.Fatalf("Run passed, want an error with %q", c.wantErr)
+			case c.wantErr != "" && !strings.Contains(err.Error(), c.wantErr):
+				t.Fatalf("Run error:%s, want %q", err, c.wantErr)
+			}
+			if c.requests > 0 && srv.requests() != c.requests {
+				t.Errorf("sent %d requests, want %d", srv.requests(), c.requests)
+			}
+		})
+	}
+}
+
+func TestSmokeInvalidConfig(t *testing.T) {
+	srv := newCountingServer(func(n int, w http.ResponseWriter, r *http.Request) {})
+	defer srv.srv.Close()
+	tester := &SmokeTester{BaseURL: srv.srv.URL}
+	for _, cfg := range []*SmokeConfig{
+		{RetryInterval: "soon"},
+		{Checks: []SmokeCheck{{BodyRegex: "("}}},
+		{Checks: []SmokeCheck{{MaxLatency: "fast"}}},
+	} {
+		if err := tester.Run(cfg); err == nil {
+			t.Errorf("Run(%+v) passed, want an error", cfg)
+		}
+	}
+}
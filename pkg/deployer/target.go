@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The kinds of workload the deployer can update
+const (
+	KindService       = "service"
+	KindConfiguration = "configuration"
+	KindDeployment    = "deployment"
+	KindStatefulSet   = "statefulset"
+	KindDaemonSet     = "daemonset"
+)
+
+// Kinds are the values accepted by --kind
+var Kinds = []string{KindService, KindConfiguration, KindDeployment, KindStatefulSet, KindDaemonSet}
+
+// ErrNoRollback is returned by Rollback when the target has no previous state, such as
+// a Service the deploy created
+var ErrNoRollback = errors.New("nothing to roll back to")
+
+// Target is a workload the images are deployed to
+type Target interface {
+	// Get returns the images of the containers of the workload
+	Get() ([]ContainerImage, error)
+	// Provenance returns the provenance stamped on the template of the workload
+	Provenance() (provenance.Provenance, error)
+	// UpdateImage deploys the images and remembers the previous state for Rollback
+	UpdateImage(images []ContainerImage) error
+	// WaitReady waits until the updated workload is ready or fails
+	WaitReady(timeout time.Duration) error
+	// Rollback restores the workload as it was before UpdateImage
+	Rollback() error
+	// DryRun computes what UpdateImage would write without writing it
+	DryRun(images []ContainerImage) (*Plan, error)
+}
+
+// ValidKind checks the --kind value
+func ValidKind(kind string) error {
+	for _, k := range Kinds {
+		if kind == k {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown kind %q, expected one of %s", kind, strings.Join(Kinds, ", "))
+}
+
+// kind is the kind of the target, a Knative Service by default
+func (dp *Deployer) kind() string {
+	if dp.Kind == "" {
+		return KindService
+	}
+	return dp.Kind
+}
+
+// newTarget builds the Target of the kind, the Knative targets fill rel
+func (dp *Deployer) newTarget(servingClient servingclientset.Interface, kubeClient kubernetes.Interface, rel *release) (Target, error) {
+	if dp.GitOps != nil {
+		return &gitopsTarget{dp: dp, cfg: dp.GitOps, rel: rel}, nil
+	}
+	switch dp.kind() {
+	case KindService:
+		return &serviceTarget{dp: dp, client: servingClient, rel: rel}, nil
+	case KindConfiguration:
+		return &configurationTarget{dp: dp, client: servingClient, rel: rel}, nil
+	case KindDeployment:
+		return newDeploymentTarget(dp, kubeClient), nil
+	case KindStatefulSet:
+		return newStatefulSetTarget(dp, kubeClient), nil
+	case KindDaemonSet:
+		return newDaemonSetTarget(dp, kubeClient), nil
+	}
+	return nil, ValidKind(dp.Kind)
+}
+
+// deployTarget updates the images of the target and rolls it back when the update
+// doesn't become ready or a post-deploy hook fails
+func (dp *Deployer) deployTarget(kubeClient kubernetes.Interface, target Target, rel *release) error {
+	current, err := target.Get()
+	if err != nil && !apierrors.IsNotFound(err) {
+		glog.Errorf("get %s %s/%s error:%s", dp.kind(), dp.Namespace, dp.ServiceName, err)
+		return err
+	}
+	glog.Infof("deploy %v to %s %s/%s, current images %v", dp.Images, dp.kind(), dp.Namespace, dp.ServiceName, current)
+
+	if err := dp.checkLease(); err != nil {
+		return err
+	}
+	if err := target.UpdateImage(dp.Images); err != nil {
+		glog.Errorf("update %s %s/%s error:%s", dp.kind(), dp.Namespace, dp.ServiceName, err)
+		return err
+	}
+
+	timeout := dp.ReadyTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	cause := target.WaitReady(timeout)
+	if cause == nil {
+		cause = dp.runHooks(kubeClient, HookPost)
+	}
+	if cause == nil {
+		return nil
+	}
+
+	if err := dp.checkLease(); err != nil {
+		return fmt.Errorf("%s, not rolled back: %s", cause, err)
+	}
+	glog.Warningf("rollback %s %s/%s: %s", dp.kind(), dp.Namespace, dp.ServiceName, cause)
+	err = target.Rollback()
+	if err == ErrNoRollback {
+		return cause
+	}
+	if err != nil {
+		glog.Errorf("rollback %s %s/%s error:%s", dp.kind(), dp.Namespace, dp.ServiceName, err)
+		return fmt.Errorf("%s, rollback failed: %s", cause, err)
+	}
+	rel.rolledBack = true
+	return fmt.Errorf("%s, rolled back", cause)
+}
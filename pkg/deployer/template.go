@@ -0,0 +1,308 @@
+package deployer
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative/serving/pkg/apis/autoscaling"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/knative/serving/pkg/apis/serving/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// MergePolicyImage only updates the image of an existing Service, the template
+	// is used when the Service is created for the first time
+	MergePolicyImage = "image"
+	// MergePolicyMerge merges the template into the existing Service, fields set in
+	// the template win over the live ones
+	MergePolicyMerge = "merge"
+	// MergePolicyReplace replaces the revision template of the existing Service
+	// with the one from the template
+	MergePolicyReplace = "replace"
+)
+
+// TemplateArgs are the values available to the placeholders of --service-template
+type TemplateArgs struct {
+	Image       string
+	Namespace   string
+	ServiceName string
+	Port        string
+}
+
+// loadServiceTemplate renders --service-template and parses it as a Knative Service
+func (dp *Deployer) loadServiceTemplate() (*v1alpha1.Service, error) {
+	tmpl, err := template.ParseFiles(dp.ServiceTemplate)
+	if err != nil {
+		glog.Errorf("parse service template %s error:%s ", dp.ServiceTemplate, err.Error())
+		return nil, err
+	}
+
+	args := &TemplateArgs{
+		Image:       dp.primaryImage(),
+		Namespace:   dp.Namespace,
+		ServiceName: dp.ServiceName,
+		Port:        dp.Port,
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, args); err != nil {
+		glog.Errorf("render service template %s error:%s ", dp.ServiceTemplate, err.Error())
+		return nil, err
+	}
+
+	jsonbts, err := yaml.YAMLToJSON(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("service template %s is not valid yaml: %s", dp.ServiceTemplate, err)
+	}
+	svc := &v1alpha1.Service{}
+	if err := yaml.Unmarshal(jsonbts, svc); err != nil {
+		glog.Errorf("parse Service Object error:%s ", err.Error())
+		return nil, err
+	}
+
+	svc.Namespace = dp.Namespace
+	svc.Name = dp.ServiceName
+	if svc.Spec.Template == nil {
+		svc.Spec.Template = &v1alpha1.RevisionTemplateSpec{}
+	}
+	return svc, nil
+}
+
+// newService builds the Service created when it does not exist yet
+func (dp *Deployer) newService() (*v1alpha1.Service, error) {
+	newSvc := &v1alpha1.Service{}
+	if dp.ServiceTemplate != "" {
+		tmpl, err := dp.loadServiceTemplate()
+		if err != nil {
+			return nil, err
+		}
+		newSvc = tmpl
+	} else {
+		newSvc.Namespace = dp.Namespace
+		newSvc.Name = dp.ServiceName
+		newSvc.Spec.Template = &v1alpha1.RevisionTemplateSpec{
+			Spec: v1alpha1.RevisionSpec{
+				RevisionSpec: v1beta1.RevisionSpec{
+					PodSpec: v1beta1.PodSpec{
+						Containers: dp.newContainers(),
+					},
+				},
+			},
+		}
+	}
+
+	if err := dp.setImages(newSvc.Spec.Template.Spec.Containers); err != nil {
+		return nil, err
+	}
+	if err := dp.applyOverrides(newSvc.Spec.Template); err != nil {
+		return nil, err
+	}
+	dp.stampProvenance(&newSvc.Spec.Template.ObjectMeta)
+	return newSvc, nil
+}
+
+// reconcileTemplate applies --service-template to an existing Service according
+// to the merge policy
+func (dp *Deployer) reconcileTemplate(svc *v1alpha1.Service) error {
+	if dp.ServiceTemplate == "" || dp.MergePolicy == "" || dp.MergePolicy == MergePolicyImage {
+		return nil
+	}
+
+	tmpl, err := dp.loadServiceTemplate()
+	if err != nil {
+		return err
+	}
+
+	switch dp.MergePolicy {
+	case MergePolicyReplace:
+		svc.Spec.Template = tmpl.Spec.Template.DeepCopy()
+	case MergePolicyMerge:
+		if svc.Spec.Template == nil {
+			svc.Spec.Template = &v1alpha1.RevisionTemplateSpec{}
+		}
+		mergeRevisionTemplate(svc.Spec.Template, tmpl.Spec.Template)
+	default:
+		return fmt.Errorf("unknown merge policy %q, valid policies are %s, %s and %s", dp.MergePolicy, MergePolicyImage, MergePolicyMerge, MergePolicyReplace)
+	}
+
+	if svc.Labels == nil {
+		svc.Labels = map[string]string{}
+	}
+	for k, v := range tmpl.Labels {
+		svc.Labels[k] = v
+	}
+	return nil
+}
+
+// mergeRevisionTemplate merges the fields set in tmpl into live. Labels, annotations
+// and env are merged by key, every other field set in tmpl replaces the live one.
+// Containers are matched by name, or by position when they have no name.
+func mergeRevisionTemplate(live, tmpl *v1alpha1.RevisionTemplateSpec) {
+	live.Labels = mergeMap(live.Labels, tmpl.Labels)
+	live.Annotations = mergeMap(live.Annotations, tmpl.Annotations)
+
+	if tmpl.Spec.ServiceAccountName != "" {
+		live.Spec.ServiceAccountName = tmpl.Spec.ServiceAccountName
+	}
+	if len(tmpl.Spec.Volumes) > 0 {
+		live.Spec.Volumes = tmpl.Spec.Volumes
+	}
+	if tmpl.Spec.ContainerConcurrency != 0 {
+		live.Spec.ContainerConcurrency = tmpl.Spec.ContainerConcurrency
+	}
+	if tmpl.Spec.TimeoutSeconds != nil {
+		live.Spec.TimeoutSeconds = tmpl.Spec.TimeoutSeconds
+	}
+
+	for i, tc := range tmpl.Spec.Containers {
+		idx := -1
+		for j, lc := range live.Spec.Containers {
+			if (tc.Name != "" && lc.Name == tc.Name) || (tc.Name == "" && j == i) {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			live.Spec.Containers = append(live.Spec.Containers, tc)
+			continue
+		}
+		mergeContainer(&live.Spec.Containers[idx], &tc)
+	}
+}
+
+func mergeContainer(live, tmpl *corev1.Container) {
+	if len(tmpl.Ports) > 0 {
+		live.Ports = tmpl.Ports
+	}
+	if len(tmpl.Command) > 0 {
+		live.Command = tmpl.Command
+	}
+	if len(tmpl.Args) > 0 {
+		live.Args = tmpl.Args
+	}
+	if len(tmpl.EnvFrom) > 0 {
+		live.EnvFrom = tmpl.EnvFrom
+	}
+	for _, env := range tmpl.Env {
+		live.Env = setEnv(live.Env, env)
+	}
+	if len(tmpl.Resources.Limits) > 0 || len(tmpl.Resources.Requests) > 0 {
+		live.Resources = tmpl.Resources
+	}
+	if len(tmpl.VolumeMounts) > 0 {
+		live.VolumeMounts = tmpl.VolumeMounts
+	}
+	if tmpl.LivenessProbe != nil {
+		live.LivenessProbe = tmpl.LivenessProbe
+	}
+	if tmpl.ReadinessProbe != nil {
+		live.ReadinessProbe = tmpl.ReadinessProbe
+	}
+	if tmpl.SecurityContext != nil {
+		live.SecurityContext = tmpl.SecurityContext
+	}
+}
+
+// applyOverrides applies --env, --env-from-secret, --min-scale, --max-scale,
+// --concurrency and --port on top of the revision template. The env and the port are
+// the ones of the --container container, or of the container of the first --image, the
+// other containers are left as they are.
+func (dp *Deployer) applyOverrides(rt *v1alpha1.RevisionTemplateSpec) error {
+	idx, err := dp.containerIndex(rt.Spec.Containers, dp.primaryContainer())
+	if err != nil {
+		return err
+	}
+	container := &rt.Spec.Containers[idx]
+
+	for _, kv := range dp.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid --env %q, expected NAME=VALUE", kv)
+		}
+		container.Env = setEnv(container.Env, corev1.EnvVar{Name: parts[0], Value: parts[1]})
+	}
+
+	for _, secret := range dp.EnvFromSecret {
+		found := false
+		for _, ef := range container.EnvFrom {
+			if ef.SecretRef != nil && ef.SecretRef.Name == secret {
+				found = true
+				break
+			}
+		}
+		if !found {
+			container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+				},
+			})
+		}
+	}
+
+	if dp.Port != "" {
+		port, err := strconv.ParseInt(dp.Port, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid --port %q: %s", dp.Port, err)
+		}
+		// keep the name, such as h2c for gRPC, and the protocol of the port
+		ports := append([]corev1.ContainerPort{}, container.Ports...)
+		if len(ports) == 0 {
+			ports = append(ports, corev1.ContainerPort{})
+		}
+		ports[0].ContainerPort = int32(port)
+		container.Ports = ports
+	}
+
+	if dp.MinScale != "" {
+		rt.Annotations = mergeMap(rt.Annotations, map[string]string{autoscaling.MinScaleAnnotationKey: dp.MinScale})
+	}
+	if dp.MaxScale != "" {
+		rt.Annotations = mergeMap(rt.Annotations, map[string]string{autoscaling.MaxScaleAnnotationKey: dp.MaxScale})
+	}
+	if dp.Concurrency >= 0 {
+		rt.Spec.ContainerConcurrency = v1beta1.RevisionContainerConcurrencyType(dp.Concurrency)
+	}
+
+	return nil
+}
+
+// stampProvenance sets the provenance labels and annotations of the revision or pod template,
+// the ones of the previous revision are removed so a revision never carries a stale commit
+func (dp *Deployer) stampProvenance(meta *metav1.ObjectMeta) {
+	for _, key := range provenance.Keys {
+		delete(meta.Labels, key)
+		delete(meta.Annotations, key)
+	}
+	meta.Labels = mergeMap(meta.Labels, dp.Provenance.Labels())
+	meta.Annotations = mergeMap(meta.Annotations, dp.Provenance.Annotations())
+}
+
+func setEnv(envs []corev1.EnvVar, env corev1.EnvVar) []corev1.EnvVar {
+	for i := range envs {
+		if envs[i].Name == env.Name {
+			envs[i] = env
+			return envs
+		}
+	}
+	return append(envs, env)
+}
+
+func mergeMap(dst, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = map[string]string{}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,268 @@
+package deployer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestApplyOverridesPort(t *testing.T) {
+	cases := []struct {
+		name  string
+		ports []corev1.ContainerPort
+		want  []corev1.ContainerPort
+	}{{
+		name: "no port",
+		want: []corev1.ContainerPort{{ContainerPort: 9090}},
+	}, {
+		name:  "named port",
+		ports: []corev1.ContainerPort{{Name: "h2c", ContainerPort: 8080, Protocol: corev1.ProtocolTCP}},
+		want:  []corev1.ContainerPort{{Name: "h2c", ContainerPort: 9090, Protocol: corev1.ProtocolTCP}},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rt := &v1alpha1.RevisionTemplateSpec{}
+			rt.Spec.Containers = []corev1.Container{{Name: "app", Ports: c.ports}}
+			dp := &Deployer{Port: "9090"}
+			if err := dp.applyOverrides(rt); err != nil {
+				t.Fatalf("applyOverrides error:%s", err)
+			}
+			got := rt.Spec.Containers[0].Ports
+			if len(got) != len(c.want) || got[0] != c.want[0] {
+				t.Errorf("ports = %+v, want %+v", got, c.want)
+			}
+			if len(c.ports) > 0 && c.ports[0].ContainerPort != 8080 {
+				t.Errorf("the ports of the template were changed: %+v", c.ports)
+			}
+		})
+	}
+}
+
+func TestApplyOverridesContainer(t *testing.T) {
+	cases := []struct {
+		name      string
+		dp        *Deployer
+		want      string
+		wantError bool
+	}{{
+		name: "first container by default",
+		dp:   &Deployer{},
+		want: "app",
+	}, {
+		name: "--container",
+		dp:   &Deployer{Container: "sidecar"},
+		want: "sidecar",
+	}, {
+		name: "container of the first --image",
+		dp:   &Deployer{Images: []ContainerImage{{Container: "sidecar", Image: "proxy:v2"}}},
+		want: "sidecar",
+	}, {
+		name:      "unknown container",
+		dp:        &Deployer{Container: "db"},
+		wantError: true,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rt := &v1alpha1.RevisionTemplateSpec{}
+			rt.Spec.Containers = []corev1.Container{{Name: "app"}, {Name: "sidecar"}}
+			c.dp.Env = []string{"MODE=canary"}
+			c.dp.Concurrency = -1
+			err := c.dp.applyOverrides(rt)
+			if c.wantError {
+				if err == nil {
+					t.Fatalf("applyOverrides passed, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyOverrides error:%s", err)
+			}
+			for _, container := range rt.Spec.Containers {
+				if got := len(container.Env) == 1; got != (container.Name == c.want) {
+					t.Errorf("env of %s = %v, want it on %s only", container.Name, container.Env, c.want)
+				}
+			}
+		})
+	}
+}
+
+// writeTemplate writes the --service-template file in a temporary directory
+func writeTemplate(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	path := filepath.Join(dir, "service.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write template error:%s", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+const serviceTemplate = `apiVersion: serving.knative.dev/v1alpha1
+kind: Service
+metadata:
+  name: ignored
+  labels:
+    team: web
+spec:
+  template:
+    metadata:
+      annotations:
+        autoscaling.knative.dev/target: "10"
+    spec:
+      serviceAccountName: web
+      containers:
+      - name: app
+        image: "{{.Image}}"
+        env:
+        - name: SERVICE
+          value: "{{.ServiceName}}.{{.Namespace}}"
+        - name: LOG_LEVEL
+          value: info
+      - name: sidecar
+        image: proxy:v1
+`
+
+func TestNewServiceFromTemplate(t *testing.T) {
+	path, remove := writeTemplate(t, serviceTemplate)
+	defer remove()
+
+	dp := &Deployer{
+		Namespace:       "prod",
+		ServiceName:     "web",
+		ServiceTemplate: path,
+		Images:          []ContainerImage{{Image: "app@sha256:aaaa"}},
+		Port:            "9090",
+		Concurrency:     -1,
+	}
+	dp.Provenance.Commit = "0123456789abcdef"
+	svc, err := dp.newService()
+	if err != nil {
+		t.Fatalf("newService error:%s", err)
+	}
+	if svc.Namespace != "prod" || svc.Name != "web" {
+		t.Errorf("Service %s/%s, want prod/web", svc.Namespace, svc.Name)
+	}
+	if svc.Labels["team"] != "web" {
+		t.Errorf("labels = %v, want the ones of the template", svc.Labels)
+	}
+	spec := svc.Spec.Template.Spec
+	if spec.ServiceAccountName != "web" || len(spec.Containers) != 2 {
+		t.Fatalf("revision spec = %+v, want the one of the template", spec)
+	}
+	app, sidecar := spec.Containers[0], spec.Containers[1]
+	if app.Image != "app@sha256:aaaa" || sidecar.Image != "proxy:v1" {
+		t.Errorf("images = %s, %s, want app@sha256:aaaa, proxy:v1", app.Image, sidecar.Image)
+	}
+	if len(app.Env) != 2 || app.Env[0].Value != "web.prod" {
+		t.Errorf("env = %v, want the placeholders rendered", app.Env)
+	}
+	if len(app.Ports) != 1 || app.Ports[0].ContainerPort != 9090 {
+		t.Errorf("ports = %v, want 9090", app.Ports)
+	}
+	if got := svc.Spec.Template.Annotations[provenance.CommitKey]; got != "0123456789abcdef" {
+		t.Errorf("commit annotation = %q, want the provenance", got)
+	}
+}
+
+func TestReconcileTemplate(t *testing.T) {
+	path, remove := writeTemplate(t, serviceTemplate)
+	defer remove()
+
+	live := func() *v1alpha1.Service {
+		svc := &v1alpha1.Service{}
+		svc.Labels = map[string]string{"owner": "ops"}
+		svc.Spec.Template = &v1alpha1.RevisionTemplateSpec{}
+		svc.Spec.Template.Spec.ServiceAccountName = "default"
+		svc.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name:  "app",
+			Image: "app:live",
+			Env:   []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "REGION", Value: "eu"}},
+		}}
+		return svc
+	}
+	env := func(c corev1.Container) map[string]string {
+		m := map[string]string{}
+		for _, e := range c.Env {
+			m[e.Name] = e.Value
+		}
+		return m
+	}
+
+	cases := []struct {
+		name    string
+		policy  string
+		check   func(t *testing.T, svc *v1alpha1.Service)
+		wantErr bool
+	}{{
+		name:   "image keeps the live Service",
+		policy: MergePolicyImage,
+		check: func(t *testing.T, svc *v1alpha1.Service) {
+			if svc.Spec.Template.Spec.ServiceAccountName != "default" || len(svc.Spec.Template.Spec.Containers) != 1 {
+				t.Errorf("revision spec = %+v, want the live one", svc.Spec.Template.Spec)
+			}
+			if svc.Labels["team"] != "" {
+				t.Errorf("labels = %v, want the live ones", svc.Labels)
+			}
+		},
+	}, {
+		name:   "merge sets the fields of the template",
+		policy: MergePolicyMerge,
+		check: func(t *testing.T, svc *v1alpha1.Service) {
+			spec := svc.Spec.Template.Spec
+			if spec.ServiceAccountName != "web" || len(spec.Containers) != 2 {
+				t.Fatalf("revision spec = %+v, want the template merged", spec)
+			}
+			if spec.Containers[0].Image != "app:live" {
+				t.Errorf("image = %s, want the live one, the deploy sets it", spec.Containers[0].Image)
+			}
+			got := env(spec.Containers[0])
+			if got["LOG_LEVEL"] != "info" || got["REGION"] != "eu" || got["SERVICE"] == "" {
+				t.Errorf("env = %v, want the live env merged with the template", got)
+			}
+			if svc.Labels["owner"] != "ops" || svc.Labels["team"] != "web" {
+				t.Errorf("labels = %v, want both", svc.Labels)
+			}
+		},
+	}, {
+		name:   "replace takes the revision template",
+		policy: MergePolicyReplace,
+		check: func(t *testing.T, svc *v1alpha1.Service) {
+			spec := svc.Spec.Template.Spec
+			if spec.ServiceAccountName != "web" || len(spec.Containers) != 2 {
+				t.Fatalf("revision spec = %+v, want the template", spec)
+			}
+			if got := env(spec.Containers[0]); got["REGION"] != "" || got["LOG_LEVEL"] != "info" {
+				t.Errorf("env = %v, want the env of the template only", got)
+			}
+		},
+	}, {
+		name:    "unknown policy",
+		policy:  "patch",
+		wantErr: true,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dp := &Deployer{Namespace: "prod", ServiceName: "web", ServiceTemplate: path, MergePolicy: c.policy,
+				Images: []ContainerImage{{Image: "app:new"}}}
+			svc := live()
+			err := dp.reconcileTemplate(svc)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("reconcileTemplate passed, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reconcileTemplate error:%s", err)
+			}
+			c.check(t, svc)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package deployer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// workloadTarget is an apps/v1 workload, the functions hide the kind
+type workloadTarget struct {
+	dp *Deployer
+	// get reads the workload
+	get func() (metav1.Object, error)
+	// template is the pod template of the workload
+	template func(obj metav1.Object) *corev1.PodTemplateSpec
+	// patch sends a merge patch
+	patch func(data []byte) error
+	// ready tells whether every pod runs the current template
+	ready func(obj metav1.Object) (bool, error)
+	// client and resource send the server-side dry-run
+	client   rest.Interface
+	resource string
+
+	previous *corev1.PodTemplateSpec
+}
+
+func newDeploymentTarget(dp *Deployer, kubeClient kubernetes.Interface) *workloadTarget {
+	deployments := kubeClient.AppsV1().Deployments(dp.Namespace)
+	return &workloadTarget{
+		dp:       dp,
+		client:   kubeClient.AppsV1().RESTClient(),
+		resource: "deployments",
+		get: func() (metav1.Object, error) {
+			return deployments.Get(dp.ServiceName, metav1.GetOptions{})
+		},
+		template: func(obj metav1.Object) *corev1.PodTemplateSpec {
+			return &obj.(*appsv1.Deployment).Spec.Template
+		},
+		patch: func(data []byte) error {
+			_, err := deployments.Patch(dp.ServiceName, types.MergePatchType, data)
+			return err
+		},
+		ready: func(obj metav1.Object) (bool, error) {
+			d := obj.(*appsv1.Deployment)
+			if d.Status.ObservedGeneration < d.Generation {
+				return false, nil
+			}
+			for _, cond := range d.Status.Conditions {
+				if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+					return false, fmt.Errorf("deployment %s/%s exceeded its progress deadline: %s", d.Namespace, d.Name, cond.Message)
+				}
+			}
+			replicas := int32(1)
+			if d.Spec.Replicas != nil {
+				replicas = *d.Spec.Replicas
+			}
+			return d.Status.UpdatedReplicas == replicas &&
+				d.Status.Replicas == replicas &&
+				d.Status.AvailableReplicas == replicas, nil
+		},
+	}
+}
+
+func newStatefulSetTarget(dp *Deployer, kubeClient kubernetes.Interface) *workloadTarget {
+	statefulSets := kubeClient.AppsV1().StatefulSets(dp.Namespace)
+	return &workloadTarget{
+		dp:       dp,
+		client:   kubeClient.AppsV1().RESTClient(),
+		resource: "statefulsets",
+		get: func() (metav1.Object, error) {
+			return statefulSets.Get(dp.ServiceName, metav1.GetOptions{})
+		},
+		template: func(obj metav1.Object) *corev1.PodTemplateSpec {
+			return &obj.(*appsv1.StatefulSet).Spec.Template
+		},
+		patch: func(data []byte) error {
+			_, err := statefulSets.Patch(dp.ServiceName, types.MergePatchType, data)
+			return err
+		},
+		ready: func(obj metav1.Object) (bool, error) {
+			s := obj.(*appsv1.StatefulSet)
+			if s.Status.ObservedGeneration < s.Generation {
+				return false, nil
+			}
+			// OnDelete pods are only replaced when deleted by hand
+			if s.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+				return true, nil
+			}
+			replicas := int32(1)
+			if s.Spec.Replicas != nil {
+				replicas = *s.Spec.Replicas
+			}
+			partition := int32(0)
+			if ru := s.Spec.UpdateStrategy.RollingUpdate; ru != nil && ru.Partition != nil {
+				partition = *ru.Partition
+			}
+			if s.Status.ReadyReplicas != replicas || s.Status.UpdatedReplicas < replicas-partition {
+				return false, nil
+			}
+			return partition > 0 || s.Status.CurrentRevision == s.Status.UpdateRevision, nil
+		},
+	}
+}
+
+func newDaemonSetTarget(dp *Deployer, kubeClient kubernetes.Interface) *workloadTarget {
+	daemonSets := kubeClient.AppsV1().DaemonSets(dp.Namespace)
+	return &workloadTarget{
+		dp:       dp,
+		client:   kubeClient.AppsV1().RESTClient(),
+		resource: "daemonsets",
+		get: func() (metav1.Object, error) {
+			return daemonSets.Get(dp.ServiceName, metav1.GetOptions{})
+		},
+		template: func(obj metav1.Object) *corev1.PodTemplateSpec {
+			return &obj.(*appsv1.DaemonSet).Spec.Template
+		},
+		patch: func(data []byte) error {
+			_, err := daemonSets.Patch(dp.ServiceName, types.MergePatchType, data)
+			return err
+		},
+		ready: func(obj metav1.Object) (bool, error) {
+			d := obj.(*appsv1.DaemonSet)
+			if d.Status.ObservedGeneration < d.Generation {
+				return false, nil
+			}
+			if d.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+				return true, nil
+			}
+			return d.Status.UpdatedNumberScheduled == d.Status.DesiredNumberScheduled &&
+				d.Status.NumberAvailable == d.Status.DesiredNumberScheduled, nil
+		},
+	}
+}
+
+func (t *workloadTarget) Get() ([]ContainerImage, error) {
+	obj, err := t.get()
+	if err != nil {
+		return nil, err
+	}
+	return containerImages(t.template(obj).Spec.Containers), nil
+}
+
+func (t *workloadTarget) Provenance() (provenance.Provenance, error) {
+	obj, err := t.get()
+	if err != nil {
+		return provenance.Provenance{}, err
+	}
+	return provenance.FromAnnotations(t.template(obj).Annotations), nil
+}
+
+func (t *workloadTarget) UpdateImage(images []ContainerImage) error {
+	t.dp.Images = images
+	return patchObject(t.get, func(obj metav1.Object) error {
+		t.previous = t.template(obj).DeepCopy()
+		return t.mutate(obj)
+	}, t.patch)
+}
+
+// mutate sets the images on the pod template
+func (t *workloadTarget) mutate(obj metav1.Object) error {
+	tmpl := t.template(obj)
+	if err := t.dp.setImages(tmpl.Spec.Containers); err != nil {
+		return err
+	}
+	t.dp.stampProvenance(&tmpl.ObjectMeta)
+	return nil
+}
+
+// DryRun computes the workload the deploy would write
+func (t *workloadTarget) DryRun(images []ContainerImage) (*Plan, error) {
+	t.dp.Images = images
+	obj, err := t.get()
+	if err != nil {
+		return nil, err
+	}
+	current := obj.(runtime.Object)
+	desired := current.DeepCopyObject()
+	if err := t.mutate(desired.(metav1.Object)); err != nil {
+		return nil, err
+	}
+	plan, err := newPlan(t.dp.kind(), current, desired)
+	if err != nil {
+		return nil, err
+	}
+	return plan, t.dp.serverDryRun(plan, t.client, t.resource, current, desired)
+}
+
+// WaitReady waits until the rollout of the pod template is complete
+func (t *workloadTarget) WaitReady(timeout time.Duration) error {
+	dp := t.dp
+	err := wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		obj, err := t.get()
+		if err != nil {
+			return false, err
+		}
+		return t.ready(obj)
+	})
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("%s %s/%s is not rolled out after %s", dp.kind(), dp.Namespace, dp.ServiceName, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	glog.Infof("%s %s/%s is rolled out", dp.kind(), dp.Namespace, dp.ServiceName)
+	return nil
+}
+
+// Rollback restores the previous pod template, the workload then rolls back its pods
+func (t *workloadTarget) Rollback() error {
+	if t.previous == nil {
+		return ErrNoRollback
+	}
+	return patchObject(t.get, func(obj metav1.Object) error {
+		*t.template(obj) = *t.previous.DeepCopy()
+		return nil
+	}, t.patch)
+}
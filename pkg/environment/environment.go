@@ -0,0 +1,145 @@
+package environment
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultSecretKey is the key of the kubeconfig in an environment Secret
+const DefaultSecretKey = "kubeconfig"
+
+// Config is the environments config, the environments are listed in promotion order,
+// such as dev, staging, prod
+type Config struct {
+	Environments []Environment `json:"environments"`
+}
+
+// Environment is a cluster, or a namespace of a cluster, the deployer deploys to. With
+// neither Context nor Secret it is the cluster the deployer runs against.
+type Environment struct {
+	Name string `json:"name"`
+	// Kubeconfig and Context select a context of a kubeconfig file, --kubeconfig or the
+	// default kubeconfig when Kubeconfig is empty
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	// Secret is the namespace/name of a Secret of the current cluster holding a
+	// kubeconfig under SecretKey, Context selects its context
+	Secret    string `json:"secret,omitempty"`
+	SecretKey string `json:"secretKey,omitempty"`
+	// Namespace is the namespace of the services, --namespace when empty
+	Namespace string `json:"namespace,omitempty"`
+	// Gates must pass before an image is promoted into this environment
+	Gates Gates `json:"gates,omitempty"`
+	// RequireApproval gates every deploy to the environment on a manual approval
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// Gates are the conditions the revision serving in the previous environment must meet
+type Gates struct {
+	// MinSoak is the minimum age of the revision, such as 2h
+	MinSoak string `json:"minSoak,omitempty"`
+	// FullTraffic requires the revision to serve all the traffic of its Service
+	FullTraffic bool `json:"fullTraffic,omitempty"`
+	// AllowNotReady promotes the revision even when its Service is not Ready
+	AllowNotReady bool `json:"allowNotReady,omitempty"`
+}
+
+// Load reads an environments config yaml file
+func Load(path string) (*Config, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(bts, cfg); err != nil {
+		return nil, fmt.Errorf("parse environments config %s error:%s", path, err)
+	}
+	seen := map[string]bool{}
+	for _, env := range cfg.Environments {
+		if env.Name == "" {
+			return nil, fmt.Errorf("environments config %s has an environment without name", path)
+		}
+		if seen[env.Name] {
+			return nil, fmt.Errorf("environments config %s lists %s twice", path, env.Name)
+		}
+		seen[env.Name] = true
+		if env.Secret != "" && len(strings.SplitN(env.Secret, "/", 2)) != 2 {
+			return nil, fmt.Errorf("environment %s: secret %q is not namespace/name", env.Name, env.Secret)
+		}
+		if _, err := env.Gates.MinSoakDuration(); err != nil {
+			return nil, fmt.Errorf("environment %s: invalid minSoak %q: %s", env.Name, env.Gates.MinSoak, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Get returns the environment by name
+func (c *Config) Get(name string) (*Environment, error) {
+	for i := range c.Environments {
+		if c.Environments[i].Name == name {
+			return &c.Environments[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown environment %q, expected one of %s", name, strings.Join(c.names(), ", "))
+}
+
+// Next returns the environment after name in the promotion order
+func (c *Config) Next(name string) (*Environment, error) {
+	for i := range c.Environments {
+		if c.Environments[i].Name != name {
+			continue
+		}
+		if i+1 == len(c.Environments) {
+			return nil, fmt.Errorf("environment %s is the last one, there is no environment to promote to", name)
+		}
+		return &c.Environments[i+1], nil
+	}
+	return nil, fmt.Errorf("unknown environment %q, expected one of %s", name, strings.Join(c.names(), ", "))
+}
+
+func (c *Config) names() []string {
+	names := make([]string, 0, len(c.Environments))
+	for _, env := range c.Environments {
+		names = append(names, env.Name)
+	}
+	return names
+}
+
+// RestConfig creates the *rest.Config of the environment
+func (e *Environment) RestConfig() (*rest.Config, error) {
+	if e.Secret != "" {
+		home, err := kube.GetKubeconfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(home)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(e.Secret, "/", 2)
+		key := e.SecretKey
+		if key == "" {
+			key = DefaultSecretKey
+		}
+		return kube.GetSecretConfig(client, parts[0], parts[1], key, e.Context)
+	}
+	if e.Context != "" || e.Kubeconfig != "" {
+		return kube.GetContextConfig(e.Kubeconfig, e.Context)
+	}
+	return kube.GetKubeconfig()
+}
+
+// MinSoakDuration parses MinSoak, 0 when unset
+func (g *Gates) MinSoakDuration() (time.Duration, error) {
+	if g.MinSoak == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(g.MinSoak)
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"os"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/knative/eventing-sources/pkg/kncloudevents"
+)
+
+// The types of the events emitted
+const (
+	TypeDeployStarted        = "dev.tekton-serving.deploy.started"
+	TypeDeploySucceeded      = "dev.tekton-serving.deploy.succeeded"
+	TypeDeployFailed         = "dev.tekton-serving.deploy.failed"
+	TypeDeployRolledBack     = "dev.tekton-serving.deploy.rolledback"
+	TypePipelineRunCreated   = "dev.tekton-serving.pipelinerun.created"
+	TypePipelineRunCancelled = "dev.tekton-serving.pipelinerun.cancelled"
+	TypeApprovalDecided      = "dev.tekton-serving.approval.decided"
+	TypeTriggerSkipped       = "dev.tekton-serving.trigger.skipped"
+	TypeTriggerFailed        = "dev.tekton-serving.trigger.failed"
+)
+
+// sendTimeout bounds the delivery of an event, a slow sink doesn't hold the deploy
+const sendTimeout = 10 * time.Second
+
+// Emitter sends CloudEvents to a sink, a nil Emitter sends nothing
+type Emitter struct {
+	client cloudevents.Client
+	// Sink is the URL of the broker or the service the events are sent to
+	Sink string
+	// Source is the source attribute of the events
+	Source string
+}
+
+// NewEmitter returns the Emitter of the sink, the K_SINK env set by a SinkBinding or
+// a ContainerSource when sink is empty. It returns nil when there is no sink.
+func NewEmitter(sink, source string) (*Emitter, error) {
+	if sink == "" {
+		sink = os.Getenv("K_SINK")
+	}
+	if sink == "" {
+		return nil, nil
+	}
+	c, err := kncloudevents.NewDefaultClient(sink)
+	if err != nil {
+		return nil, err
+	}
+	return &Emitter{client: c, Sink: sink, Source: source}, nil
+}
+
+// NewEvent builds a v0.3 event with the JSON data and a new id, a reply isn't given
+// one by the client
+func NewEvent(eventType, source, subject string, data interface{}) (cloudevents.Event, error) {
+	event := cloudevents.NewEvent(cloudevents.VersionV03)
+	event.SetID(uuid.New().String())
+	event.SetType(eventType)
+	event.SetSource(source)
+	event.SetSubject(subject)
+	if err := event.SetData(data); err != nil {
+		return event, err
+	}
+	event.SetDataContentType(cloudevents.ApplicationJSON)
+	return event, nil
+}
+
+// Emit sends an event with the JSON data, failing to send it is only logged
+func (e *Emitter) Emit(eventType, subject string, data interface{}) {
+	if e == nil {
+		return
+	}
+	event, err := NewEvent(eventType, e.Source, subject, data)
+	if err != nil {
+		glog.Errorf("encode event %s of %s error:%s", eventType, subject, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if _, _, err := e.client.Send(ctx, event); err != nil {
+		glog.Errorf("send event %s of %s to %s error:%s", eventType, subject, e.Sink, err)
+		return
+	}
+	glog.Infof("sent event %s of %s to %s", eventType, subject, e.Sink)
+}
@@ -0,0 +1,124 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+)
+
+type payload struct {
+	Service string `json:"service"`
+	Percent int    `json:"percent"`
+}
+
+func TestNewEvent(t *testing.T) {
+	e, err := NewEvent(TypeDeploySucceeded, "tekton-serving/deployer", "default/app", &payload{Service: "app", Percent: 100})
+	if err != nil {
+		t.Fatalf("NewEvent error:%s", err)
+	}
+	if err := e.Validate(); err != nil {
+		t.Errorf("event %s is not valid:%s", e, err)
+	}
+	if e.SpecVersion() != cloudevents.VersionV03 || e.Type() != TypeDeploySucceeded || e.Source() != "tekton-serving/deployer" ||
+		e.Subject() != "default/app" || e.DataContentType() != cloudevents.ApplicationJSON {
+		t.Errorf("event = %s", e)
+	}
+	if e.ID() == "" {
+		t.Errorf("event has no id")
+	}
+	got := &payload{}
+	if err := e.DataAs(got); err != nil {
+		t.Fatalf("decode event data error:%s", err)
+	}
+	if got.Service != "app" || got.Percent != 100 {
+		t.Errorf("event data = %+v", got)
+	}
+}
+
+func TestNewEmitter(t *testing.T) {
+	defer os.Setenv("K_SINK", os.Getenv("K_SINK"))
+
+	os.Setenv("K_SINK", "")
+	if e, err := NewEmitter("", "tekton-serving/deployer"); err != nil || e != nil {
+		t.Errorf("NewEmitter without a sink = %v, %v, want none", e, err)
+	}
+
+	os.Setenv("K_SINK", "http://broker.default.svc")
+	e, err := NewEmitter("", "tekton-serving/deployer")
+	if err != nil {
+		t.Fatalf("NewEmitter error:%s", err)
+	}
+	if e.Sink != "http://broker.default.svc" {
+		t.Errorf("sink = %s, want K_SINK", e.Sink)
+	}
+	if e, err = NewEmitter("http://events.example.com", "tekton-serving/deployer"); err != nil || e.Sink != "http://events.example.com" {
+		t.Errorf("NewEmitter of a sink = %v, %v, want the sink over K_SINK", e, err)
+	}
+
+	// a nil Emitter sends nothing
+	var nilEmitter *Emitter
+	nilEmitter.Emit(TypeDeployStarted, "default/app", &payload{})
+}
+
+func TestEmit(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{header: r.Header, body: body}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	e, err := NewEmitter(sink.URL, "tekton-serving/trigger")
+	if err != nil {
+		t.Fatalf("NewEmitter error:%s", err)
+	}
+	e.Emit(TypePipelineRunCreated, "default/app-build-42", &payload{Service: "app", Percent: 10})
+
+	var r request
+	select {
+	case r = <-requests:
+	default:
+		t.Fatalf("no event sent to the sink")
+	}
+	for key, want := range map[string]string{
+		"Ce-Specversion": cloudevents.VersionV03,
+		"Ce-Type":        TypePipelineRunCreated,
+		"Ce-Source":      "tekton-serving/trigger",
+		"Ce-Subject":     "default/app-build-42",
+	} {
+		if got := r.header.Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+	got := &payload{}
+	if err := json.Unmarshal(r.body, got); err != nil {
+		t.Fatalf("decode event data %s error:%s", r.body, err)
+	}
+	if got.Service != "app" || got.Percent != 10 {
+		t.Errorf("event data = %+v", got)
+	}
+}
+
+func TestEmitFailureIsLogged(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer sink.Close()
+
+	e, err := NewEmitter(sink.URL, "tekton-serving/deployer")
+	if err != nil {
+		t.Fatalf("NewEmitter error:%s", err)
+	}
+	// the deploy goes on when the sink fails
+	e.Emit(TypeDeployFailed, "default/app", &payload{})
+}
@@ -0,0 +1,112 @@
+package freeze
+
+import (
+	"testing"
+	"time"
+)
+
+// at parses a UTC time, 2020-03-06 is a Friday
+func at(t *testing.T, value string) time.Time {
+	v, err := time.Parse("2006-01-02 15:04", value)
+	if err != nil {
+		t.Fatalf("parse time %q error:%s", value, err)
+	}
+	return v
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q) passed, want an error", expr)
+		}
+	}
+}
+
+func TestScheduleMatch(t *testing.T) {
+	cases := []struct {
+		name string
+		expr string
+		at   string
+		want bool
+	}{
+		{name: "every minute", expr: "* * * * *", at: "2020-03-06 13:37", want: true},
+		{name: "step in a range", expr: "*/15 9-17 * * 1-5", at: "2020-03-06 09:45", want: true},
+		{name: "off the step", expr: "*/15 9-17 * * 1-5", at: "2020-03-06 09:50"},
+		{name: "after the hour range", expr: "*/15 9-17 * * 1-5", at: "2020-03-06 18:00"},
+		{name: "out of the weekdays", expr: "*/15 9-17 * * 1-5", at: "2020-03-07 10:00"},
+		{name: "step from a value", expr: "5/20 * * * *", at: "2020-03-06 10:25", want: true},
+		{name: "off the step from a value", expr: "5/20 * * * *", at: "2020-03-06 10:20"},
+		{name: "step of a range", expr: "1-10/3 * * * *", at: "2020-03-06 10:07", want: true},
+		{name: "step past the range", expr: "1-10/3 * * * *", at: "2020-03-06 10:13"},
+		{name: "list", expr: "0,30 * * * *", at: "2020-03-06 10:30", want: true},
+		{name: "out of the list", expr: "0,30 * * * *", at: "2020-03-06 10:15"},
+		{name: "list of ranges", expr: "0 1-2,22-23 * * *", at: "2020-03-06 22:00", want: true},
+		{name: "month", expr: "0 0 * 3 *", at: "2020-04-06 00:00"},
+		{name: "day of month", expr: "0 0 13 * *", at: "2020-03-13 00:00", want: true},
+		{name: "other day of month", expr: "0 0 13 * *", at: "2020-03-14 00:00"},
+		{name: "7 is Sunday", expr: "0 0 * * 7", at: "2020-03-08 00:00", want: true},
+		{name: "0 is Sunday", expr: "0 0 * * 0", at: "2020-03-08 00:00", want: true},
+		{name: "both days restricted, day of week matches", expr: "0 0 1 * 0", at: "2020-03-08 00:00", want: true},
+		{name: "both days restricted, day of month matches", expr: "0 0 1 * 0", at: "2020-04-01 00:00", want: true},
+		{name: "both days restricted, none matches", expr: "0 0 1 * 0", at: "2020-03-02 00:00"},
+		{name: "day of month step is a star, both must match", expr: "0 0 */2 * 1", at: "2020-03-09 00:00", want: true},
+		{name: "day of month step is a star, day of month fails", expr: "0 0 */2 * 1", at: "2020-03-16 00:00"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := ParseSchedule(c.expr)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error:%s", c.expr, err)
+			}
+			if got := s.Match(at(t, c.at)); got != c.want {
+				t.Errorf("%q matches %s: %v, want %v", c.expr, c.at, got, c.want)
+			}
+		})
+	}
+}
+
+func TestScheduleLast(t *testing.T) {
+	s, err := ParseSchedule("0 18 * * 5")
+	if err != nil {
+		t.Fatalf("ParseSchedule error:%s", err)
+	}
+	cases := []struct {
+		name     string
+		at       string
+		lookback time.Duration
+		want     string
+	}{
+		{name: "fired the day before", at: "2020-03-07 01:00", lookback: 8 * time.Hour, want: "2020-03-06 18:00"},
+		{name: "fired too long ago", at: "2020-03-07 01:00", lookback: 6 * time.Hour},
+		{name: "fires at the minute", at: "2020-03-06 18:00", lookback: time.Minute, want: "2020-03-06 18:00"},
+		{name: "not fired yet", at: "2020-03-06 17:59", lookback: 24 * time.Hour},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := s.Last(at(t, c.at).Add(30*time.Second), c.lookback)
+			if c.want == "" {
+				if ok {
+					t.Errorf("Last = %s, want none", got)
+				}
+				return
+			}
+			if !ok || !got.Equal(at(t, c.want)) {
+				t.Errorf("Last = %s, %v, want %s", got, ok, c.want)
+			}
+		})
+	}
+}
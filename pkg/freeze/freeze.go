@@ -0,0 +1,194 @@
+package freeze
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PolicyKey is the key of the policy in the freeze ConfigMap
+const PolicyKey = "policy.yaml"
+
+// maxWindow bounds the duration of a recurring window
+const maxWindow = 31 * 24 * time.Hour
+
+// Policy is the deploy freeze policy
+type Policy struct {
+	// Emergency freezes every deploy in its scope until it is disabled
+	Emergency Emergency `json:"emergency,omitempty"`
+	Windows   []Window  `json:"windows,omitempty"`
+}
+
+// Emergency is the freeze declared during an incident
+type Emergency struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Scope   `json:",inline"`
+}
+
+// Window is a recurring window, Cron and Duration, or an absolute range, Start and End
+type Window struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+	// Cron is when the window starts, such as "0 18 * * 5" on Friday 18:00, it lasts Duration
+	Cron     string `json:"cron,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	// Timezone of Cron, such as Asia/Shanghai, UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+	// Start and End are RFC3339 times
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	Scope `json:",inline"`
+
+	schedule *Schedule
+	duration time.Duration
+	location *time.Location
+	start    time.Time
+	end      time.Time
+}
+
+// Scope restricts a freeze to namespaces and Services, a Service is name or
+// namespace/name. An empty scope is every deploy.
+type Scope struct {
+	Namespaces []string `json:"namespaces,omitempty"`
+	Services   []string `json:"services,omitempty"`
+}
+
+// Freeze is a freeze in force
+type Freeze struct {
+	Name   string
+	Reason string
+	// Until is the end of the freeze, zero for an emergency freeze
+	Until time.Time
+}
+
+func (f *Freeze) String() string {
+	s := fmt.Sprintf("deploy freeze %s", f.Name)
+	if f.Reason != "" {
+		s += ": " + f.Reason
+	}
+	if !f.Until.IsZero() {
+		s += fmt.Sprintf(", until %s", f.Until.Format(time.RFC3339))
+	}
+	return s
+}
+
+// Parse parses and validates a freeze policy yaml
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	for i := range p.Windows {
+		if err := p.Windows[i].init(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Load reads the policy of the ConfigMap namespace/name, a missing ConfigMap is no freeze
+func Load(client kubernetes.Interface, ref string) (*Policy, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("freeze policy %q is not namespace/name", ref)
+	}
+	cm, err := client.CoreV1().ConfigMaps(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return &Policy{}, nil
+		}
+		return nil, err
+	}
+	p, err := Parse([]byte(cm.Data[PolicyKey]))
+	if err != nil {
+		return nil, fmt.Errorf("parse freeze policy %s error:%s", ref, err)
+	}
+	return p, nil
+}
+
+func (w *Window) init() error {
+	if w.Name == "" {
+		return fmt.Errorf("freeze window without name")
+	}
+	switch {
+	case w.Cron != "":
+		s, err := ParseSchedule(w.Cron)
+		if err != nil {
+			return fmt.Errorf("freeze window %s: %s", w.Name, err)
+		}
+		d, err := time.ParseDuration(w.Duration)
+		if err != nil || d <= 0 || d > maxWindow {
+			return fmt.Errorf("freeze window %s: duration %q must be a duration up to %s", w.Name, w.Duration, maxWindow)
+		}
+		loc := time.UTC
+		if w.Timezone != "" {
+			if loc, err = time.LoadLocation(w.Timezone); err != nil {
+				return fmt.Errorf("freeze window %s: %s", w.Name, err)
+			}
+		}
+		w.schedule, w.duration, w.location = s, d, loc
+	case w.Start != "" && w.End != "":
+		start, err := time.Parse(time.RFC3339, w.Start)
+		if err != nil {
+			return fmt.Errorf("freeze window %s: start: %s", w.Name, err)
+		}
+		end, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return fmt.Errorf("freeze window %s: end: %s", w.Name, err)
+		}
+		if !end.After(start) {
+			return fmt.Errorf("freeze window %s ends before it starts", w.Name)
+		}
+		w.start, w.end = start, end
+	default:
+		return fmt.Errorf("freeze window %s needs either cron and duration or start and end", w.Name)
+	}
+	return nil
+}
+
+// Check returns the freeze in force at now for the Service, nil when it may be deployed
+func (p *Policy) Check(now time.Time, namespace, service string) *Freeze {
+	if p.Emergency.Enabled && p.Emergency.Scope.matches(namespace, service) {
+		return &Freeze{Name: "emergency", Reason: p.Emergency.Reason}
+	}
+	for _, w := range p.Windows {
+		if !w.Scope.matches(namespace, service) {
+			continue
+		}
+		if w.schedule != nil {
+			if start, ok := w.schedule.Last(now.In(w.location), w.duration); ok {
+				return &Freeze{Name: w.Name, Reason: w.Reason, Until: start.Add(w.duration)}
+			}
+			continue
+		}
+		if !now.Before(w.start) && now.Before(w.end) {
+			return &Freeze{Name: w.Name, Reason: w.Reason, Until: w.end}
+		}
+	}
+	return nil
+}
+
+func (s *Scope) matches(namespace, service string) bool {
+	if len(s.Namespaces) > 0 && !contains(s.Namespaces, namespace) {
+		return false
+	}
+	if len(s.Services) > 0 && !contains(s.Services, service) && !contains(s.Services, namespace+"/"+service) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package freeze
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{{
+		name:    "no name",
+		policy:  "windows:\n- cron: 0 18 * * 5\n  duration: 60h\n",
+		wantErr: "freeze window without name",
+	}, {
+		name:    "neither cron nor range",
+		policy:  "windows:\n- name: weekend\n",
+		wantErr: "needs either cron and duration or start and end",
+	}, {
+		name:    "invalid cron",
+		policy:  "windows:\n- name: weekend\n  cron: 0 18 * *\n  duration: 60h\n",
+		wantErr: "has 4 fields",
+	}, {
+		name:    "no duration",
+		policy:  "windows:\n- name: weekend\n  cron: 0 18 * * 5\n",
+		wantErr: "must be a duration",
+	}, {
+		name:    "duration too long",
+		policy:  "windows:\n- name: weekend\n  cron: 0 18 * * 5\n  duration: 745h\n",
+		wantErr: "must be a duration",
+	}, {
+		name:    "unknown time zone",
+		policy:  "windows:\n- name: weekend\n  cron: 0 18 * * 5\n  duration: 60h\n  timezone: Mars/Olympus\n",
+		wantErr: "Mars/Olympus",
+	}, {
+		name:    "ends before it starts",
+		policy:  "windows:\n- name: holidays\n  start: 2020-12-27T00:00:00Z\n  end: 2020-12-24T00:00:00Z\n",
+		wantErr: "ends before it starts",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Parse([]byte(c.policy))
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("Parse error:%v, want %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+const policy = `
+emergency:
+  enabled: true
+  reason: incident 42
+  namespaces: [payments]
+windows:
+- name: friday-night
+  reason: nobody on call
+  cron: 0 22 * * 5
+  duration: 4h
+- name: shanghai-evening
+  cron: 0 18 * * 5
+  duration: 2h
+  timezone: Asia/Shanghai
+  services: [prod/web, api]
+- name: holidays
+  start: 2020-12-24T00:00:00Z
+  end: 2020-12-27T00:00:00Z
+  namespaces: [prod]
+`
+
+func TestPolicyCheck(t *testing.T) {
+	p, err := Parse([]byte(policy))
+	if err != nil {
+		t.Fatalf("Parse error:%s", err)
+	}
+	cases := []struct {
+		name      string
+		now       string
+		namespace string
+		service   string
+		want      string
+		wantUntil string
+	}{{
+		name:      "emergency in its namespace",
+		now:       "2020-03-04T10:00:00Z",
+		namespace: "payments",
+		service:   "api",
+		want:      "emergency",
+	}, {
+		name:      "no freeze out of the windows",
+		now:       "2020-03-04T10:00:00Z",
+		namespace: "dev",
+		service:   "api",
+	}, {
+		name:      "window starting",
+		now:       "2020-03-06T22:00:00Z",
+		namespace: "dev",
+		service:   "app",
+		want:      "friday-night",
+		wantUntil: "2020-03-07T02:00:00Z",
+	}, {
+		name:      "window across midnight",
+		now:       "2020-03-07T01:59:00Z",
+		namespace: "dev",
+		service:   "app",
+		want:      "friday-night",
+		wantUntil: "2020-03-07T02:00:00Z",
+	}, {
+		name:      "window over",
+		now:       "2020-03-07T02:00:00Z",
+		namespace: "dev",
+		service:   "app",
+	}, {
+		name:      "before the window",
+		now:       "2020-03-06T21:59:59Z",
+		namespace: "dev",
+		service:   "app",
+	}, {
+		name:      "cron in the time zone of the window",
+		now:       "2020-03-06T10:30:00Z",
+		namespace: "prod",
+		service:   "web",
+		want:      "shanghai-evening",
+		wantUntil: "2020-03-06T12:00:00Z",
+	}, {
+		name:      "cron is not UTC",
+		now:       "2020-03-06T18:30:00Z",
+		namespace: "prod",
+		service:   "web",
+	}, {
+		name:      "service of another namespace",
+		now:       "2020-03-06T10:30:00Z",
+		namespace: "dev",
+		service:   "web",
+	}, {
+		name:      "service of every namespace",
+		now:       "2020-03-06T10:30:00Z",
+		namespace: "dev",
+		service:   "api",
+		want:      "shanghai-evening",
+		wantUntil: "2020-03-06T12:00:00Z",
+	}, {
+		name:      "absolute range",
+		now:       "2020-12-25T10:00:00Z",
+		namespace: "prod",
+		service:   "app",
+		want:      "holidays",
+		wantUntil: "2020-12-27T00:00:00Z",
+	}, {
+		name:      "absolute range is over at its end",
+		now:       "2020-12-27T00:00:00Z",
+		namespace: "prod",
+		service:   "app",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, c.now)
+			if err != nil {
+				t.Fatalf("parse %s error:%s", c.now, err)
+			}
+			f := p.Check(now, c.namespace, c.service)
+			if c.want == "" {
+				if f != nil {
+					t.Errorf("Check = %s, want no freeze", f)
+				}
+				return
+			}
+			if f == nil || f.Name != c.want {
+				t.Fatalf("Check = %v, want %s", f, c.want)
+			}
+			if c.wantUntil == "" {
+				if !f.Until.IsZero() {
+					t.Errorf("until %s, want none", f.Until)
+				}
+				return
+			}
+			until, err := time.Parse(time.RFC3339, c.wantUntil)
+			if err != nil {
+				t.Fatalf("parse %s error:%s", c.wantUntil, err)
+			}
+			if !f.Until.Equal(until) {
+				t.Errorf("until %s, want %s", f.Until, until)
+			}
+		})
+	}
+}
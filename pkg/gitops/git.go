@@ -0,0 +1,165 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// DefaultAuthor is the author of the commits when none is configured
+const DefaultAuthor = "deployer <deployer@tekton-serving.dev>"
+
+// Repo is a clone of a git repository in a temporary directory
+type Repo struct {
+	// URL is the remote, credentials may be given in it
+	URL string
+	// Branch is the branch cloned
+	Branch string
+	Dir    string
+	// Author is the "Name <email>" the commits are made by
+	Author string
+}
+
+// Clone clones the branch of the repository in a temporary directory, Close removes it
+func Clone(repoURL, branch, author string) (*Repo, error) {
+	dir, err := ioutil.TempDir("", "gitops-")
+	if err != nil {
+		return nil, err
+	}
+	if author == "" {
+		author = DefaultAuthor
+	}
+	r := &Repo{URL: repoURL, Branch: branch, Dir: dir, Author: author}
+	args := []string{"clone", "--quiet", "--depth", "50"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	if _, err := r.git(append(args, repoURL, dir)...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if r.Branch == "" {
+		if r.Branch, err = r.git("rev-parse", "--abbrev-ref", "HEAD"); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	glog.Infof("cloned %s branch %s", Redact(repoURL), r.Branch)
+	return r, nil
+}
+
+// Close removes the clone
+func (r *Repo) Close() error {
+	return os.RemoveAll(r.Dir)
+}
+
+// git runs a git command in the clone and returns its trimmed output
+func (r *Repo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	if r.Dir != "" && args[0] != "clone" {
+		cmd.Dir = r.Dir
+	}
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", args[0], err, Redact(strings.TrimSpace(stderr.String())))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ReadFile reads a file of the clone, path is relative to the root of the repository
+func (r *Repo) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(r.Dir, path))
+}
+
+// WriteFile writes a file of the clone
+func (r *Repo) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(filepath.Join(r.Dir, path), data, 0644)
+}
+
+// Commit commits the changes of the path and returns the commit sha. It returns an
+// empty sha when there is nothing to commit.
+func (r *Repo) Commit(path, message string) (string, error) {
+	if _, err := r.git("add", "--", path); err != nil {
+		return "", err
+	}
+	if _, err := r.git("diff", "--cached", "--quiet"); err == nil {
+		return "", nil
+	}
+	args := []string{}
+	if name, email := splitAuthor(r.Author); name != "" {
+		args = append(args, "-c", "user.name="+name, "-c", "user.email="+email)
+	}
+	args = append(args, "commit", "--quiet", "-m", message)
+	if _, err := r.git(args...); err != nil {
+		return "", err
+	}
+	return r.Head()
+}
+
+// Head returns the sha of the commit checked out
+func (r *Repo) Head() (string, error) {
+	return r.git("rev-parse", "HEAD")
+}
+
+// Push pushes HEAD to the branch of the remote, force overwrites a branch of a
+// previous push
+func (r *Repo) Push(branch string, force bool) error {
+	args := []string{"push", "--quiet"}
+	if force {
+		args = append(args, "--force")
+	}
+	_, err := r.git(append(args, "origin", "HEAD:refs/heads/"+branch)...)
+	return err
+}
+
+// Reset drops the local commits and checks out the branch as it is on the remote
+func (r *Repo) Reset() error {
+	if _, err := r.git("fetch", "--quiet", "--depth", "50", "origin", r.Branch); err != nil {
+		return err
+	}
+	_, err := r.git("reset", "--quiet", "--hard", "FETCH_HEAD")
+	return err
+}
+
+// LastMessage returns the message of the last commit changing the path
+func (r *Repo) LastMessage(path string) (string, error) {
+	return r.git("log", "-1", "--format=%B", "--", path)
+}
+
+// Revert commits the revert of a commit and returns the sha of the revert
+func (r *Repo) Revert(sha string) (string, error) {
+	args := []string{}
+	if name, email := splitAuthor(r.Author); name != "" {
+		args = append(args, "-c", "user.name="+name, "-c", "user.email="+email)
+	}
+	if _, err := r.git(append(args, "revert", "--no-edit", sha)...); err != nil {
+		return "", err
+	}
+	return r.Head()
+}
+
+// splitAuthor splits "Name <email>"
+func splitAuthor(author string) (string, string) {
+	i := strings.Index(author, "<")
+	if i < 0 {
+		return strings.TrimSpace(author), ""
+	}
+	return strings.TrimSpace(author[:i]), strings.TrimSuffix(strings.TrimSpace(author[i+1:]), ">")
+}
+
+// credentials matches the user info of the URLs
+var credentials = regexp.MustCompile(`://[^/@\s'"]+@`)
+
+// Redact hides the password or token of the URLs in s
+func Redact(s string) string {
+	return credentials.ReplaceAllString(s, "://xxxxx@")
+}
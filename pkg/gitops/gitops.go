@@ -0,0 +1,129 @@
+package gitops
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+)
+
+// pushAttempts is the number of times an update is rebased on the remote and pushed
+// again when another commit was pushed in the meantime
+const pushAttempts = 3
+
+// Config is the repository and the manifest the images are written to
+type Config struct {
+	// Repo is the URL of the repository, Branch the branch the manifests are on, the
+	// default branch when empty
+	Repo   string `json:"repo"`
+	Branch string `json:"branch,omitempty"`
+	// Path is the manifest in the repository, a kustomization when Kustomize is set
+	Path      string `json:"path"`
+	Kustomize bool   `json:"kustomize,omitempty"`
+	// ImagePath is the YAML path of the image in the manifest, {container} is replaced
+	// by the name of the container
+	ImagePath string `json:"imagePath,omitempty"`
+	// PushBranch is the branch pushed to instead of Branch, to open a pull request
+	PushBranch string `json:"pushBranch,omitempty"`
+	// Author is the "Name <email>" of the commits
+	Author string `json:"author,omitempty"`
+}
+
+// Validate checks the config
+func (c *Config) Validate() error {
+	if c.Repo == "" {
+		return fmt.Errorf("gitops repo is required")
+	}
+	if c.Path == "" {
+		return fmt.Errorf("gitops path is required")
+	}
+	if c.Kustomize && c.ImagePath != "" {
+		return fmt.Errorf("gitops image path can't be used with a kustomization")
+	}
+	return nil
+}
+
+// MarshalJSON hides the credentials of the repository URL, the config is logged
+func (c Config) MarshalJSON() ([]byte, error) {
+	type config Config
+	c.Repo = Redact(c.Repo)
+	return json.Marshal(config(c))
+}
+
+// Result is the commit an update was pushed as
+type Result struct {
+	Repo   string `json:"repo"`
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+}
+
+// Update edits the file, commits it and pushes it to branch. When the push is rejected
+// because the branch moved, the clone is reset to the remote and the edit made again.
+// It returns an empty sha when the edit changes nothing.
+func (r *Repo) Update(path string, edit func([]byte) ([]byte, error), message, branch string) (string, error) {
+	force := branch != r.Branch
+	for attempt := 1; ; attempt++ {
+		data, err := r.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if data, err = edit(data); err != nil {
+			return "", err
+		}
+		if err := r.WriteFile(path, data); err != nil {
+			return "", err
+		}
+		sha, err := r.Commit(path, message)
+		if err != nil || sha == "" {
+			return "", err
+		}
+		err = r.Push(branch, force)
+		if err == nil {
+			glog.Infof("pushed %s to %s branch %s", sha, Redact(r.URL), branch)
+			return sha, nil
+		}
+		if force || attempt == pushAttempts {
+			return "", err
+		}
+		glog.Warningf("push to %s branch %s rejected, retrying on top of it: %s", Redact(r.URL), branch, err)
+		if err := r.Reset(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// trailer is a provenance line of a commit message
+var trailer = regexp.MustCompile(`^([A-Z][A-Z_]*): (.+)$`)
+
+// Message is the commit message of a deploy, the provenance is added as trailers
+func Message(title string, p provenance.Provenance) string {
+	env := p.Env()
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := []string{title}
+	if len(keys) > 0 {
+		lines = append(lines, "")
+	}
+	for _, k := range keys {
+		lines = append(lines, k+": "+env[k])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ProvenanceOf reads the provenance of the trailers of a commit message
+func ProvenanceOf(message string) provenance.Provenance {
+	env := map[string]string{}
+	for _, line := range strings.Split(message, "\n") {
+		if m := trailer.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			env[m[1]] = m[2]
+		}
+	}
+	return provenance.FromEnvMap(env)
+}
@@ -0,0 +1,368 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// step is one element of a YAML path: a key, an index [n] or a selector [key=value]
+type step struct {
+	key    string
+	index  int
+	selKey string
+	selVal string
+}
+
+// parsePath parses a YAML path such as spec.template.spec.containers[name=app].image
+func parsePath(path string) ([]step, error) {
+	steps := []step{}
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		brackets := ""
+		if i := strings.Index(part, "["); i >= 0 {
+			key, brackets = part[:i], part[i:]
+		}
+		if key != "" {
+			steps = append(steps, step{key: key, index: -1})
+		}
+		for brackets != "" {
+			end := strings.Index(brackets, "]")
+			if !strings.HasPrefix(brackets, "[") || end < 0 {
+				return nil, fmt.Errorf("invalid yaml path %q", path)
+			}
+			sel := brackets[1:end]
+			brackets = brackets[end+1:]
+			if kv := strings.SplitN(sel, "=", 2); len(kv) == 2 {
+				steps = append(steps, step{index: -1, selKey: kv[0], selVal: kv[1]})
+				continue
+			}
+			n, err := strconv.Atoi(sel)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid index %q in yaml path %q", sel, path)
+			}
+			steps = append(steps, step{index: n})
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty yaml path")
+	}
+	return steps, nil
+}
+
+// walk follows the steps from node and sets the value at the end when set is not nil,
+// it returns the value found
+func walk(node interface{}, steps []step, set *string) (string, error) {
+	s := steps[0]
+	last := len(steps) == 1
+	switch n := node.(type) {
+	case yaml.MapSlice:
+		if s.key == "" {
+			return "", fmt.Errorf("expected a key, found a mapping")
+		}
+		for i := range n {
+			if fmt.Sprint(n[i].Key) != s.key {
+				continue
+			}
+			if !last {
+				return walk(n[i].Value, steps[1:], set)
+			}
+			old, ok := n[i].Value.(string)
+			if !ok {
+				return "", fmt.Errorf("%s is not a string", s.key)
+			}
+			if set != nil {
+				n[i].Value = *set
+			}
+			return old, nil
+		}
+		return "", fmt.Errorf("key %s not found", s.key)
+	case []interface{}:
+		if s.key != "" {
+			return "", fmt.Errorf("expected an index or a selector before %s, found a list", s.key)
+		}
+		i := s.index
+		if s.selKey != "" {
+			i = -1
+			for j, item := range n {
+				if m, ok := item.(yaml.MapSlice); ok && mapValue(m, s.selKey) == s.selVal {
+					i = j
+					break
+				}
+			}
+			if i < 0 {
+				return "", fmt.Errorf("no item with %s=%s", s.selKey, s.selVal)
+			}
+		}
+		if i >= len(n) {
+			return "", fmt.Errorf("index %d out of %d items", i, len(n))
+		}
+		if !last {
+			return walk(n[i], steps[1:], set)
+		}
+		old, ok := n[i].(string)
+		if !ok {
+			return "", fmt.Errorf("item %d is not a string", i)
+		}
+		if set != nil {
+			n[i] = *set
+		}
+		return old, nil
+	}
+	return "", fmt.Errorf("cannot follow %v into a scalar", s)
+}
+
+func mapValue(m yaml.MapSlice, key string) string {
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return fmt.Sprint(item.Value)
+		}
+	}
+	return ""
+}
+
+// documents decodes every document of a multi documents yaml file
+func documents(data []byte) ([]yaml.MapSlice, error) {
+	docs := []yaml.MapSlice{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := yaml.MapSlice{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func encode(docs []yaml.MapSlice) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for i, doc := range docs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		bts, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(bts)
+	}
+	return buf.Bytes(), nil
+}
+
+// GetImage returns the value at the YAML path in the first document it resolves in
+func GetImage(data []byte, path string) (string, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+	v, err := resolve(data, steps)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", path, err)
+	}
+	return v, nil
+}
+
+// resolve returns the value at the steps in the first document they resolve in
+func resolve(data []byte, steps []step) (string, error) {
+	docs, err := documents(data)
+	if err != nil {
+		return "", err
+	}
+	var first error
+	for _, doc := range docs {
+		v, err := walk(doc, steps, nil)
+		if err == nil {
+			return v, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	if first == nil {
+		first = fmt.Errorf("no yaml document")
+	}
+	return "", first
+}
+
+// SetImage sets the value at the YAML path, in the first document it resolves in, and
+// returns the file with the previous value
+func SetImage(data []byte, path, image string) ([]byte, string, error) {
+	steps, err := parsePath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	old, err := GetImage(data, path)
+	if err != nil {
+		return nil, "", err
+	}
+	if old == image {
+		return data, old, nil
+	}
+	if out, ok := replaceAt(data, steps, old, image); ok {
+		return out, old, nil
+	}
+
+	docs, err := documents(data)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, doc := range docs {
+		if _, err := walk(doc, steps, &image); err == nil {
+			break
+		}
+	}
+	out, err := encode(docs)
+	return out, old, err
+}
+
+// marker stands for the value while its occurrence in the text is looked for
+const marker = "tekton-serving-gitops-marker"
+
+// replaceAt replaces the value in the text at the occurrence the steps resolve to, so
+// the comments and the layout of the file are kept. An occurrence is the one of the
+// steps when the steps resolve to the marker written in its place. It fails when the
+// value isn't written as is, such as an escaped string.
+func replaceAt(data []byte, steps []step, old, value string) ([]byte, bool) {
+	if old == "" {
+		return nil, false
+	}
+	for offset := 0; ; {
+		i := bytes.Index(data[offset:], []byte(old))
+		if i < 0 {
+			return nil, false
+		}
+		at := offset + i
+		offset = at + len(old)
+		if v, err := resolve(splice(data, at, len(old), marker), steps); err != nil || v != marker {
+			continue
+		}
+		out := splice(data, at, len(old), value)
+		if v, err := resolve(out, steps); err != nil || v != value {
+			return nil, false
+		}
+		return out, true
+	}
+}
+
+// splice returns a copy of data with the n bytes at i replaced by value
+func splice(data []byte, i, n int, value string) []byte {
+	out := make([]byte, 0, len(data)-n+len(value))
+	out = append(out, data[:i]...)
+	out = append(out, value...)
+	return append(out, data[i+n:]...)
+}
+
+// splitImage splits an image reference into its repository, tag and digest
+func splitImage(image string) (repo, tag, digest string) {
+	repo = image
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo, digest = repo[:i], repo[i+1:]
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	return repo, tag, digest
+}
+
+// KustomizeImages returns the images entries of a kustomization as references
+func KustomizeImages(data []byte) (map[string]string, error) {
+	k := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &k); err != nil {
+		return nil, err
+	}
+	images := map[string]string{}
+	list, _ := value(k, "images").([]interface{})
+	for _, item := range list {
+		m, ok := item.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		name := mapValue(m, "name")
+		ref := name
+		if n := mapValue(m, "newName"); n != "" {
+			ref = n
+		}
+		if d := mapValue(m, "digest"); d != "" {
+			ref += "@" + d
+		} else if t := mapValue(m, "newTag"); t != "" {
+			ref += ":" + t
+		}
+		images[name] = ref
+	}
+	return images, nil
+}
+
+// SetKustomizeImage sets the images entry of the repository of the image, the entry
+// named after it or the one whose newName it is, it is added when there is none
+func SetKustomizeImage(data []byte, image string) ([]byte, string, error) {
+	k := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &k); err != nil {
+		return nil, "", err
+	}
+	repo, tag, digest := splitImage(image)
+
+	list, _ := value(k, "images").([]interface{})
+	index := -1
+	for i, item := range list {
+		m, ok := item.(yaml.MapSlice)
+		if ok && (mapValue(m, "name") == repo || mapValue(m, "newName") == repo) {
+			index = i
+			break
+		}
+	}
+
+	old := ""
+	entry := yaml.MapSlice{{Key: "name", Value: repo}}
+	if index >= 0 {
+		current, _ := KustomizeImages(data)
+		m := list[index].(yaml.MapSlice)
+		old = current[mapValue(m, "name")]
+		entry = yaml.MapSlice{}
+		for _, item := range m {
+			if key := fmt.Sprint(item.Key); key != "newTag" && key != "digest" {
+				entry = append(entry, item)
+			}
+		}
+	}
+	if digest != "" {
+		entry = append(entry, yaml.MapItem{Key: "digest", Value: digest})
+	} else if tag != "" {
+		entry = append(entry, yaml.MapItem{Key: "newTag", Value: tag})
+	}
+	if index >= 0 {
+		list[index] = entry
+	} else {
+		list = append(list, entry)
+	}
+	k = setValue(k, "images", list)
+
+	out, err := yaml.Marshal(k)
+	return out, old, err
+}
+
+func value(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func setValue(m yaml.MapSlice, key string, v interface{}) yaml.MapSlice {
+	for i := range m {
+		if fmt.Sprint(m[i].Key) == key {
+			m[i].Value = v
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: v})
+}
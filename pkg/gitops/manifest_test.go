@@ -0,0 +1,352 @@
+package gitops
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		path    string
+		want    []step
+		wantErr bool
+	}{{
+		path: "spec.template.spec.containers[name=app].image",
+		want: []step{
+			{key: "spec", index: -1}, {key: "template", index: -1}, {key: "spec", index: -1},
+			{key: "containers", index: -1}, {index: -1, selKey: "name", selVal: "app"}, {key: "image", index: -1},
+		},
+	}, {
+		path: "spec.containers[0].image",
+		want: []step{{key: "spec", index: -1}, {key: "containers", index: -1}, {index: 0}, {key: "image", index: -1}},
+	}, {
+		path: "images[1][0]",
+		want: []step{{key: "images", index: -1}, {index: 1}, {index: 0}},
+	}, {
+		path: "[name=app=v2]",
+		want: []step{{index: -1, selKey: "name", selVal: "app=v2"}},
+	}, {
+		path:    "",
+		wantErr: true,
+	}, {
+		path:    "containers[0",
+		wantErr: true,
+	}, {
+		path:    "containers[-1]",
+		wantErr: true,
+	}, {
+		path:    "containers[first]",
+		wantErr: true,
+	}, {
+		path:    "containers[0]x",
+		wantErr: true,
+	}}
+	for _, c := range cases {
+		got, err := parsePath(c.path)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parsePath(%q) = %v, want an error", c.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePath(%q) error:%s", c.path, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("parsePath(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+const deployment = `spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: registry.example.com/app:v1
+      - name: proxy
+        image: envoy:1.12
+      args: [a, b]
+      replicas: 2
+`
+
+func TestWalk(t *testing.T) {
+	cases := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: "spec.template.spec.containers[name=proxy].image", want: "envoy:1.12"},
+		{path: "spec.template.spec.containers[0].image", want: "registry.example.com/app:v1"},
+		{path: "spec.template.spec.args[1]", want: "b"},
+		{path: "spec.template.spec.containers[name=db].image", wantErr: "no item with name=db"},
+		{path: "spec.template.spec.containers[2].image", wantErr: "index 2 out of 2 items"},
+		{path: "spec.template.spec.containers.image", wantErr: "expected an index or a selector before image"},
+		{path: "spec.template[0]", wantErr: "expected a key, found a mapping"},
+		{path: "spec.template.spec.replicas", wantErr: "replicas is not a string"},
+		{path: "spec.template.spec.containers[0].image.name", wantErr: "into a scalar"},
+		{path: "spec.selector", wantErr: "key selector not found"},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			doc := yaml.MapSlice{}
+			if err := yaml.Unmarshal([]byte(deployment), &doc); err != nil {
+				t.Fatalf("parse manifest error:%s", err)
+			}
+			steps, err := parsePath(c.path)
+			if err != nil {
+				t.Fatalf("parsePath error:%s", err)
+			}
+			value := "app:v2"
+			got, err := walk(doc, steps, &value)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("walk error:%v, want %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("walk error:%s", err)
+			}
+			if got != c.want {
+				t.Errorf("walk = %s, want %s", got, c.want)
+			}
+			if set, err := walk(doc, steps, nil); err != nil || set != value {
+				t.Errorf("value after walk = %s, %v, want %s", set, err, value)
+			}
+		})
+	}
+}
+
+func TestSetImage(t *testing.T) {
+	cases := []struct {
+		name     string
+		manifest string
+		path     string
+		image    string
+		want     string
+		wantOld  string
+	}{{
+		name: "keeps the comments and the layout",
+		manifest: `# the web frontend
+spec:
+  template:
+    spec:
+      containers:
+      - name: app   # the app
+        image: "app:v1"
+`,
+		path:  "spec.template.spec.containers[name=app].image",
+		image: "app@sha256:aaaa",
+		want: `# the web frontend
+spec:
+  template:
+    spec:
+      containers:
+      - name: app   # the app
+        image: "app@sha256:aaaa"
+`,
+		wantOld: "app:v1",
+	}, {
+		name: "the same image elsewhere is left",
+		manifest: `spec:
+  template:
+    spec:
+      # rolled back from app:v1 once
+      initContainers:
+      - name: migrate
+        image: app:v1
+      containers:
+      - name: app
+        image: app:v1
+        env:
+        - name: PREVIOUS
+          value: myapp:v1
+`,
+		path:  "spec.template.spec.containers[name=app].image",
+		image: "app:v2",
+		want: `spec:
+  template:
+    spec:
+      # rolled back from app:v1 once
+      initContainers:
+      - name: migrate
+        image: app:v1
+      containers:
+      - name: app
+        image: app:v2
+        env:
+        - name: PREVIOUS
+          value: myapp:v1
+`,
+		wantOld: "app:v1",
+	}, {
+		name: "first document the path resolves in",
+		manifest: `kind: Service
+spec:
+  ports:
+  - port: 80
+---
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+---
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+`,
+		path:  "spec.template.spec.containers[0].image",
+		image: "app:v2",
+		want: `kind: Service
+spec:
+  ports:
+  - port: 80
+---
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v2
+---
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+`,
+		wantOld: "app:v1",
+	}, {
+		name: "an escaped value is written by the encoder",
+		manifest: `containers:
+- name: app
+  image: "app:\x76\x31"
+`,
+		path:  "containers[0].image",
+		image: "app:v2",
+		want: `containers:
+- name: app
+  image: app:v2
+`,
+		wantOld: "app:v1",
+	}, {
+		name:     "unchanged image",
+		manifest: deployment,
+		path:     "spec.template.spec.containers[name=proxy].image",
+		image:    "envoy:1.12",
+		want:     deployment,
+		wantOld:  "envoy:1.12",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, old, err := SetImage([]byte(c.manifest), c.path, c.image)
+			if err != nil {
+				t.Fatalf("SetImage error:%s", err)
+			}
+			if old != c.wantOld {
+				t.Errorf("old image = %s, want %s", old, c.wantOld)
+			}
+			if string(got) != c.want {
+				t.Errorf("SetImage =\n%s\nwant\n%s", got, c.want)
+			}
+		})
+	}
+
+	if _, _, err := SetImage([]byte(deployment), "spec.template.spec.containers[name=db].image", "db:v2"); err == nil {
+		t.Errorf("SetImage of an unknown container passed, want an error")
+	}
+}
+
+func TestSetKustomizeImage(t *testing.T) {
+	const kustomization = `resources:
+- deployment.yaml
+images:
+- name: app
+  newName: registry.example.com/app
+  newTag: v1
+- name: envoy
+  digest: sha256:aaaa
+`
+	cases := []struct {
+		name       string
+		image      string
+		wantOld    string
+		wantImages map[string]string
+	}{{
+		name:    "entry of the newName",
+		image:   "registry.example.com/app:v2",
+		wantOld: "registry.example.com/app:v1",
+		wantImages: map[string]string{
+			"app":   "registry.example.com/app:v2",
+			"envoy": "envoy@sha256:aaaa",
+		},
+	}, {
+		name:    "digest replaces the tag",
+		image:   "registry.example.com/app@sha256:bbbb",
+		wantOld: "registry.example.com/app:v1",
+		wantImages: map[string]string{
+			"app":   "registry.example.com/app@sha256:bbbb",
+			"envoy": "envoy@sha256:aaaa",
+		},
+	}, {
+		name:    "entry of the name",
+		image:   "envoy:1.13",
+		wantOld: "envoy@sha256:aaaa",
+		wantImages: map[string]string{
+			"app":   "registry.example.com/app:v1",
+			"envoy": "envoy:1.13",
+		},
+	}, {
+		name:  "entry added",
+		image: "localhost:5000/db:v3",
+		wantImages: map[string]string{
+			"app":               "registry.example.com/app:v1",
+			"envoy":             "envoy@sha256:aaaa",
+			"localhost:5000/db": "localhost:5000/db:v3",
+		},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, old, err := SetKustomizeImage([]byte(kustomization), c.image)
+			if err != nil {
+				t.Fatalf("SetKustomizeImage error:%s", err)
+			}
+			if old != c.wantOld {
+				t.Errorf("old image = %q, want %q", old, c.wantOld)
+			}
+			images, err := KustomizeImages(got)
+			if err != nil {
+				t.Fatalf("KustomizeImages error:%s", err)
+			}
+			if fmt.Sprint(images) != fmt.Sprint(c.wantImages) {
+				t.Errorf("images = %v, want %v", images, c.wantImages)
+			}
+			if !strings.HasPrefix(string(got), "resources:\n- deployment.yaml\n") {
+				t.Errorf("kustomization lost its resources:\n%s", got)
+			}
+		})
+	}
+
+	got, _, err := SetKustomizeImage([]byte("resources:\n- deployment.yaml\n"), "app:v1")
+	if err != nil {
+		t.Fatalf("SetKustomizeImage error:%s", err)
+	}
+	if images, _ := KustomizeImages(got); images["app"] != "app:v1" {
+		t.Errorf("images = %v, want the images list added", images)
+	}
+}
@@ -0,0 +1,220 @@
+package lock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Lease is a lock held through a coordination.k8s.io Lease. The holder renews it while
+// it holds it, a Lease not renewed for its duration is stale and taken over.
+type Lease struct {
+	client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Holder    string
+	Duration  time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// renewed is the last time the Lease was renewed, lost is closed with lostErr when
+	// it is taken over or not renewed within Duration
+	renewed  time.Time
+	lost     chan struct{}
+	lostErr  error
+	lostOnce sync.Once
+}
+
+// NewLease returns the lock of the Lease namespace/name for the holder
+func NewLease(client kubernetes.Interface, namespace, name, holder string, duration time.Duration) *Lease {
+	return &Lease{client: client, Namespace: namespace, Name: name, Holder: holder, Duration: duration}
+}
+
+// Acquire waits up to timeout for the Lease, then renews it until Release
+func (l *Lease) Acquire(timeout time.Duration) error {
+	holder := ""
+	err := wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		current, ok, err := l.tryAcquire()
+		if err != nil {
+			return false, err
+		}
+		if !ok && current != holder {
+			glog.Infof("lease %s/%s is held by %s, waiting", l.Namespace, l.Name, current)
+			holder = current
+		}
+		return ok, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("lease %s/%s is still held by %s after %s", l.Namespace, l.Name, holder, timeout)
+	}
+	if err != nil {
+		glog.Errorf("acquire lease %s/%s error:%s", l.Namespace, l.Name, err)
+		return err
+	}
+	glog.Infof("lease %s/%s acquired by %s", l.Namespace, l.Name, l.Holder)
+
+	l.renewed = time.Now()
+	l.lost = make(chan struct{})
+	l.lostOnce = sync.Once{}
+	l.stop = make(chan struct{})
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		wait.Until(l.renew, l.Duration/3, l.stop)
+	}()
+	return nil
+}
+
+// tryAcquire takes the Lease when it is free, stale or already ours, it returns the
+// current holder otherwise. A conflict with another deployer is retried.
+func (l *Lease) tryAcquire() (string, bool, error) {
+	leases := l.client.CoordinationV1().Leases(l.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(l.Duration / time.Second)
+
+	lease, err := leases.Get(l.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: l.Name, Namespace: l.Namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &l.Holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(lease); err != nil {
+			if errors.IsAlreadyExists(err) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		return l.Holder, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if holder != "" && holder != l.Holder && !expired(lease, now.Time) {
+		return holder, false, nil
+	}
+	if holder != "" && holder != l.Holder {
+		glog.Warningf("lease %s/%s of %s is stale, taking it over", l.Namespace, l.Name, holder)
+	}
+	if holder != l.Holder {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &l.Holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(lease); err != nil {
+		if errors.IsConflict(err) {
+			return holder, false, nil
+		}
+		return "", false, err
+	}
+	return l.Holder, true, nil
+}
+
+func expired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(now)
+}
+
+// Lost is closed when the Lease is taken over or couldn't be renewed within its
+// duration, the holder must stop writing what the Lease protects
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err tells why the Lease was lost, nil while it is held
+func (l *Lease) Err() error {
+	select {
+	case <-l.lost:
+		return l.lostErr
+	default:
+		return nil
+	}
+}
+
+func (l *Lease) lose(err error) {
+	l.lostOnce.Do(func() {
+		glog.Errorf("lease %s/%s lost by %s: %s", l.Namespace, l.Name, l.Holder, err)
+		l.lostErr = err
+		close(l.lost)
+	})
+}
+
+// renew extends the Lease, a renewal failing past the duration of the Lease loses it as
+// another deployer may take it over
+func (l *Lease) renew() {
+	if l.Err() != nil {
+		return
+	}
+	leases := l.client.CoordinationV1().Leases(l.Namespace)
+	lease, err := leases.Get(l.Name, metav1.GetOptions{})
+	if err == nil && (lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Holder) {
+		holder := "nobody"
+		if lease.Spec.HolderIdentity != nil {
+			holder = *lease.Spec.HolderIdentity
+		}
+		l.lose(fmt.Errorf("lease %s/%s was taken over by %s", l.Namespace, l.Name, holder))
+		return
+	}
+	if err == nil {
+		now := metav1.NewMicroTime(time.Now())
+		lease.Spec.RenewTime = &now
+		if _, err = leases.Update(lease); err == nil {
+			l.renewed = now.Time
+			return
+		}
+	}
+	glog.Errorf("renew lease %s/%s error:%s", l.Namespace, l.Name, err)
+	if time.Since(l.renewed) > l.Duration {
+		l.lose(fmt.Errorf("lease %s/%s not renewed since %s: %s", l.Namespace, l.Name, l.renewed.Format(time.RFC3339), err))
+	}
+}
+
+// Release stops renewing the Lease and frees it when it is still ours
+func (l *Lease) Release() {
+	if l.stop != nil {
+		close(l.stop)
+		l.wg.Wait()
+		l.stop = nil
+	}
+	leases := l.client.CoordinationV1().Leases(l.Namespace)
+	lease, err := leases.Get(l.Name, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("release lease %s/%s error:%s", l.Namespace, l.Name, err)
+		return
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.Holder {
+		return
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.RenewTime = nil
+	if _, err := leases.Update(lease); err != nil {
+		glog.Errorf("release lease %s/%s error:%s", l.Namespace, l.Name, err)
+		return
+	}
+	glog.Infof("lease %s/%s released by %s", l.Namespace, l.Name, l.Holder)
+}
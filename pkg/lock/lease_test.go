@@ -0,0 +1,223 @@
+package lock
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// heldLease is the Lease default/app-deploy-lock of holder, renewed at renewed
+func heldLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	seconds := int32(30)
+	renewTime := metav1.NewMicroTime(renewed)
+	transitions := int32(1)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-deploy-lock"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			AcquireTime:          &renewTime,
+			RenewTime:            &renewTime,
+			LeaseTransitions:     &transitions,
+		},
+	}
+}
+
+func getLease(t *testing.T, client *fake.Clientset) *coordinationv1.Lease {
+	lease, err := client.CoordinationV1().Leases("default").Get("app-deploy-lock", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease error:%s", err)
+	}
+	return lease
+}
+
+func holderOf(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func TestTryAcquire(t *testing.T) {
+	cases := []struct {
+		name            string
+		existing        *coordinationv1.Lease
+		wantOK          bool
+		wantHolder      string
+		wantTransitions int32
+	}{{
+		name:       "no lease",
+		wantOK:     true,
+		wantHolder: "run-2",
+	}, {
+		name:            "held by another deployer",
+		existing:        heldLease("run-1", time.Now()),
+		wantHolder:      "run-1",
+		wantTransitions: 1,
+	}, {
+		name:            "stale lease taken over",
+		existing:        heldLease("run-1", time.Now().Add(-time.Minute)),
+		wantOK:          true,
+		wantHolder:      "run-2",
+		wantTransitions: 2,
+	}, {
+		name:            "already ours",
+		existing:        heldLease("run-2", time.Now()),
+		wantOK:          true,
+		wantHolder:      "run-2",
+		wantTransitions: 1,
+	}, {
+		name:            "released lease",
+		existing:        &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app-deploy-lock"}},
+		wantOK:          true,
+		wantHolder:      "run-2",
+		wantTransitions: 1,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			if c.existing != nil {
+				client = fake.NewSimpleClientset(c.existing)
+			}
+			l := NewLease(client, "default", "app-deploy-lock", "run-2", 30*time.Second)
+			holder, ok, err := l.tryAcquire()
+			if err != nil {
+				t.Fatalf("tryAcquire error:%s", err)
+			}
+			if ok != c.wantOK || holder != c.wantHolder {
+				t.Errorf("tryAcquire = %s, %v, want %s, %v", holder, ok, c.wantHolder, c.wantOK)
+			}
+			lease := getLease(t, client)
+			if got := holderOf(lease); got != c.wantHolder {
+				t.Errorf("lease held by %s, want %s", got, c.wantHolder)
+			}
+			if c.wantTransitions > 0 && (lease.Spec.LeaseTransitions == nil || *lease.Spec.LeaseTransitions != c.wantTransitions) {
+				t.Errorf("transitions = %v, want %d", lease.Spec.LeaseTransitions, c.wantTransitions)
+			}
+		})
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset(heldLease("run-1", time.Now()))
+	l := NewLease(client, "default", "app-deploy-lock", "run-2", 30*time.Second)
+	err := l.Acquire(10 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "still held by run-1") {
+		t.Fatalf("Acquire error:%v, want the lease held by run-1", err)
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	l := NewLease(client, "default", "app-deploy-lock", "run-2", 30*time.Second)
+	if err := l.Acquire(time.Second); err != nil {
+		t.Fatalf("Acquire error:%s", err)
+	}
+	if got := holderOf(getLease(t, client)); got != "run-2" {
+		t.Fatalf("lease held by %s, want run-2", got)
+	}
+	if err := l.Err(); err != nil {
+		t.Errorf("lease lost: %s", err)
+	}
+
+	l.Release()
+	lease := getLease(t, client)
+	if got := holderOf(lease); got != "" || lease.Spec.RenewTime != nil {
+		t.Errorf("released lease held by %q renewed at %v, want it free", got, lease.Spec.RenewTime)
+	}
+
+	other := NewLease(client, "default", "app-deploy-lock", "run-3", 30*time.Second)
+	if err := other.Acquire(time.Second); err != nil {
+		t.Fatalf("Acquire of the released lease error:%s", err)
+	}
+	l.Release()
+	if got := holderOf(getLease(t, client)); got != "run-3" {
+		t.Errorf("lease held by %s after a second release of run-2, want run-3", got)
+	}
+	other.Release()
+}
+
+func TestRenew(t *testing.T) {
+	cases := []struct {
+		name     string
+		holder   string
+		getErr   error
+		renewed  time.Duration
+		wantLost string
+	}{{
+		name:   "renewed",
+		holder: "run-2",
+	}, {
+		name:     "taken over",
+		holder:   "run-3",
+		wantLost: "taken over by run-3",
+	}, {
+		name:    "failure within the duration",
+		holder:  "run-2",
+		getErr:  fmt.Errorf("connection refused"),
+		renewed: 10 * time.Second,
+	}, {
+		name:     "failure past the duration",
+		holder:   "run-2",
+		getErr:   fmt.Errorf("connection refused"),
+		renewed:  time.Minute,
+		wantLost: "not renewed since",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(heldLease(c.holder, time.Now().Add(-c.renewed)))
+			if c.getErr != nil {
+				client.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, c.getErr
+				})
+			}
+			l := NewLease(client, "default", "app-deploy-lock", "run-2", 30*time.Second)
+			l.renewed = time.Now().Add(-c.renewed)
+			l.lost = make(chan struct{})
+
+			l.renew()
+			err := l.Err()
+			if c.wantLost == "" {
+				if err != nil {
+					t.Fatalf("lease lost: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantLost) {
+				t.Fatalf("lease lost: %v, want %q", err, c.wantLost)
+			}
+			select {
+			case <-l.Lost():
+			default:
+				t.Errorf("Lost is not closed")
+			}
+			l.renew()
+			if err := l.Err(); err == nil {
+				t.Errorf("lease held again after it was lost")
+			}
+		})
+	}
+}
+
+func TestRenewUpdatesTheLease(t *testing.T) {
+	client := fake.NewSimpleClientset(heldLease("run-2", time.Now().Add(-20*time.Second)))
+	l := NewLease(client, "default", "app-deploy-lock", "run-2", 30*time.Second)
+	l.renewed = time.Now().Add(-20 * time.Second)
+	l.lost = make(chan struct{})
+
+	l.renew()
+	lease := getLease(t, client)
+	if lease.Spec.RenewTime == nil || time.Since(lease.Spec.RenewTime.Time) > 5*time.Second {
+		t.Errorf("lease renewed at %v, want now", lease.Spec.RenewTime)
+	}
+	if time.Since(l.renewed) > 5*time.Second {
+		t.Errorf("renewed at %s, want now", l.renewed)
+	}
+}
@@ -0,0 +1,335 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+// The kinds of the messages
+const (
+	KindBuildStarted     = "build.started"
+	KindBuildFailed      = "build.failed"
+	KindDeploySucceeded  = "deploy.succeeded"
+	KindDeployFailed     = "deploy.failed"
+	KindDeployRolledBack = "deploy.rolledback"
+)
+
+// The types of the channels
+const (
+	TypeSlack    = "slack"
+	TypeDingTalk = "dingtalk"
+	TypeTeams    = "teams"
+	TypeWebhook  = "webhook"
+)
+
+// sendTimeout bounds the post of a message
+const sendTimeout = 10 * time.Second
+
+// Message is what is notified, the templates are executed on it
+type Message struct {
+	Kind string `json:"kind"`
+
+	Repo        string `json:"repo,omitempty"`
+	Commit      string `json:"commit,omitempty"`
+	PullRequest string `json:"pullRequest,omitempty"`
+	Author      string `json:"author,omitempty"`
+
+	// PipelineRun is the build, Task and Step where it failed
+	PipelineRun string `json:"pipelineRun,omitempty"`
+	Task        string `json:"task,omitempty"`
+	Step        string `json:"step,omitempty"`
+	// Retries is the number of times the build was retried before failing
+	Retries int `json:"retries,omitempty"`
+
+	Service     string    `json:"service,omitempty"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	Revision    string    `json:"revision,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Traffic     []Traffic `json:"traffic,omitempty"`
+
+	// Reason is why the build or the deploy failed, Log the tail of the log of the
+	// failed step
+	Reason string `json:"reason,omitempty"`
+	Log    string `json:"log,omitempty"`
+}
+
+// subject is the Service of a deploy, the PipelineRun of a build
+func (m *Message) subject() string {
+	if m.Service != "" {
+		return m.Namespace + "/" + m.Service
+	}
+	return m.Namespace + "/" + m.PipelineRun
+}
+
+// Traffic is a traffic target of the Service deployed
+type Traffic struct {
+	Revision string `json:"revision,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Percent  int    `json:"percent"`
+}
+
+// Config is the --notify-config file
+type Config struct {
+	Routes []Route `json:"routes"`
+}
+
+// Route sends the messages it matches to a channel
+type Route struct {
+	Name string `json:"name"`
+	// Repos and Services select the messages of the repositories, as a URL or as
+	// owner/name, and of the Services. A route setting both needs both to match,
+	// an empty list matches everything.
+	Repos    []string `json:"repos,omitempty"`
+	Services []string `json:"services,omitempty"`
+	// Kinds are the kinds of the messages sent, all when empty
+	Kinds []string `json:"kinds,omitempty"`
+
+	// Type is slack, dingtalk, teams or webhook. The ENVs in the URL are expanded so
+	// the secret part of an incoming webhook can come from a Secret.
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// Template is the text/template of the message text, the default of the kind when
+	// empty. It is the whole body of a webhook, which is the JSON of the message when
+	// no template is set.
+	Template string `json:"template,omitempty"`
+	// Templates override Template per kind
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// defaultTemplates are the texts of the kinds
+var defaultTemplates = map[string]string{
+	KindBuildStarted:     `Build {{.PipelineRun}} started for {{.Repo}}@{{short .Commit}}{{if .Author}} by {{.Author}}{{end}}`,
+	KindBuildFailed:      `Build {{.PipelineRun}} failed for {{.Repo}}@{{short .Commit}}{{if .Task}} in task {{.Task}}{{end}}{{if .Step}} step {{.Step}}{{end}}{{if .Retries}} after {{.Retries}} retries{{end}}{{if .Reason}}: {{.Reason}}{{end}}{{if .Log}}` + "\n```\n{{.Log}}\n```" + `{{end}}`,
+	KindDeploySucceeded:  `Deployed {{.Namespace}}/{{.Service}}{{if .Environment}} to {{.Environment}}{{end}} revision {{.Revision}}{{if .URL}} at {{.URL}}{{end}}{{range .Traffic}}{{"\n"}}{{.Percent}}% {{.Revision}}{{if .Tag}} ({{.Tag}}){{end}}{{end}}`,
+	KindDeployFailed:     `Deploy of {{.Namespace}}/{{.Service}}{{if .Environment}} to {{.Environment}}{{end}} failed{{if .Reason}}: {{.Reason}}{{end}}`,
+	KindDeployRolledBack: `Deploy of {{.Namespace}}/{{.Service}}{{if .Environment}} to {{.Environment}}{{end}} rolled back from revision {{.Revision}}{{if .Reason}}: {{.Reason}}{{end}}`,
+}
+
+var funcs = template.FuncMap{
+	"short": func(commit string) string {
+		if len(commit) > 8 {
+			return commit[:8]
+		}
+		return commit
+	},
+}
+
+// LoadConfig reads a Config yaml file
+func LoadConfig(path string) (*Config, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(bts, cfg); err != nil {
+		return nil, fmt.Errorf("parse notify config %s error:%s", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("notify config %s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	for i, r := range c.Routes {
+		switch r.Type {
+		case TypeSlack, TypeDingTalk, TypeTeams, TypeWebhook:
+		default:
+			return fmt.Errorf("route %d %s: unknown type %q", i, r.Name, r.Type)
+		}
+		if r.URL == "" {
+			return fmt.Errorf("route %d %s: url is empty", i, r.Name)
+		}
+		for _, k := range r.Kinds {
+			if _, ok := defaultTemplates[k]; !ok {
+				return fmt.Errorf("route %d %s: unknown kind %q", i, r.Name, k)
+			}
+		}
+		for k, t := range r.Templates {
+			if _, err := template.New(k).Funcs(funcs).Parse(t); err != nil {
+				return fmt.Errorf("route %d %s: template of %s: %s", i, r.Name, k, err)
+			}
+		}
+		if _, err := template.New(r.Name).Funcs(funcs).Parse(r.Template); err != nil {
+			return fmt.Errorf("route %d %s: template: %s", i, r.Name, err)
+		}
+	}
+	return nil
+}
+
+// Notifier posts the messages to the channels of the routes matching them, a nil
+// Notifier posts nothing
+type Notifier struct {
+	Config *Config
+	Client *http.Client
+
+	// posting are the posts in flight
+	posting sync.WaitGroup
+}
+
+// New returns the Notifier of the config file, nil when path is empty
+func New(path string) (*Notifier, error) {
+	if path == "" {
+		return nil, nil
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{Config: cfg}, nil
+}
+
+// Notify posts the message to every route matching it in the background, so a slow
+// channel doesn't hold the caller. Failing to post is only logged. The message must not
+// be changed afterwards.
+func (n *Notifier) Notify(m *Message) {
+	if n == nil {
+		return
+	}
+	for i := range n.Config.Routes {
+		r := &n.Config.Routes[i]
+		if !r.matches(m) {
+			continue
+		}
+		n.posting.Add(1)
+		go func() {
+			defer n.posting.Done()
+			if err := n.post(r, m); err != nil {
+				glog.Errorf("notify %s of %s to route %s error:%s", m.Kind, m.subject(), r.Name, err)
+				return
+			}
+			glog.Infof("notified %s of %s to route %s", m.Kind, m.subject(), r.Name)
+		}()
+	}
+}
+
+// Wait waits for the posts in flight, a process notifying has to call it before exiting
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.posting.Wait()
+}
+
+func (r *Route) matches(m *Message) bool {
+	if len(r.Kinds) > 0 && !contains(r.Kinds, m.Kind) {
+		return false
+	}
+	if len(r.Repos) > 0 && !matchRepo(r.Repos, m.Repo) {
+		return false
+	}
+	if len(r.Services) > 0 && !contains(r.Services, m.Service) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRepo matches the repository URL against URLs and owner/name
+func matchRepo(repos []string, repo string) bool {
+	if repo == "" {
+		return false
+	}
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+	for _, r := range repos {
+		r = strings.TrimSuffix(strings.TrimSuffix(r, "/"), ".git")
+		if r == repo || strings.HasSuffix(repo, "/"+r) {
+			return true
+		}
+	}
+	return false
+}
+
+// text renders the template of the route for the message
+func (r *Route) text(m *Message) (string, error) {
+	tmpl := r.Templates[m.Kind]
+	if tmpl == "" {
+		tmpl = r.Template
+	}
+	if tmpl == "" {
+		tmpl = defaultTemplates[m.Kind]
+	}
+	t, err := template.New(m.Kind).Funcs(funcs).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, m); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// body is the payload of the channel of the route
+func (r *Route) body(m *Message) ([]byte, error) {
+	if r.Type == TypeWebhook && r.Template == "" && r.Templates[m.Kind] == "" {
+		return json.Marshal(m)
+	}
+	text, err := r.text(m)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Type {
+	case TypeSlack:
+		return json.Marshal(map[string]interface{}{"text": text})
+	case TypeDingTalk:
+		return json.Marshal(map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"title": m.Kind, "text": text},
+		})
+	case TypeTeams:
+		return json.Marshal(map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "http://schema.org/extensions",
+			"summary":  m.Kind,
+			"text":     text,
+		})
+	}
+	return []byte(text), nil
+}
+
+func (n *Notifier) post(r *Route, m *Message) error {
+	body, err := r.body(m)
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: sendTimeout}
+	}
+	resp, err := client.Post(os.ExpandEnv(r.URL), "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the URL of an incoming webhook is its secret, don't log it
+		if uerr, ok := err.(*url.Error); ok {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bts, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(bts)))
+	}
+	return nil
+}
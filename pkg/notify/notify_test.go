@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifyInBackground(t *testing.T) {
+	release := make(chan struct{})
+	mu := sync.Mutex{}
+	bodies := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		bts, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(bts))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	n := &Notifier{Config: &Config{Routes: []Route{
+		{Name: "deploys", Type: TypeWebhook, URL: srv.URL, Kinds: []string{KindDeployFailed}, Template: "{{.Service}} failed"},
+		{Name: "builds", Type: TypeWebhook, URL: srv.URL, Kinds: []string{KindBuildFailed}},
+	}}}
+
+	done := make(chan struct{})
+	go func() {
+		n.Notify(&Message{Kind: KindDeployFailed, Namespace: "default", Service: "app"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Notify waited for the slow channel")
+	}
+
+	close(release)
+	n.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 || bodies[0] != "app failed" {
+		t.Errorf("posted %q, want the deploy route only", bodies)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(&Message{Kind: KindDeployFailed})
+	n.Wait()
+}
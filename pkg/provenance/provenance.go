@@ -0,0 +1,186 @@
+package provenance
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	GroupName = "tekton-serving.dev"
+
+	// CommitKey is the commit sha the object was built from
+	CommitKey = GroupName + "/commit"
+	// RepoKey is the repository of the commit
+	RepoKey = GroupName + "/repo"
+	// PullRequestKey is the URL of the pull request which produced the commit
+	PullRequestKey = GroupName + "/pull-request"
+	// PipelineRunKey is the PipelineRun which built and deployed the commit
+	PipelineRunKey = GroupName + "/pipelinerun"
+	// DeliveryKey is the delivery ID of the GitHub event which triggered the PipelineRun
+	DeliveryKey = GroupName + "/delivery"
+	// AuthorKey is the person who produced the change
+	AuthorKey = GroupName + "/author"
+	// CommitTimeKey is the RFC3339 time of the commit, the merge time of the pull request
+	CommitTimeKey = GroupName + "/commit-time"
+	// BuildSequenceKey is an increasing number of the build, later builds have greater ones
+	BuildSequenceKey = GroupName + "/build-sequence"
+)
+
+// Keys are the annotations of a provenance
+var Keys = []string{CommitKey, RepoKey, PullRequestKey, PipelineRunKey, DeliveryKey, AuthorKey, CommitTimeKey, BuildSequenceKey}
+
+// labelValue is what Kubernetes accepts as a label value
+var labelValue = regexp.MustCompile(`^(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?$`)
+
+// Provenance records where a deployed revision comes from
+type Provenance struct {
+	Commit      string `json:"commit,omitempty"`
+	Repo        string `json:"repo,omitempty"`
+	PullRequest string `json:"pullRequest,omitempty"`
+	PipelineRun string `json:"pipelineRun,omitempty"`
+	Delivery    string `json:"delivery,omitempty"`
+	Author      string `json:"author,omitempty"`
+	// CommitTime and BuildSequence order the builds
+	CommitTime    string `json:"commitTime,omitempty"`
+	BuildSequence string `json:"buildSequence,omitempty"`
+}
+
+// env are the fields and their env
+func (p *Provenance) env() []struct {
+	v   *string
+	env string
+} {
+	return []struct {
+		v   *string
+		env string
+	}{
+		{&p.Commit, "COMMIT_SHA"},
+		{&p.Repo, "REPO_URL"},
+		{&p.PullRequest, "PR_URL"},
+		{&p.PipelineRun, "PIPELINERUN_NAME"},
+		{&p.Delivery, "DELIVERY_ID"},
+		{&p.Author, "AUTHOR"},
+		{&p.CommitTime, "COMMIT_TIME"},
+		{&p.BuildSequence, "BUILD_SEQUENCE"},
+	}
+}
+
+// FromEnv fills the fields not set yet from COMMIT_SHA, REPO_URL, PR_URL,
+// PIPELINERUN_NAME, DELIVERY_ID, AUTHOR, COMMIT_TIME and BUILD_SEQUENCE
+func (p *Provenance) FromEnv() {
+	for _, f := range p.env() {
+		if *f.v == "" {
+			*f.v = os.Getenv(f.env)
+		}
+	}
+}
+
+// FromEnvMap reads a provenance from the env Env returns
+func FromEnvMap(env map[string]string) Provenance {
+	p := Provenance{}
+	for _, f := range p.env() {
+		*f.v = env[f.env]
+	}
+	return p
+}
+
+// Env returns every field set by the env FromEnv reads it from
+func (p *Provenance) Env() map[string]string {
+	env := map[string]string{}
+	for _, f := range p.env() {
+		if *f.v != "" {
+			env[f.env] = *f.v
+		}
+	}
+	return env
+}
+
+// Fill sets the fields not set yet from another provenance
+func (p *Provenance) Fill(from Provenance) {
+	for _, f := range []struct{ v, from *string }{
+		{&p.Commit, &from.Commit},
+		{&p.Repo, &from.Repo},
+		{&p.PullRequest, &from.PullRequest},
+		{&p.PipelineRun, &from.PipelineRun},
+		{&p.Delivery, &from.Delivery},
+		{&p.Author, &from.Author},
+		{&p.CommitTime, &from.CommitTime},
+		{&p.BuildSequence, &from.BuildSequence},
+	} {
+		if *f.v == "" {
+			*f.v = *f.from
+		}
+	}
+}
+
+// Annotations returns every field set as annotations
+func (p *Provenance) Annotations() map[string]string {
+	annotations := map[string]string{}
+	for k, v := range map[string]string{
+		CommitKey:        p.Commit,
+		RepoKey:          p.Repo,
+		PullRequestKey:   p.PullRequest,
+		PipelineRunKey:   p.PipelineRun,
+		DeliveryKey:      p.Delivery,
+		AuthorKey:        p.Author,
+		CommitTimeKey:    p.CommitTime,
+		BuildSequenceKey: p.BuildSequence,
+	} {
+		if v != "" {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
+// Labels returns the fields which can be selected on, and are valid label values
+func (p *Provenance) Labels() map[string]string {
+	labels := map[string]string{}
+	for k, v := range map[string]string{
+		CommitKey:      p.Commit,
+		PipelineRunKey: p.PipelineRun,
+		DeliveryKey:    p.Delivery,
+	} {
+		if v != "" && len(v) <= 63 && labelValue.MatchString(v) {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// FromAnnotations reads the provenance stamped on an object
+func FromAnnotations(annotations map[string]string) Provenance {
+	return Provenance{
+		Commit:        annotations[CommitKey],
+		Repo:          annotations[RepoKey],
+		PullRequest:   annotations[PullRequestKey],
+		PipelineRun:   annotations[PipelineRunKey],
+		Delivery:      annotations[DeliveryKey],
+		Author:        annotations[AuthorKey],
+		CommitTime:    annotations[CommitTimeKey],
+		BuildSequence: annotations[BuildSequenceKey],
+	}
+}
+
+// OlderThan tells the build is older than another one, by build sequence when both
+// have one, by commit time otherwise. Builds which can't be ordered are not older.
+func (p *Provenance) OlderThan(o Provenance) (bool, string) {
+	if p.BuildSequence != "" && o.BuildSequence != "" {
+		a, errA := strconv.ParseInt(p.BuildSequence, 10, 64)
+		b, errB := strconv.ParseInt(o.BuildSequence, 10, 64)
+		if errA == nil && errB == nil {
+			return a < b, fmt.Sprintf("build sequence %d is older than %d", a, b)
+		}
+	}
+	if p.CommitTime != "" && o.CommitTime != "" {
+		a, errA := time.Parse(time.RFC3339, p.CommitTime)
+		b, errB := time.Parse(time.RFC3339, o.CommitTime)
+		if errA == nil && errB == nil {
+			return a.Before(b), fmt.Sprintf("commit time %s is older than %s", p.CommitTime, o.CommitTime)
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,67 @@
+package provenance
+
+import "testing"
+
+func TestOlderThan(t *testing.T) {
+	cases := []struct {
+		name  string
+		build Provenance
+		other Provenance
+		want  bool
+	}{{
+		name:  "lower build sequence",
+		build: Provenance{BuildSequence: "9"},
+		other: Provenance{BuildSequence: "10"},
+		want:  true,
+	}, {
+		name:  "higher build sequence",
+		build: Provenance{BuildSequence: "11"},
+		other: Provenance{BuildSequence: "10"},
+	}, {
+		name:  "same build sequence",
+		build: Provenance{BuildSequence: "10"},
+		other: Provenance{BuildSequence: "10"},
+	}, {
+		name:  "build sequence wins over commit time",
+		build: Provenance{BuildSequence: "11", CommitTime: "2020-03-01T00:00:00Z"},
+		other: Provenance{BuildSequence: "10", CommitTime: "2020-03-02T00:00:00Z"},
+	}, {
+		name:  "commit time when a build sequence is missing",
+		build: Provenance{BuildSequence: "11", CommitTime: "2020-03-01T00:00:00Z"},
+		other: Provenance{CommitTime: "2020-03-02T00:00:00Z"},
+		want:  true,
+	}, {
+		name:  "commit time when a build sequence is not a number",
+		build: Provenance{BuildSequence: "abc", CommitTime: "2020-03-01T00:00:00Z"},
+		other: Provenance{BuildSequence: "10", CommitTime: "2020-03-02T00:00:00Z"},
+		want:  true,
+	}, {
+		name:  "commit times of other zones",
+		build: Provenance{CommitTime: "2020-03-02T07:00:00+08:00"},
+		other: Provenance{CommitTime: "2020-03-01T23:30:00Z"},
+		want:  true,
+	}, {
+		name:  "newer commit",
+		build: Provenance{CommitTime: "2020-03-02T00:00:00Z"},
+		other: Provenance{CommitTime: "2020-03-01T00:00:00Z"},
+	}, {
+		name:  "invalid commit time",
+		build: Provenance{CommitTime: "yesterday"},
+		other: Provenance{CommitTime: "2020-03-01T00:00:00Z"},
+	}, {
+		name:  "nothing to order",
+		build: Provenance{Commit: "a"},
+		other: Provenance{Commit: "b"},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			older, why := c.build.OlderThan(c.other)
+			if older != c.want {
+				t.Errorf("OlderThan = %v (%s), want %v", older, why, c.want)
+			}
+			if older && why == "" {
+				t.Errorf("OlderThan gives no reason")
+			}
+		})
+	}
+}
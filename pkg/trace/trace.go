@@ -0,0 +1,345 @@
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative/serving/pkg/apis/serving"
+	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	servingclientset "github.com/knative/serving/pkg/client/clientset/versioned"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+	duckv1beta1 "knative.dev/pkg/apis/duck/v1beta1"
+)
+
+// Trace is every chain found for a query
+type Trace struct {
+	Query  string  `json:"query"`
+	Chains []Chain `json:"chains"`
+}
+
+// Chain follows one commit from the GitHub event to the traffic of its revision. Any
+// link may be missing: a PipelineRun still building has no Revision, a Revision
+// deployed by hand has no PipelineRun.
+type Chain struct {
+	Event       provenance.Provenance `json:"event"`
+	PipelineRun *Run                  `json:"pipelineRun,omitempty"`
+	Revision    *Revision             `json:"revision,omitempty"`
+}
+
+// Run is a PipelineRun or a TaskRun
+type Run struct {
+	Name         string     `json:"name"`
+	PipelineTask string     `json:"pipelineTask,omitempty"`
+	Status       string     `json:"status"`
+	Reason       string     `json:"reason,omitempty"`
+	StartTime    *time.Time `json:"startTime,omitempty"`
+	Duration     string     `json:"duration,omitempty"`
+	// Digests are the image digests the TaskRun reported
+	Digests  []string `json:"digests,omitempty"`
+	TaskRuns []Run    `json:"taskRuns,omitempty"`
+}
+
+// Revision is a Knative Revision and the traffic it serves
+type Revision struct {
+	Name        string    `json:"name"`
+	Service     string    `json:"service"`
+	Ready       bool      `json:"ready"`
+	Created     time.Time `json:"created"`
+	Images      []string  `json:"images"`
+	ImageDigest string    `json:"imageDigest,omitempty"`
+	Traffic     []Traffic `json:"traffic"`
+}
+
+// Traffic is a traffic target of the Service routing to the revision
+type Traffic struct {
+	Percent int    `json:"percent"`
+	Tag     string `json:"tag,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Tracer follows the labels and annotations the trigger and the deployer put on the
+// PipelineRuns and the revision templates
+type Tracer struct {
+	Namespace string
+	Serving   servingclientset.Interface
+	Tekton    tektonclientset.Interface
+
+	services map[string]*servingv1alpha1.Service
+}
+
+// Trace resolves the query as a Service name, a Revision name or a commit sha, in
+// that order. A commit may be abbreviated.
+func (t *Tracer) Trace(query string) (*Trace, error) {
+	t.services = map[string]*servingv1alpha1.Service{}
+	revs, err := t.findRevisions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &Trace{Query: query, Chains: []Chain{}}
+	for i := range revs {
+		chain, err := t.revisionChain(&revs[i])
+		if err != nil {
+			return nil, err
+		}
+		tr.Chains = append(tr.Chains, *chain)
+	}
+	if len(revs) > 0 {
+		return tr, nil
+	}
+
+	// the commit may still be building
+	prs, err := t.pipelineRunsOfCommit(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range prs {
+		run, prov, err := t.pipelineRun(name)
+		if err != nil {
+			return nil, err
+		}
+		tr.Chains = append(tr.Chains, Chain{Event: prov, PipelineRun: run})
+	}
+	if len(tr.Chains) == 0 {
+		return nil, fmt.Errorf("no Service, Revision or commit %q found in namespace %s", query, t.Namespace)
+	}
+	return tr, nil
+}
+
+// findRevisions returns the revisions routed by the Service, the Revision, or the
+// revisions built from the commit
+func (t *Tracer) findRevisions(query string) ([]servingv1alpha1.Revision, error) {
+	revisions := t.Serving.ServingV1alpha1().Revisions(t.Namespace)
+
+	svc, err := t.service(query)
+	if err != nil {
+		return nil, err
+	}
+	if svc != nil {
+		names := []string{}
+		seen := map[string]bool{}
+		for _, tt := range svc.Status.Traffic {
+			if tt.RevisionName != "" && !seen[tt.RevisionName] {
+				seen[tt.RevisionName] = true
+				names = append(names, tt.RevisionName)
+			}
+		}
+		revs := []servingv1alpha1.Revision{}
+		for _, name := range names {
+			rev, err := revisions.Get(name, metav1.GetOptions{})
+			if err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return nil, err
+			}
+			revs = append(revs, *rev)
+		}
+		return revs, nil
+	}
+
+	rev, err := revisions.Get(query, metav1.GetOptions{})
+	if err == nil {
+		return []servingv1alpha1.Revision{*rev}, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	list, err := revisions.List(metav1.ListOptions{LabelSelector: provenance.CommitKey + "=" + query})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		// an abbreviated sha only matches the annotation prefix
+		if list, err = revisions.List(metav1.ListOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	revs := []servingv1alpha1.Revision{}
+	for _, rev := range list.Items {
+		if matchCommit(rev.Annotations[provenance.CommitKey], query) {
+			revs = append(revs, rev)
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].CreationTimestamp.After(revs[j].CreationTimestamp.Time)
+	})
+	return revs, nil
+}
+
+func matchCommit(commit, query string) bool {
+	return commit != "" && len(query) >= 4 && strings.HasPrefix(commit, query)
+}
+
+// service returns nil when the Service doesn't exist
+func (t *Tracer) service(name string) (*servingv1alpha1.Service, error) {
+	if svc, ok := t.services[name]; ok {
+		return svc, nil
+	}
+	svc, err := t.Serving.ServingV1alpha1().Services(t.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		svc = nil
+	}
+	t.services[name] = svc
+	return svc, nil
+}
+
+func (t *Tracer) revisionChain(rev *servingv1alpha1.Revision) (*Chain, error) {
+	r := &Revision{
+		Name:        rev.Name,
+		Service:     rev.Labels[serving.ServiceLabelKey],
+		Ready:       rev.Status.IsReady(),
+		Created:     rev.CreationTimestamp.Time,
+		Images:      []string{},
+		ImageDigest: rev.Status.ImageDigest,
+		Traffic:     []Traffic{},
+	}
+	for _, c := range rev.Spec.Containers {
+		r.Images = append(r.Images, c.Image)
+	}
+	if r.Service != "" {
+		svc, err := t.service(r.Service)
+		if err != nil {
+			return nil, err
+		}
+		if svc != nil {
+			for _, tt := range svc.Status.Traffic {
+				if tt.RevisionName != rev.Name {
+					continue
+				}
+				traffic := Traffic{Percent: tt.Percent, Tag: tt.Tag}
+				if tt.URL != nil {
+					traffic.URL = tt.URL.String()
+				}
+				r.Traffic = append(r.Traffic, traffic)
+			}
+		}
+	}
+
+	chain := &Chain{Event: provenance.FromAnnotations(rev.Annotations), Revision: r}
+	name := chain.Event.PipelineRun
+	if name == "" && chain.Event.Commit != "" {
+		prs, err := t.pipelineRunsOfCommit(chain.Event.Commit)
+		if err != nil {
+			return nil, err
+		}
+		if len(prs) > 0 {
+			name = prs[0]
+		}
+	}
+	if name == "" {
+		return chain, nil
+	}
+
+	run, prov, err := t.pipelineRun(name)
+	if err != nil {
+		return nil, err
+	}
+	chain.PipelineRun = run
+	// complete the provenance of the revision with the one stamped by the trigger
+	chain.Event.Fill(prov)
+	return chain, nil
+}
+
+// pipelineRunsOfCommit returns the PipelineRuns the trigger created for the commit, newest first
+func (t *Tracer) pipelineRunsOfCommit(commit string) ([]string, error) {
+	prs := t.Tekton.TektonV1alpha1().PipelineRuns(t.Namespace)
+	list, err := prs.List(metav1.ListOptions{LabelSelector: provenance.CommitKey + "=" + commit})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		if list, err = prs.List(metav1.ListOptions{LabelSelector: provenance.CommitKey}); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].CreationTimestamp.After(list.Items[j].CreationTimestamp.Time)
+	})
+	names := []string{}
+	for _, pr := range list.Items {
+		if matchCommit(pr.Labels[provenance.CommitKey], commit) {
+			names = append(names, pr.Name)
+		}
+	}
+	return names, nil
+}
+
+// pipelineRun returns the PipelineRun with its TaskRuns, and the provenance the trigger
+// stamped on it. A PipelineRun deleted since is reported as NotFound.
+func (t *Tracer) pipelineRun(name string) (*Run, provenance.Provenance, error) {
+	pr, err := t.Tekton.TektonV1alpha1().PipelineRuns(t.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			glog.Warningf("PipelineRun %s/%s not found", t.Namespace, name)
+			return &Run{Name: name, Status: "NotFound"}, provenance.Provenance{}, nil
+		}
+		return nil, provenance.Provenance{}, err
+	}
+	run := NewRun(name, &pr.Status.Status, pr.Status.StartTime, pr.Status.CompletionTime)
+
+	trs, err := t.Tekton.TektonV1alpha1().TaskRuns(t.Namespace).List(metav1.ListOptions{
+		LabelSelector: pipeline.GroupName + pipeline.PipelineRunLabelKey + "=" + name,
+	})
+	if err != nil {
+		return nil, provenance.Provenance{}, err
+	}
+	for _, tr := range trs.Items {
+		task := NewRun(tr.Name, &tr.Status.Status, tr.Status.StartTime, tr.Status.CompletionTime)
+		task.PipelineTask = tr.Labels[pipeline.GroupName+pipeline.PipelineTaskLabelKey]
+		for _, res := range tr.Status.ResourcesResult {
+			if res.Key == "digest" {
+				task.Digests = append(task.Digests, res.Value)
+			}
+		}
+		run.TaskRuns = append(run.TaskRuns, *task)
+	}
+	sort.SliceStable(run.TaskRuns, func(i, j int) bool {
+		a, b := run.TaskRuns[i].StartTime, run.TaskRuns[j].StartTime
+		return a != nil && (b == nil || a.Before(*b))
+	})
+
+	prov := provenance.FromAnnotations(pr.Annotations)
+	if prov.PipelineRun == "" {
+		prov.PipelineRun = name
+	}
+	return run, prov, nil
+}
+
+// NewRun is the Run of the status of a PipelineRun or a TaskRun
+func NewRun(name string, status *duckv1beta1.Status, start, completion *metav1.Time) *Run {
+	run := &Run{Name: name, Status: "Pending"}
+	if cond := status.GetCondition(apis.ConditionSucceeded); cond != nil {
+		switch {
+		case cond.IsTrue():
+			run.Status = "Succeeded"
+		case cond.IsFalse():
+			run.Status = "Failed"
+		default:
+			run.Status = "Running"
+		}
+		run.Reason = cond.Reason
+	}
+	if start != nil {
+		s := start.Time
+		run.StartTime = &s
+		end := time.Now()
+		if completion != nil {
+			end = completion.Time
+		}
+		run.Duration = end.Sub(s).Round(time.Second).String()
+	}
+	return run
+}
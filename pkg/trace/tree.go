@@ -0,0 +1,133 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// node is a line of the tree with its children
+type node struct {
+	label    string
+	children []*node
+}
+
+func (n *node) add(format string, a ...interface{}) *node {
+	child := &node{label: fmt.Sprintf(format, a...)}
+	n.children = append(n.children, child)
+	return child
+}
+
+// PrintTree writes every chain as a tree rooted at the GitHub event
+func PrintTree(w io.Writer, tr *Trace) error {
+	for i, chain := range tr.Chains {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		root := chainTree(&chain)
+		fmt.Fprintln(w, root.label)
+		if err := printChildren(w, root, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func chainTree(chain *Chain) *node {
+	ev := chain.Event
+	root := &node{label: "event " + orUnknown(ev.Delivery)}
+	if ev.Commit != "" {
+		root.add("commit %s", ev.Commit)
+	}
+	if ev.Repo != "" {
+		root.add("repo %s", ev.Repo)
+	}
+	if ev.PullRequest != "" {
+		root.add("pull request %s", ev.PullRequest)
+	}
+	if ev.Author != "" {
+		root.add("author %s", ev.Author)
+	}
+
+	parent := root
+	if pr := chain.PipelineRun; pr != nil {
+		parent = root.add("pipelinerun %s %s", pr.Name, runStatus(pr))
+		for i := range pr.TaskRuns {
+			tr := &pr.TaskRuns[i]
+			label := tr.Name
+			if tr.PipelineTask != "" {
+				label += " (" + tr.PipelineTask + ")"
+			}
+			task := parent.add("taskrun %s %s", label, runStatus(tr))
+			for _, digest := range tr.Digests {
+				task.add("digest %s", digest)
+			}
+		}
+	}
+
+	rev := chain.Revision
+	if rev == nil {
+		parent.add("revision not deployed")
+		return root
+	}
+	ready := "NotReady"
+	if rev.Ready {
+		ready = "Ready"
+	}
+	revNode := parent.add("revision %s/%s %s created %s", rev.Service, rev.Name, ready, rev.Created.Format(time.RFC3339))
+	for _, image := range rev.Images {
+		revNode.add("image %s", image)
+	}
+	if rev.ImageDigest != "" && (len(rev.Images) != 1 || rev.Images[0] != rev.ImageDigest) {
+		revNode.add("digest %s", rev.ImageDigest)
+	}
+	if len(rev.Traffic) == 0 {
+		revNode.add("traffic none")
+	}
+	for _, t := range rev.Traffic {
+		label := fmt.Sprintf("traffic %d%%", t.Percent)
+		if t.Tag != "" {
+			label += " tag " + t.Tag
+		}
+		if t.URL != "" {
+			label += " " + t.URL
+		}
+		revNode.add("%s", label)
+	}
+	return root
+}
+
+func runStatus(r *Run) string {
+	parts := []string{r.Status}
+	if r.Reason != "" && r.Reason != r.Status {
+		parts = append(parts, "("+r.Reason+")")
+	}
+	if r.Duration != "" {
+		parts = append(parts, r.Duration)
+	}
+	return strings.Join(parts, " ")
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "<unknown>"
+	}
+	return s
+}
+
+func printChildren(w io.Writer, n *node, prefix string) error {
+	for i, child := range n.children {
+		branch, indent := "├── ", "│   "
+		if i == len(n.children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		if _, err := fmt.Fprintf(w, "%s%s%s\n", prefix, branch, child.label); err != nil {
+			return err
+		}
+		if err := printChildren(w, child, prefix+indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
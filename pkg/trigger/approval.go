@@ -0,0 +1,285 @@
+package trigger
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/pkg/cloudevents"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/approval"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	gh "gopkg.in/go-playground/webhooks.v5/github"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// approverAssociations are the author associations allowed to approve when no
+// --approvers are set
+var approverAssociations = map[string]bool{"OWNER": true, "MEMBER": true, "COLLABORATOR": true}
+
+// issueCommentEvent decides the pending approvals of the deploys of a pull request on an
+// /approve or /reject [reason] comment and replies the approvals decided, the other
+// comments are ChatOps commands
+func (dp *Trigger) issueCommentEvent(e cloudevents.Event) (*Reply, error) {
+	payload := &gh.IssueCommentPayload{}
+	data, ok := e.Data.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(e.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, payload); err != nil {
+		glog.Errorf("parse issue comment error:%s", err)
+		return nil, err
+	}
+	if payload.Action != "created" {
+		return skipped(e, RuleApprovalComment, "comment is "+payload.Action), nil
+	}
+
+	state, reason := parseApprovalComment(payload.Comment.Body)
+	if state == "" {
+		return dp.chatOpsEvent(e, payload)
+	}
+	user := payload.Comment.User.Login
+	if !dp.canApprove(user, payload.Comment.AuthorAssociation) {
+		glog.Warningf("%s is not allowed to decide the deploys of %s", user, payload.Issue.HTMLURL)
+		return skipped(e, RuleApprovalComment, user+" is not allowed to decide the deploys"), nil
+	}
+	if state == approval.StateApproved && !dp.AllowSelfApproval && strings.EqualFold(user, payload.Issue.User.Login) {
+		glog.Warningf("%s can't approve the deploys of its own pull request %s", user, payload.Issue.HTMLURL)
+		return skipped(e, RuleApprovalComment, user+" is the author of the pull request and can't approve its deploys"), nil
+	}
+
+	client := dp.watcher.kube
+	pending, err := approval.ForPullRequest(client, payload.Issue.HTMLURL)
+	if err != nil {
+		glog.Errorf("list approvals of %s error:%s", payload.Issue.HTMLURL, err)
+		return nil, err
+	}
+	if len(pending) == 0 {
+		glog.Infof("no pending approval for %s", payload.Issue.HTMLURL)
+		return skipped(e, RuleApprovalComment, "no pending approval for "+payload.Issue.HTMLURL), nil
+	}
+	reply := &Reply{Type: events.TypeApprovalDecided, Subject: payload.Issue.HTMLURL, Event: e.ID(), Rule: RuleApprovalComment}
+	for _, cm := range pending {
+		d := approval.Decision{State: state, By: user, Source: approval.SourceComment, Reason: reason}
+		if err := approval.Decide(client, cm.Namespace, cm.Name, d); err != nil {
+			glog.Errorf("decide approval %s/%s error:%s", cm.Namespace, cm.Name, err)
+			continue
+		}
+		glog.Infof("approval %s/%s %s by %s on %s", cm.Namespace, cm.Name, state, user, payload.Comment.HTMLURL)
+		reply.Approvals = append(reply.Approvals, cm.Namespace+"/"+cm.Name)
+	}
+	return reply, nil
+}
+
+// parseApprovalComment returns the decision of the first line of the comment, an empty
+// state when it is not an approval command
+func parseApprovalComment(body string) (string, string) {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(body), "\n", 2)[0])
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	reason := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch fields[0] {
+	case "/approve":
+		return approval.StateApproved, reason
+	case "/reject":
+		return approval.StateRejected, reason
+	}
+	return "", ""
+}
+
+func (dp *Trigger) canApprove(user, association string) bool {
+	if len(dp.Approvers) == 0 {
+		return approverAssociations[association]
+	}
+	for _, a := range dp.Approvers {
+		if strings.EqualFold(a, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTokens reads the admin tokens file, one user=token per line
+func loadTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("admin tokens %s: %q is not user=token", path, line)
+		}
+		tokens[strings.TrimSpace(parts[1])] = strings.TrimSpace(parts[0])
+	}
+	return tokens, scanner.Err()
+}
+
+// adminServer serves the approvals over http, every request is authenticated with a
+// bearer token of the tokens file
+type adminServer struct {
+	tokens map[string]string
+	client kubernetes.Interface
+	runs   *runWatcher
+}
+
+// newAdminServer loads the tokens of the admin endpoint, there is no endpoint without them
+func (dp *Trigger) newAdminServer() (*adminServer, error) {
+	if dp.AdminTokens == "" {
+		return nil, fmt.Errorf("the admin endpoint %s has no tokens file", dp.AdminAddress)
+	}
+	tokens, err := loadTokens(dp.AdminTokens)
+	if err != nil {
+		glog.Errorf("load admin tokens error:%s", err)
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("admin tokens %s has no token", dp.AdminTokens)
+	}
+	return &adminServer{tokens: tokens, client: dp.watcher.kube, runs: dp.watcher}, nil
+}
+
+// handler serves GET /approvals listing the pending approvals,
+// POST /approvals/<namespace>/<name> with decision=approved|rejected and a reason, and
+// GET /runs/<name>?namespace=<namespace> with the summary of a PipelineRun
+func (s *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/approvals", s.approvals)
+	mux.HandleFunc("/approvals/", s.approvals)
+	mux.HandleFunc("/runs/", s.run)
+	return mux
+}
+
+// serveAdmin listens on AdminAddress and serves the admin endpoint in the background. A
+// missing tokens file or an address already in use fails the start of the trigger, the
+// endpoint is never served without authentication.
+func (dp *Trigger) serveAdmin() error {
+	s, err := dp.newAdminServer()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", dp.AdminAddress)
+	if err != nil {
+		glog.Errorf("listen on %s error:%s", dp.AdminAddress, err)
+		return err
+	}
+	glog.Infof("admin server listening on %s", dp.AdminAddress)
+	go func() {
+		if err := http.Serve(l, s.handler()); err != nil {
+			glog.Errorf("admin server %s error:%s", dp.AdminAddress, err)
+		}
+	}()
+	return nil
+}
+
+// user returns the user of the bearer token, empty when the token is unknown
+func (s *adminServer) user(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return ""
+	}
+	for t, user := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return user
+		}
+	}
+	return ""
+}
+
+func (s *adminServer) approvals(w http.ResponseWriter, r *http.Request) {
+	user := s.user(r)
+	if user == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/approvals"), "/")
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		pending, err := approval.Pending(s.client, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type item struct {
+			Namespace string            `json:"namespace"`
+			Name      string            `json:"name"`
+			Data      map[string]string `json:"data"`
+		}
+		items := []item{}
+		for _, cm := range pending {
+			items = append(items, item{Namespace: cm.Namespace, Name: cm.Name, Data: cm.Data})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+
+	case r.Method == http.MethodPost && strings.Count(path, "/") == 1:
+		parts := strings.SplitN(path, "/", 2)
+		state := r.FormValue("decision")
+		if state != approval.StateApproved && state != approval.StateRejected {
+			http.Error(w, "decision must be approved or rejected", http.StatusBadRequest)
+			return
+		}
+		d := approval.Decision{State: state, By: user, Source: approval.SourceHTTP, Reason: r.FormValue("reason")}
+		if err := approval.Decide(s.client, parts[0], parts[1], d); err != nil {
+			code := http.StatusConflict
+			if approval.IsNotFound(err) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		glog.Infof("approval %s %s by %s over http", path, state, user)
+		fmt.Fprintf(w, "approval %s %s by %s\n", path, state, user)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// run serves the summary of a PipelineRun the trigger created
+func (s *adminServer) run(w http.ResponseWriter, r *http.Request) {
+	if s.user(r) == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs"), "/")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	summary, err := s.runs.summary(r.URL.Query().Get("namespace"), name)
+	if err != nil {
+		code := http.StatusBadRequest
+		if errors.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
@@ -0,0 +1,319 @@
+package trigger
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/pkg/cloudevents"
+	"github.com/knative-sample/tekton-serving/pkg/approval"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const pullRequestURL = "https://github.com/knative-sample/app/pull/7"
+
+// approvalRequest is a pending approval of a deploy of the pull request
+func approvalRequest(name string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      map[string]string{approval.Label: "app"},
+			Annotations: map[string]string{approval.StateKey: approval.StatePending},
+		},
+		Data: map[string]string{"service": "app", "pullRequest": pullRequestURL},
+	}
+}
+
+// commentEvent is the issue_comment event of a comment of user on the pull request of author
+func commentEvent(t *testing.T, body, user, association, author string) cloudevents.Event {
+	payload := map[string]interface{}{
+		"action": "created",
+		"issue": map[string]interface{}{
+			"html_url": pullRequestURL,
+			"user":     map[string]interface{}{"login": author},
+		},
+		"comment": map[string]interface{}{
+			"body":               body,
+			"user":               map[string]interface{}{"login": user},
+			"author_association": association,
+		},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload error:%s", err)
+	}
+	e := cloudevents.New()
+	e.SetID("1")
+	e.SetType("dev.knative.source.github.issue_comment")
+	e.Data = data
+	return e
+}
+
+func TestParseApprovalComment(t *testing.T) {
+	cases := []struct {
+		body       string
+		wantState  string
+		wantReason string
+	}{
+		{body: "/approve", wantState: approval.StateApproved},
+		{body: "  /approve looks good\nthanks", wantState: approval.StateApproved, wantReason: "looks good"},
+		{body: "/reject the canary fails", wantState: approval.StateRejected, wantReason: "the canary fails"},
+		{body: "/approved"},
+		{body: "please /approve"},
+		{body: ""},
+	}
+	for _, c := range cases {
+		state, reason := parseApprovalComment(c.body)
+		if state != c.wantState || reason != c.wantReason {
+			t.Errorf("parseApprovalComment(%q) = %q, %q, want %q, %q", c.body, state, reason, c.wantState, c.wantReason)
+		}
+	}
+}
+
+func TestCanApprove(t *testing.T) {
+	cases := []struct {
+		name        string
+		approvers   []string
+		user        string
+		association string
+		want        bool
+	}{
+		{name: "member", user: "bob", association: "MEMBER", want: true},
+		{name: "contributor", user: "bob", association: "CONTRIBUTOR"},
+		{name: "listed approver", approvers: []string{"Bob"}, user: "bob", association: "NONE", want: true},
+		{name: "owner not listed", approvers: []string{"carol"}, user: "bob", association: "OWNER"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dp := &Trigger{Approvers: c.approvers}
+			if got := dp.canApprove(c.user, c.association); got != c.want {
+				t.Errorf("canApprove(%s, %s) = %v, want %v", c.user, c.association, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIssueCommentApproval(t *testing.T) {
+	cases := []struct {
+		name              string
+		body              string
+		user              string
+		association       string
+		allowSelfApproval bool
+		wantState         string
+		wantSkipped       string
+	}{{
+		name:        "approved by a member",
+		body:        "/approve",
+		user:        "bob",
+		association: "MEMBER",
+		wantState:   approval.StateApproved,
+	}, {
+		name:        "rejected with a reason",
+		body:        "/reject not now",
+		user:        "bob",
+		association: "MEMBER",
+		wantState:   approval.StateRejected,
+	}, {
+		name:        "not an approver",
+		body:        "/approve",
+		user:        "bob",
+		association: "CONTRIBUTOR",
+		wantSkipped: "bob is not allowed to decide the deploys",
+	}, {
+		name:        "the author can't approve",
+		body:        "/approve",
+		user:        "Alice",
+		association: "OWNER",
+		wantSkipped: "Alice is the author of the pull request and can't approve its deploys",
+	}, {
+		name:        "the author can reject",
+		body:        "/reject",
+		user:        "alice",
+		association: "OWNER",
+		wantState:   approval.StateRejected,
+	}, {
+		name:              "self approval allowed",
+		body:              "/approve",
+		user:              "alice",
+		association:       "OWNER",
+		allowSelfApproval: true,
+		wantState:         approval.StateApproved,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(approvalRequest("app-approval-1"))
+			dp := &Trigger{AllowSelfApproval: c.allowSelfApproval, watcher: &runWatcher{kube: client}}
+
+			reply, err := dp.issueCommentEvent(commentEvent(t, c.body, c.user, c.association, "alice"))
+			if err != nil {
+				t.Fatalf("issueCommentEvent error:%s", err)
+			}
+			cm, err := client.CoreV1().ConfigMaps("default").Get("app-approval-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get approval error:%s", err)
+			}
+			d := approval.DecisionOf(cm)
+			if c.wantSkipped != "" {
+				if reply.Type != events.TypeTriggerSkipped || reply.Reason != c.wantSkipped {
+					t.Errorf("reply = %+v, want skipped: %s", reply, c.wantSkipped)
+				}
+				if d.State != approval.StatePending {
+					t.Errorf("approval is %s, want it pending", d.State)
+				}
+				return
+			}
+			if reply.Type != events.TypeApprovalDecided || len(reply.Approvals) != 1 || reply.Approvals[0] != "default/app-approval-1" {
+				t.Errorf("reply = %+v, want default/app-approval-1 decided", reply)
+			}
+			if d.State != c.wantState || d.By != c.user || d.Source != approval.SourceComment {
+				t.Errorf("decision = %+v, want %s by %s", d, c.wantState, c.user)
+			}
+		})
+	}
+}
+
+func TestNewAdminServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("write %s error:%s", name, err)
+		}
+		return path
+	}
+
+	cases := []struct {
+		name    string
+		tokens  string
+		wantErr bool
+	}{
+		{name: "no tokens file", wantErr: true},
+		{name: "missing tokens file", tokens: filepath.Join(dir, "missing"), wantErr: true},
+		{name: "no token", tokens: write("empty", "# nobody\n"), wantErr: true},
+		{name: "not user=token", tokens: write("invalid", "bob\n"), wantErr: true},
+		{name: "tokens", tokens: write("tokens", "bob=s3cr3t\n")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dp := &Trigger{AdminAddress: ":8081", AdminTokens: c.tokens, watcher: &runWatcher{kube: fake.NewSimpleClientset()}}
+			s, err := dp.newAdminServer()
+			if c.wantErr != (err != nil) {
+				t.Fatalf("newAdminServer error:%v, want an error: %v", err, c.wantErr)
+			}
+			if err == nil && s.tokens["s3cr3t"] != "bob" {
+				t.Errorf("tokens = %v, want bob's", s.tokens)
+			}
+		})
+	}
+}
+
+func TestAdminApprovals(t *testing.T) {
+	client := fake.NewSimpleClientset(approvalRequest("app-approval-1"), approvalRequest("app-approval-2"))
+	s := &adminServer{tokens: map[string]string{"s3cr3t": "bob"}, client: client}
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	do := func(method, path, token string, form url.Values) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("new request error:%s", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error:%s", method, path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{{
+		name:     "no token",
+		method:   http.MethodGet,
+		path:     "/approvals",
+		wantCode: http.StatusUnauthorized,
+	}, {
+		name:     "unknown token",
+		method:   http.MethodGet,
+		path:     "/approvals",
+		token:    "guess",
+		wantCode: http.StatusUnauthorized,
+	}, {
+		name:     "list the pending approvals",
+		method:   http.MethodGet,
+		path:     "/approvals",
+		token:    "s3cr3t",
+		wantCode: http.StatusOK,
+		wantBody: `"name":"app-approval-2"`,
+	}, {
+		name:     "invalid decision",
+		method:   http.MethodPost,
+		path:     "/approvals/default/app-approval-1",
+		token:    "s3cr3t",
+		form:     url.Values{"decision": {"maybe"}},
+		wantCode: http.StatusBadRequest,
+	}, {
+		name:     "approve",
+		method:   http.MethodPost,
+		path:     "/approvals/default/app-approval-1",
+		token:    "s3cr3t",
+		form:     url.Values{"decision": {"approved"}, "reason": {"checked the canary"}},
+		wantCode: http.StatusOK,
+		wantBody: "approval default/app-approval-1 approved by bob",
+	}, {
+		name:     "decided twice",
+		method:   http.MethodPost,
+		path:     "/approvals/default/app-approval-1",
+		token:    "s3cr3t",
+		form:     url.Values{"decision": {"rejected"}},
+		wantCode: http.StatusConflict,
+	}, {
+		name:     "unknown approval",
+		method:   http.MethodPost,
+		path:     "/approvals/default/app-approval-9",
+		token:    "s3cr3t",
+		form:     url.Values{"decision": {"approved"}},
+		wantCode: http.StatusNotFound,
+	}}
+	for _, c := range cases {
+		code, body := do(c.method, c.path, c.token, c.form)
+		if code != c.wantCode || !strings.Contains(body, c.wantBody) {
+			t.Errorf("%s: %s %s = %d %q, want %d %q", c.name, c.method, c.path, code, body, c.wantCode, c.wantBody)
+		}
+	}
+
+	cm, err := client.CoreV1().ConfigMaps("default").Get("app-approval-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get approval error:%s", err)
+	}
+	if d := approval.DecisionOf(cm); d.State != approval.StateApproved || d.By != "bob" || d.Source != approval.SourceHTTP || d.Reason != "checked the canary" {
+		t.Errorf("decision = %+v, want approved by bob over http", d)
+	}
+}
@@ -0,0 +1,535 @@
+package trigger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cloudevents/sdk-go/pkg/cloudevents"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	gh "gopkg.in/go-playground/webhooks.v5/github"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/apis"
+)
+
+// The ChatOps commands of the comments, /retest and /cancel only run on pull requests
+const (
+	CommandRetest   = "retest"
+	CommandDeploy   = "deploy"
+	CommandRollback = "rollback"
+	CommandCancel   = "cancel"
+)
+
+// The acknowledgements of a command
+const (
+	AcknowledgeComment  = "comment"
+	AcknowledgeReaction = "reaction"
+)
+
+// CommandKey is the label of the PipelineRuns a ChatOps command created, with the command
+const CommandKey = provenance.GroupName + "/command"
+
+// ChatOpsConfig is the --chatops-config file
+type ChatOpsConfig struct {
+	// Acknowledge is how a command is acknowledged, a comment or a reaction to the
+	// command. comment by default.
+	Acknowledge string `json:"acknowledge,omitempty"`
+	// TokenEnv is the ENV of the GitHub token acknowledging the commands and reading
+	// the pull requests, GITHUB_TOKEN by default
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// Permissions are who may run the commands, by command or by "command environment"
+	// such as "deploy prod". The owners, members and collaborators of the repository
+	// may run the commands without a permission.
+	Permissions map[string]Permission `json:"permissions,omitempty"`
+	// Environments are the PipelineRun templates of /deploy and /rollback
+	Environments map[string]ChatOpsEnvironment `json:"environments,omitempty"`
+
+	// templates are the parsed templates by "command environment"
+	templates map[string]*template.Template
+}
+
+// Permission allows the users, the author associations and the permissions on the
+// repository it lists
+type Permission struct {
+	Users        []string `json:"users,omitempty"`
+	Associations []string `json:"associations,omitempty"`
+	// Permissions are permissions on the repository such as admin or write, the one of
+	// the user is looked up through the GitHub API
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// ChatOpsEnvironment are the files of the PipelineRun templates of an environment, they
+// are executed on CommandArgs
+type ChatOpsEnvironment struct {
+	Deploy   string `json:"deploy,omitempty"`
+	Rollback string `json:"rollback,omitempty"`
+}
+
+// CommandArgs are the args of the /deploy and /rollback templates. Issue and Number are
+// the issue or the pull request commented, PullRequest is empty on an issue.
+type CommandArgs struct {
+	Args
+	Command     string
+	Environment string
+	Issue       string
+	PullRequest string
+	Number      int64
+	Repo        string
+	User        string
+}
+
+// command is a parsed ChatOps comment
+type command struct {
+	Name        string
+	Environment string
+}
+
+func (c *command) String() string {
+	if c.Environment == "" {
+		return c.Name
+	}
+	return c.Name + " " + c.Environment
+}
+
+// LoadChatOpsConfig reads a ChatOpsConfig yaml file and parses its templates
+func LoadChatOpsConfig(path string) (*ChatOpsConfig, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &ChatOpsConfig{}
+	if err := yaml.Unmarshal(bts, c); err != nil {
+		return nil, fmt.Errorf("parse chatops config %s error:%s", path, err)
+	}
+	if err := c.compile(); err != nil {
+		return nil, fmt.Errorf("chatops config %s: %s", path, err)
+	}
+	return c, nil
+}
+
+func (c *ChatOpsConfig) compile() error {
+	switch c.Acknowledge {
+	case "":
+		c.Acknowledge = AcknowledgeComment
+	case AcknowledgeComment, AcknowledgeReaction:
+	default:
+		return fmt.Errorf("unknown acknowledge %q", c.Acknowledge)
+	}
+	if c.TokenEnv == "" {
+		c.TokenEnv = "GITHUB_TOKEN"
+	}
+	for key := range c.Permissions {
+		fields := strings.Fields(key)
+		if len(fields) == 0 || len(fields) > 2 || !knownCommand(fields[0]) {
+			return fmt.Errorf("unknown command %q in permissions", key)
+		}
+	}
+	c.templates = map[string]*template.Template{}
+	for env, e := range c.Environments {
+		for name, file := range map[string]string{CommandDeploy: e.Deploy, CommandRollback: e.Rollback} {
+			if file == "" {
+				continue
+			}
+			tmpl, err := template.ParseFiles(file)
+			if err != nil {
+				return fmt.Errorf("%s template of %s: %s", name, env, err)
+			}
+			c.templates[name+" "+env] = tmpl
+		}
+	}
+	return nil
+}
+
+func knownCommand(name string) bool {
+	switch name {
+	case CommandRetest, CommandDeploy, CommandRollback, CommandCancel:
+		return true
+	}
+	return false
+}
+
+// allowed checks the permission of the environment of the command, then of the command.
+// permission looks up the permission of the user on the repository, it is only called
+// when the users and the associations don't allow the command.
+func (c *ChatOpsConfig) allowed(cmd *command, user, association string, permission func() (string, error)) (bool, error) {
+	p, ok := c.Permissions[cmd.String()]
+	if !ok {
+		p, ok = c.Permissions[cmd.Name]
+	}
+	if !ok {
+		return approverAssociations[association], nil
+	}
+	for _, u := range p.Users {
+		if strings.EqualFold(u, user) {
+			return true, nil
+		}
+	}
+	for _, a := range p.Associations {
+		if strings.EqualFold(a, association) {
+			return true, nil
+		}
+	}
+	if len(p.Permissions) == 0 {
+		return false, nil
+	}
+	level, err := permission()
+	if err != nil {
+		return false, err
+	}
+	for _, l := range p.Permissions {
+		if strings.EqualFold(l, level) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseCommand returns the command of the first line of the comment, nil when it is not
+// a ChatOps command
+func parseCommand(body string) *command {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(body), "\n", 2)[0])
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return nil
+	}
+	name := strings.TrimPrefix(fields[0], "/")
+	if !knownCommand(name) {
+		return nil
+	}
+	cmd := &command{Name: name}
+	if len(fields) > 1 && (name == CommandDeploy || name == CommandRollback) {
+		cmd.Environment = fields[1]
+	}
+	return cmd
+}
+
+// chatOpsEvent runs the command of a pull request or an issue comment and acknowledges
+// it. A command which fails is acknowledged and skipped rather than redelivered. /retest
+// and /cancel work on the builds of a pull request, they are skipped on an issue.
+func (dp *Trigger) chatOpsEvent(e cloudevents.Event, payload *gh.IssueCommentPayload) (*Reply, error) {
+	cmd := parseCommand(payload.Comment.Body)
+	if cmd == nil {
+		return skipped(e, RuleChatOps, "comment is not /approve, /reject or a command"), nil
+	}
+	if dp.chatops == nil {
+		return skipped(e, RuleChatOps, "chatops is not configured"), nil
+	}
+	if !isPullRequest(payload) && (cmd.Name == CommandRetest || cmd.Name == CommandCancel) {
+		return skipped(e, RuleChatOps, fmt.Sprintf("%s is an issue, /%s only runs on pull requests", payload.Issue.HTMLURL, cmd)), nil
+	}
+	user, repo := payload.Comment.User.Login, payload.Repository.FullName
+	allowed, err := dp.chatops.allowed(cmd, user, payload.Comment.AuthorAssociation, func() (string, error) {
+		return dp.github.permission(repo, user)
+	})
+	if err != nil {
+		reason := fmt.Sprintf("check the permission of %s to /%s: %s", user, cmd, err)
+		glog.Errorf("%s on %s", reason, payload.Issue.HTMLURL)
+		dp.acknowledge(payload, false, fmt.Sprintf("your permission to /%s can't be checked", cmd))
+		return skipped(e, RuleChatOps, reason), nil
+	}
+	if !allowed {
+		reason := fmt.Sprintf("%s is not allowed to /%s", user, cmd)
+		glog.Warningf("%s on %s", reason, payload.Issue.HTMLURL)
+		dp.acknowledge(payload, false, fmt.Sprintf("you are not allowed to /%s", cmd))
+		return skipped(e, RuleChatOps, reason), nil
+	}
+
+	var reply *Reply
+	switch cmd.Name {
+	case CommandRetest:
+		reply, err = dp.retest(e, payload)
+	case CommandCancel:
+		reply, err = dp.cancel(e, payload)
+	default:
+		reply, err = dp.deployCommand(e, payload, cmd)
+	}
+	if err != nil {
+		reason := fmt.Sprintf("/%s failed: %s", cmd, err)
+		glog.Errorf("/%s on %s error:%s", cmd, payload.Issue.HTMLURL, err)
+		dp.acknowledge(payload, false, reason)
+		return skipped(e, RuleChatOps, reason), nil
+	}
+	reply.Command = cmd.String()
+	if reply.Name != "" {
+		dp.acknowledge(payload, true, fmt.Sprintf("/%s created PipelineRun %s/%s", cmd, reply.Namespace, reply.Name))
+	} else {
+		dp.acknowledge(payload, true, fmt.Sprintf("/%s cancelled PipelineRuns %s", cmd, strings.Join(reply.Cancelled, ", ")))
+	}
+	dp.events.Emit(reply.Type, reply.Subject, reply)
+	return reply, nil
+}
+
+// isPullRequest tells a comment of a pull request from a comment of an issue
+func isPullRequest(payload *gh.IssueCommentPayload) bool {
+	return strings.Contains(payload.Issue.HTMLURL, "/pull/")
+}
+
+// retestName is the name of a retest of the build named name
+func retestName(name, sequence string) string {
+	if i := strings.LastIndex(name, "-retest-"); i > 0 {
+		name = name[:i]
+	}
+	suffix := "-retest-" + sequence
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+	return name + suffix
+}
+
+// retest rebuilds the pull request. The last build of a merged pull request is recreated,
+// an open one is built at its head commit from the --trigger-config template.
+func (dp *Trigger) retest(e cloudevents.Event, payload *gh.IssueCommentPayload) (*Reply, error) {
+	pull, err := dp.github.pullRequest(payload.Repository.FullName, payload.Issue.Number)
+	if err != nil {
+		return nil, fmt.Errorf("get pull request: %s", err)
+	}
+	if pull.Merged {
+		var last *v1alpha1.PipelineRun
+		for _, pr := range dp.watcher.runsOf(payload.Issue.HTMLURL) {
+			if c := pr.Labels[CommandKey]; c == "" || c == CommandRetest {
+				last = pr
+			}
+		}
+		if last != nil {
+			return dp.recreate(e, last)
+		}
+	}
+
+	commit, branch := pull.commit()
+	if len(commit) < 8 {
+		return nil, fmt.Errorf("pull request has no commit")
+	}
+	now := time.Now()
+	u, err := dp.buildRun(&Args{
+		Commitid:      commit,
+		ShortCommitid: commit[:8],
+		TimeString:    now.Format("20060102150405"),
+		Branch:        branch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("build template: %s", err)
+	}
+	sequence := fmt.Sprintf("%v", now.Unix())
+	if u.Name == "" && u.GenerateName != "" {
+		u.Name = generateRunName(u.GenerateName)
+	} else {
+		u.Name = retestName(u.Name, sequence)
+	}
+	if u.Labels == nil {
+		u.Labels = map[string]string{}
+	}
+	u.Labels[CommandKey] = CommandRetest
+	setImageTag(u, sequence)
+	stamp(u, provenance.Provenance{
+		Commit:        commit,
+		Repo:          payload.Repository.HTMLURL,
+		PullRequest:   payload.Issue.HTMLURL,
+		PipelineRun:   u.Name,
+		Delivery:      e.ID(),
+		Author:        payload.Issue.User.Login,
+		BuildSequence: sequence,
+	})
+	glog.Infof("retest %s at %s as PipelineRun %s/%s", payload.Issue.HTMLURL, commit, u.Namespace, u.Name)
+	return dp.createRun(e, u)
+}
+
+// recreate recreates the last build of a merged pull request with a new build sequence
+func (dp *Trigger) recreate(e cloudevents.Event, last *v1alpha1.PipelineRun) (*Reply, error) {
+	sequence := fmt.Sprintf("%v", time.Now().Unix())
+	run := copyRun(last, retestName(retryOf(last), sequence))
+	delete(run.Labels, RetryOfKey)
+	delete(run.Labels, RetryAttemptKey)
+	run.Labels[CommandKey] = CommandRetest
+	setImageTag(run, sequence)
+	prov := provenance.FromAnnotations(last.Annotations)
+	prov.PipelineRun, prov.Delivery, prov.BuildSequence = run.Name, e.ID(), sequence
+	stamp(run, prov)
+	glog.Infof("retest PipelineRun %s/%s as %s", last.Namespace, last.Name, run.Name)
+	return dp.createRun(e, run)
+}
+
+// cancel cancels the PipelineRuns of the pull request which are not done
+func (dp *Trigger) cancel(e cloudevents.Event, payload *gh.IssueCommentPayload) (*Reply, error) {
+	reply := &Reply{Type: events.TypePipelineRunCancelled, Subject: payload.Issue.HTMLURL, Event: e.ID(), Rule: RuleChatOps}
+	patch := []byte(fmt.Sprintf(`{"spec":{"status":%q}}`, v1alpha1.PipelineRunSpecStatusCancelled))
+	for _, pr := range dp.watcher.runsOf(payload.Issue.HTMLURL) {
+		if cond := pr.Status.GetCondition(apis.ConditionSucceeded); pr.IsCancelled() || (cond != nil && !cond.IsUnknown()) {
+			continue
+		}
+		if _, err := dp.watcher.tekton.TektonV1alpha1().PipelineRuns(pr.Namespace).Patch(pr.Name, types.MergePatchType, patch); err != nil {
+			glog.Errorf("cancel PipelineRun %s/%s error:%s", pr.Namespace, pr.Name, err)
+			return nil, err
+		}
+		glog.Infof("cancelled PipelineRun %s/%s of %s", pr.Namespace, pr.Name, payload.Issue.HTMLURL)
+		reply.Cancelled = append(reply.Cancelled, pr.Namespace+"/"+pr.Name)
+	}
+	if len(reply.Cancelled) == 0 {
+		return nil, fmt.Errorf("no running PipelineRun of %s", payload.Issue.HTMLURL)
+	}
+	return reply, nil
+}
+
+// deployCommand creates the /deploy or /rollback PipelineRun of the environment for the
+// commit of the pull request, or the head of the default branch on an issue
+func (dp *Trigger) deployCommand(e cloudevents.Event, payload *gh.IssueCommentPayload, cmd *command) (*Reply, error) {
+	if cmd.Environment == "" {
+		return nil, fmt.Errorf("usage: /%s <environment>", cmd.Name)
+	}
+	tmpl, ok := dp.chatops.templates[cmd.String()]
+	if !ok {
+		return nil, fmt.Errorf("no %s template for environment %s", cmd.Name, cmd.Environment)
+	}
+	var commit, branch, pullRequest string
+	if isPullRequest(payload) {
+		pull, err := dp.github.pullRequest(payload.Repository.FullName, payload.Issue.Number)
+		if err != nil {
+			return nil, fmt.Errorf("get pull request: %s", err)
+		}
+		commit, branch = pull.commit()
+		pullRequest = payload.Issue.HTMLURL
+	} else {
+		var err error
+		if commit, branch, err = dp.github.defaultBranch(payload.Repository.FullName); err != nil {
+			return nil, fmt.Errorf("get default branch: %s", err)
+		}
+	}
+	if len(commit) < 8 {
+		return nil, fmt.Errorf("%s has no commit", payload.Issue.HTMLURL)
+	}
+	now := time.Now()
+	args := &CommandArgs{
+		Args: Args{
+			Commitid:      commit,
+			ShortCommitid: commit[:8],
+			TimeString:    now.Format("20060102150405"),
+			Branch:        branch,
+		},
+		Command:     cmd.Name,
+		Environment: cmd.Environment,
+		Issue:       payload.Issue.HTMLURL,
+		PullRequest: pullRequest,
+		Number:      payload.Issue.Number,
+		Repo:        payload.Repository.HTMLURL,
+		User:        payload.Comment.User.Login,
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, args); err != nil {
+		return nil, fmt.Errorf("execute %s template: %s", cmd, err)
+	}
+	jsonbts, err := yaml.YAMLToJSON(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template: %s", cmd, err)
+	}
+	u := &v1alpha1.PipelineRun{}
+	if err := yaml.Unmarshal(jsonbts, u); err != nil {
+		return nil, fmt.Errorf("parse %s template: %s", cmd, err)
+	}
+
+	sequence := fmt.Sprintf("%v", now.Unix())
+	if u.Name == "" && u.GenerateName != "" {
+		u.Name = generateRunName(u.GenerateName)
+	}
+	if u.Labels == nil {
+		u.Labels = map[string]string{}
+	}
+	u.Labels[CommandKey] = cmd.Name
+	stamp(u, provenance.Provenance{
+		Commit:        commit,
+		Repo:          payload.Repository.HTMLURL,
+		PullRequest:   pullRequest,
+		PipelineRun:   u.Name,
+		Delivery:      e.ID(),
+		Author:        payload.Comment.User.Login,
+		BuildSequence: sequence,
+	})
+	return dp.createRun(e, u)
+}
+
+// createRun creates the PipelineRun of a command and notifies it
+func (dp *Trigger) createRun(e cloudevents.Event, u *v1alpha1.PipelineRun) (*Reply, error) {
+	if u.Namespace == "" {
+		u.Namespace = "default"
+	}
+	created, err := dp.watcher.tekton.TektonV1alpha1().PipelineRuns(u.Namespace).Create(u)
+	if err != nil {
+		glog.Errorf("create PipelineRun %s/%s error:%s", u.Namespace, u.Name, err)
+		return nil, err
+	}
+	dp.notifier.Notify(runMessage(notify.KindBuildStarted, created))
+	prov := provenance.FromAnnotations(created.Annotations)
+	return &Reply{
+		Type:       events.TypePipelineRunCreated,
+		Subject:    created.Namespace + "/" + created.Name,
+		Event:      e.ID(),
+		Rule:       RuleChatOps,
+		Name:       created.Name,
+		Namespace:  created.Namespace,
+		Provenance: &prov,
+	}, nil
+}
+
+// acknowledge answers the command with a comment mentioning the commenter, or with a
+// reaction to the command. Failing to acknowledge is only logged.
+func (dp *Trigger) acknowledge(payload *gh.IssueCommentPayload, ok bool, text string) {
+	repo := payload.Repository.FullName
+	var err error
+	if dp.chatops.Acknowledge == AcknowledgeReaction {
+		content := reactionOK
+		if !ok {
+			content = reactionFailed
+		}
+		err = dp.github.react(repo, payload.Comment.ID, content)
+	} else {
+		err = dp.github.comment(repo, payload.Issue.Number, "@"+payload.Comment.User.Login+" "+text)
+	}
+	if err != nil {
+		glog.Errorf("acknowledge command on %s error:%s", payload.Comment.HTMLURL, err)
+	}
+}
+
+// runsOf are the PipelineRuns of the pull request, the oldest first
+func (w *runWatcher) runsOf(pullRequest string) []*v1alpha1.PipelineRun {
+	all, err := w.runs.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("list PipelineRuns error:%s", err)
+		return nil
+	}
+	runs := []*v1alpha1.PipelineRun{}
+	for _, pr := range all {
+		if pr.Annotations[provenance.PullRequestKey] == pullRequest {
+			runs = append(runs, pr)
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].CreationTimestamp.Before(&runs[j].CreationTimestamp)
+	})
+	return runs
+}
+
+// newChatOps loads the --chatops-config and the GitHub client acknowledging the commands
+func (dp *Trigger) newChatOps() error {
+	if dp.ChatOpsConfig == "" {
+		return nil
+	}
+	c, err := LoadChatOpsConfig(dp.ChatOpsConfig)
+	if err != nil {
+		return err
+	}
+	token := os.Getenv(c.TokenEnv)
+	if token == "" {
+		glog.Warningf("%s ENV is empty, the GitHub API is called anonymously", c.TokenEnv)
+	}
+	dp.chatops, dp.github = c, newGitHubClient(dp.GitHubAPI, token)
+	return nil
+}
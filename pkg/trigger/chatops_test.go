@@ -0,0 +1,553 @@
+package trigger
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/pkg/cloudevents"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektonfake "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+const (
+	repoURL  = "https://github.com/knative-sample/app"
+	issueURL = "https://github.com/knative-sample/app/issues/9"
+)
+
+// buildTemplate is the --trigger-config template of the builds
+const buildTemplate = `apiVersion: tekton.dev/v1alpha1
+kind: PipelineRun
+metadata:
+  name: app-build
+spec:
+  params:
+  - name: imageTag
+    value: latest
+  - name: commit
+    value: "{{.Commitid}}"
+  - name: branch
+    value: "{{.Branch}}"
+`
+
+// deployTemplate is the template of the /deploy and /rollback commands
+const deployTemplate = `apiVersion: tekton.dev/v1alpha1
+kind: PipelineRun
+metadata:
+  generateName: app-{{.Command}}-{{.Environment}}-
+  namespace: {{.Environment}}
+spec:
+  params:
+  - name: commit
+    value: "{{.Commitid}}"
+  - name: branch
+    value: "{{.Branch}}"
+  - name: issue
+    value: "{{.Issue}}"
+  - name: pullRequest
+    value: "{{.PullRequest}}"
+`
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		body string
+		want string
+	}{
+		{body: "/retest", want: "retest"},
+		{body: "  /deploy staging\nplease", want: "deploy staging"},
+		{body: "/rollback prod now", want: "rollback prod"},
+		{body: "/deploy", want: "deploy"},
+		{body: "/cancel all", want: "cancel"},
+		{body: "/approve"},
+		{body: "/test"},
+		{body: "please /retest"},
+		{body: "retest"},
+		{body: "\n/retest", want: "retest"},
+		{body: "thanks\n/retest"},
+		{body: ""},
+	}
+	for _, c := range cases {
+		cmd := parseCommand(c.body)
+		got := ""
+		if cmd != nil {
+			got = cmd.String()
+		}
+		if got != c.want {
+			t.Errorf("parseCommand(%q) = %q, want %q", c.body, got, c.want)
+		}
+	}
+}
+
+// chatOpsConfig writes the templates and the config of the commands in dir and loads it
+func chatOpsConfig(t *testing.T, dir, acknowledge string) *ChatOpsConfig {
+	template := filepath.Join(dir, "deploy.yaml")
+	if err := ioutil.WriteFile(template, []byte(deployTemplate), 0644); err != nil {
+		t.Fatalf("write template error:%s", err)
+	}
+	config := `acknowledge: ` + acknowledge + `
+permissions:
+  rollback prod:
+    users: [carol]
+    permissions: [admin]
+  cancel:
+    associations: [contributor, member]
+environments:
+  staging:
+    deploy: ` + template + `
+  prod:
+    deploy: ` + template + `
+    rollback: ` + template + `
+`
+	path := filepath.Join(dir, "chatops.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("write chatops config error:%s", err)
+	}
+	c, err := LoadChatOpsConfig(path)
+	if err != nil {
+		t.Fatalf("LoadChatOpsConfig error:%s", err)
+	}
+	return c
+}
+
+func TestLoadChatOpsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chatops")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := chatOpsConfig(t, dir, "")
+	if c.Acknowledge != AcknowledgeComment || c.TokenEnv != "GITHUB_TOKEN" {
+		t.Errorf("defaults = %s, %s", c.Acknowledge, c.TokenEnv)
+	}
+	for _, key := range []string{"deploy staging", "deploy prod", "rollback prod"} {
+		if c.templates[key] == nil {
+			t.Errorf("no template of %s", key)
+		}
+	}
+	if c.templates["rollback staging"] != nil {
+		t.Errorf("template of rollback staging, want none")
+	}
+
+	for config, wantErr := range map[string]string{
+		"acknowledge: emoji\n":                             `unknown acknowledge "emoji"`,
+		"permissions:\n  merge: {users: [bob]}\n":          `unknown command "merge" in permissions`,
+		"permissions:\n  deploy prod eu: {users: [bob]}\n": `unknown command "deploy prod eu" in permissions`,
+		"environments:\n  prod: {deploy: /missing.yaml}\n": "deploy template of prod",
+	} {
+		path := filepath.Join(dir, "invalid.yaml")
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("write chatops config error:%s", err)
+		}
+		if _, err := LoadChatOpsConfig(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("LoadChatOpsConfig(%q) error:%v, want %q", config, err, wantErr)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chatops")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	c := chatOpsConfig(t, dir, "")
+
+	cases := []struct {
+		command     string
+		user        string
+		association string
+		permission  string
+		lookupErr   error
+		want        bool
+		wantLookup  bool
+		wantErr     bool
+	}{
+		{command: "/retest", user: "bob", association: "MEMBER", want: true},
+		{command: "/retest", user: "bob", association: "CONTRIBUTOR"},
+		{command: "/deploy prod", user: "bob", association: "COLLABORATOR", want: true},
+		{command: "/cancel", user: "bob", association: "CONTRIBUTOR", want: true},
+		{command: "/cancel", user: "bob", association: "OWNER"},
+		{command: "/rollback prod", user: "Carol", association: "NONE", want: true},
+		{command: "/rollback prod", user: "bob", association: "OWNER", permission: "admin", want: true, wantLookup: true},
+		{command: "/rollback prod", user: "bob", association: "MEMBER", permission: "write", wantLookup: true},
+		{command: "/rollback prod", user: "bob", association: "MEMBER", lookupErr: errors.New("status 403"), wantLookup: true, wantErr: true},
+		// the permission of rollback prod doesn't apply to the other environments
+		{command: "/rollback staging", user: "bob", association: "MEMBER", want: true},
+	}
+	for _, cs := range cases {
+		lookups := 0
+		got, err := c.allowed(parseCommand(cs.command), cs.user, cs.association, func() (string, error) {
+			lookups++
+			return cs.permission, cs.lookupErr
+		})
+		if got != cs.want || (err != nil) != cs.wantErr || (lookups > 0) != cs.wantLookup {
+			t.Errorf("%s by %s %s: allowed = %v, %v after %d lookups, want %v, an error: %v, a lookup: %v",
+				cs.command, cs.user, cs.association, got, err, lookups, cs.want, cs.wantErr, cs.wantLookup)
+		}
+	}
+}
+
+// commandEvent is the issue_comment event of a comment of user on the issue or the pull
+// request number
+func commandEvent(t *testing.T, body, user, association, url string, number int64) cloudevents.Event {
+	payload := map[string]interface{}{
+		"action": "created",
+		"issue": map[string]interface{}{
+			"html_url": url,
+			"number":   number,
+			"user":     map[string]interface{}{"login": "alice"},
+		},
+		"comment": map[string]interface{}{
+			"id":                 42,
+			"body":               body,
+			"user":               map[string]interface{}{"login": user},
+			"author_association": association,
+		},
+		"repository": map[string]interface{}{
+			"full_name": "knative-sample/app",
+			"html_url":  repoURL,
+		},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload error:%s", err)
+	}
+	e := cloudevents.New()
+	e.SetID("1")
+	e.SetType("dev.knative.source.github.issue_comment")
+	e.Data = data
+	return e
+}
+
+// mergeBuild is the build of the merged pull request, running when running is set
+func mergeBuild(name string, running bool) *v1alpha1.PipelineRun {
+	pr := pipelineRun(name)
+	delete(pr.Annotations, FailureKey)
+	pr.Annotations[provenance.PullRequestKey] = pullRequestURL
+	pr.Annotations[provenance.CommitKey] = "cccc3333dddd"
+	pr.Spec.Params = []v1alpha1.Param{{Name: "imageTag", Value: v1alpha1.ArrayOrString{Type: v1alpha1.ParamTypeString, StringVal: "1583500000"}}}
+	status := corev1.ConditionTrue
+	if running {
+		status = corev1.ConditionUnknown
+	}
+	pr.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: status})
+	return pr
+}
+
+// param is the value of the param of the PipelineRun
+func param(pr *v1alpha1.PipelineRun, name string) string {
+	for _, p := range pr.Spec.Params {
+		if p.Name == name {
+			return p.Value.StringVal
+		}
+	}
+	return ""
+}
+
+func TestChatOpsCommands(t *testing.T) {
+	const pulls = "GET /repos/knative-sample/app/pulls/7"
+	openPull := `{"merged":false,"head":{"ref":"feature","sha":"aaaa1111bbbb"},"base":{"ref":"master"}}`
+	mergedPull := `{"merged":true,"merge_commit_sha":"cccc3333dddd","head":{"ref":"feature","sha":"aaaa1111bbbb"},"base":{"ref":"master"}}`
+
+	cases := []struct {
+		name        string
+		body        string
+		user        string
+		association string
+		url         string
+		acknowledge string
+		runs        []*v1alpha1.PipelineRun
+		responses   map[string]string
+		// wantRun checks the PipelineRun created, wantSkipped the reason of a command
+		// which isn't run
+		wantRun       func(t *testing.T, pr *v1alpha1.PipelineRun)
+		wantCancelled []string
+		wantSkipped   string
+		// wantRequests are the calls of the GitHub API
+		wantRequests []string
+	}{{
+		name: "retest an open pull request at its head",
+		body: "/retest",
+		// a build of the pull request before it was reopened is not recreated
+		runs: []*v1alpha1.PipelineRun{mergeBuild("app-build-old", false)},
+		responses: map[string]string{
+			pulls: openPull,
+		},
+		wantRun: func(t *testing.T, pr *v1alpha1.PipelineRun) {
+			if !strings.HasPrefix(pr.Name, "app-build-retest-") || pr.Namespace != "default" {
+				t.Errorf("retest = %s/%s, want app-build-retest-<sequence>", pr.Namespace, pr.Name)
+			}
+			prov := provenance.FromAnnotations(pr.Annotations)
+			if param(pr, "commit") != "aaaa1111bbbb" || param(pr, "branch") != "feature" || prov.Commit != "aaaa1111bbbb" {
+				t.Errorf("retest builds %s on %s, provenance %s, want the head of feature", param(pr, "commit"), param(pr, "branch"), prov.Commit)
+			}
+			if prov.PullRequest != pullRequestURL || prov.Author != "alice" || param(pr, "imageTag") != prov.BuildSequence {
+				t.Errorf("provenance = %+v, image tag %s", prov, param(pr, "imageTag"))
+			}
+			if pr.Labels[CommandKey] != CommandRetest || pr.Labels[provenance.PipelineRunKey] != pr.Name {
+				t.Errorf("labels = %v", pr.Labels)
+			}
+		},
+		wantRequests: []string{pulls, "POST /repos/knative-sample/app/issues/7/comments @bob /retest created PipelineRun default/app-build-retest-"},
+	}, {
+		name: "retest a merged pull request recreates its build",
+		body: "/retest",
+		runs: []*v1alpha1.PipelineRun{mergeBuild("app-build-x7k2p", false)},
+		responses: map[string]string{
+			pulls: mergedPull,
+		},
+		wantRun: func(t *testing.T, pr *v1alpha1.PipelineRun) {
+			if !strings.HasPrefix(pr.Name, "app-build-x7k2p-retest-") || pr.Labels[CommandKey] != CommandRetest {
+				t.Errorf("retest = %s, labels %v, want a copy of app-build-x7k2p", pr.Name, pr.Labels)
+			}
+			prov := provenance.FromAnnotations(pr.Annotations)
+			if prov.Commit != "cccc3333dddd" || prov.PipelineRun != pr.Name || param(pr, "imageTag") != prov.BuildSequence {
+				t.Errorf("provenance = %+v, image tag %s", prov, param(pr, "imageTag"))
+			}
+		},
+		wantRequests: []string{pulls, "POST /repos/knative-sample/app/issues/7/comments @bob /retest created PipelineRun default/app-build-x7k2p-retest-"},
+	}, {
+		name: "deploy the head of a pull request",
+		body: "/deploy staging",
+		responses: map[string]string{
+			pulls: openPull,
+		},
+		wantRun: func(t *testing.T, pr *v1alpha1.PipelineRun) {
+			if !strings.HasPrefix(pr.Name, "app-deploy-staging-") || pr.Namespace != "staging" || pr.Labels[CommandKey] != CommandDeploy {
+				t.Errorf("deploy = %s/%s, labels %v", pr.Namespace, pr.Name, pr.Labels)
+			}
+			if param(pr, "commit") != "aaaa1111bbbb" || param(pr, "issue") != pullRequestURL || param(pr, "pullRequest") != pullRequestURL {
+				t.Errorf("params = %+v", pr.Spec.Params)
+			}
+			if prov := provenance.FromAnnotations(pr.Annotations); prov.Author != "bob" || prov.PullRequest != pullRequestURL {
+				t.Errorf("provenance = %+v, want the commenter and the pull request", prov)
+			}
+		},
+		wantRequests: []string{pulls, "POST /repos/knative-sample/app/issues/7/comments @bob /deploy staging created PipelineRun staging/app-deploy-staging-"},
+	}, {
+		name: "deploy the default branch from an issue",
+		body: "/deploy prod",
+		url:  issueURL,
+		responses: map[string]string{
+			"GET /repos/knative-sample/app":               `{"default_branch":"main"}`,
+			"GET /repos/knative-sample/app/branches/main": `{"commit":{"sha":"eeee5555ffff"}}`,
+		},
+		wantRun: func(t *testing.T, pr *v1alpha1.PipelineRun) {
+			if param(pr, "commit") != "eeee5555ffff" || param(pr, "branch") != "main" || param(pr, "issue") != issueURL || param(pr, "pullRequest") != "" {
+				t.Errorf("params = %+v, want the head of main", pr.Spec.Params)
+			}
+			if prov := provenance.FromAnnotations(pr.Annotations); prov.Commit != "eeee5555ffff" || prov.PullRequest != "" {
+				t.Errorf("provenance = %+v, want the commit without a pull request", prov)
+			}
+		},
+		wantRequests: []string{
+			"GET /repos/knative-sample/app",
+			"GET /repos/knative-sample/app/branches/main",
+			"POST /repos/knative-sample/app/issues/9/comments @bob /deploy prod created PipelineRun prod/app-deploy-prod-",
+		},
+	}, {
+		name: "rollback allowed by the permission on the repository",
+		body: "/rollback prod",
+		url:  issueURL,
+		responses: map[string]string{
+			"GET /repos/knative-sample/app/collaborators/bob/permission": `{"permission":"admin"}`,
+			"GET /repos/knative-sample/app":                              `{"default_branch":"main"}`,
+			"GET /repos/knative-sample/app/branches/main":                `{"commit":{"sha":"eeee5555ffff"}}`,
+		},
+		wantRun: func(t *testing.T, pr *v1alpha1.PipelineRun) {
+			if !strings.HasPrefix(pr.Name, "app-rollback-prod-") || pr.Labels[CommandKey] != CommandRollback {
+				t.Errorf("rollback = %s, labels %v", pr.Name, pr.Labels)
+			}
+		},
+		wantRequests: []string{
+			"GET /repos/knative-sample/app/collaborators/bob/permission",
+			"GET /repos/knative-sample/app",
+			"GET /repos/knative-sample/app/branches/main",
+			"POST /repos/knative-sample/app/issues/9/comments @bob /rollback prod created PipelineRun prod/app-rollback-prod-",
+		},
+	}, {
+		name: "rollback refused without the permission",
+		body: "/rollback prod",
+		responses: map[string]string{
+			"GET /repos/knative-sample/app/collaborators/bob/permission": `{"permission":"write"}`,
+		},
+		wantSkipped: "bob is not allowed to /rollback prod",
+		wantRequests: []string{
+			"GET /repos/knative-sample/app/collaborators/bob/permission",
+			"POST /repos/knative-sample/app/issues/7/comments @bob you are not allowed to /rollback prod",
+		},
+	}, {
+		name:        "rollback refused when the permission can't be looked up",
+		body:        "/rollback prod",
+		wantSkipped: "check the permission of bob to /rollback prod: GET /repos/knative-sample/app/collaborators/bob/permission status 404",
+		wantRequests: []string{
+			"GET /repos/knative-sample/app/collaborators/bob/permission",
+			"POST /repos/knative-sample/app/issues/7/comments @bob your permission to /rollback prod can't be checked",
+		},
+	}, {
+		name: "cancel the running builds",
+		body: "/cancel",
+		runs: []*v1alpha1.PipelineRun{
+			mergeBuild("app-build-done", false),
+			mergeBuild("app-build-running", true),
+		},
+		wantCancelled: []string{"default/app-build-running"},
+		wantRequests:  []string{"POST /repos/knative-sample/app/issues/7/comments @bob /cancel cancelled PipelineRuns default/app-build-running"},
+	}, {
+		name:         "cancel without a running build",
+		body:         "/cancel",
+		runs:         []*v1alpha1.PipelineRun{mergeBuild("app-build-done", false)},
+		wantSkipped:  "/cancel failed: no running PipelineRun of " + pullRequestURL,
+		wantRequests: []string{"POST /repos/knative-sample/app/issues/7/comments @bob /cancel failed: no running PipelineRun of " + pullRequestURL},
+	}, {
+		name:        "retest on an issue",
+		body:        "/retest",
+		url:         issueURL,
+		wantSkipped: issueURL + " is an issue, /retest only runs on pull requests",
+	}, {
+		name:        "not a collaborator",
+		body:        "/deploy staging",
+		association: "CONTRIBUTOR",
+		acknowledge: AcknowledgeReaction,
+		wantSkipped: "bob is not allowed to /deploy staging",
+		wantRequests: []string{
+			`POST /repos/knative-sample/app/issues/comments/42/reactions {"content":"-1"}`,
+		},
+	}, {
+		name:        "deploy acknowledged with a reaction",
+		body:        "/deploy staging",
+		acknowledge: AcknowledgeReaction,
+		responses: map[string]string{
+			pulls: openPull,
+		},
+		wantRun: func(t *testing.T, pr *v1alpha1.PipelineRun) {},
+		wantRequests: []string{
+			pulls,
+			`POST /repos/knative-sample/app/issues/comments/42/reactions {"content":"+1"}`,
+		},
+	}, {
+		name:        "environment without a template",
+		body:        "/rollback staging",
+		wantSkipped: "/rollback staging failed: no rollback template for environment staging",
+		wantRequests: []string{
+			"POST /repos/knative-sample/app/issues/7/comments @bob /rollback staging failed: no rollback template for environment staging",
+		},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "chatops")
+			if err != nil {
+				t.Fatalf("create temp dir error:%s", err)
+			}
+			defer os.RemoveAll(dir)
+			triggerConfig := filepath.Join(dir, "trigger.yaml")
+			if err := ioutil.WriteFile(triggerConfig, []byte(buildTemplate), 0644); err != nil {
+				t.Fatalf("write trigger config error:%s", err)
+			}
+
+			api := &githubAPI{responses: c.responses}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			objects := []runtime.Object{}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, pr := range c.runs {
+				objects = append(objects, pr)
+				indexer.Add(pr)
+			}
+			tektonClient := tektonfake.NewSimpleClientset(objects...)
+			var created *v1alpha1.PipelineRun
+			tektonClient.PrependReactor("create", "pipelineruns", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				created = action.(k8stesting.CreateAction).GetObject().(*v1alpha1.PipelineRun)
+				return false, nil, nil
+			})
+
+			acknowledge, association, url := c.acknowledge, c.association, c.url
+			if association == "" {
+				association = "MEMBER"
+			}
+			if url == "" {
+				url = pullRequestURL
+			}
+			dp := &Trigger{
+				TriggerConfig: triggerConfig,
+				chatops:       chatOpsConfig(t, dir, acknowledge),
+				github:        newGitHubClient(server.URL, "t0ken"),
+				watcher:       &runWatcher{tekton: tektonClient, runs: listers.NewPipelineRunLister(indexer)},
+			}
+			number := int64(7)
+			if url == issueURL {
+				number = 9
+			}
+
+			reply, err := dp.issueCommentEvent(commandEvent(t, c.body, "bob", association, url, number))
+			if err != nil {
+				t.Fatalf("issueCommentEvent error:%s", err)
+			}
+
+			requests := []string{}
+			for _, r := range api.requests {
+				// the comments are compared up to the name generated for the PipelineRun
+				if i := strings.Index(r, ` {"body":"`); i > 0 {
+					var body struct{ Body string }
+					json.Unmarshal([]byte(r[i+1:]), &body)
+					r = r[:i] + " " + body.Body
+				}
+				requests = append(requests, r)
+			}
+			if len(requests) != len(c.wantRequests) {
+				t.Fatalf("GitHub requests:\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(c.wantRequests, "\n"))
+			}
+			for i, want := range c.wantRequests {
+				if !strings.HasPrefix(requests[i], want) {
+					t.Errorf("GitHub request %d = %s, want %s", i, requests[i], want)
+				}
+			}
+
+			if c.wantSkipped != "" {
+				if reply.Type != events.TypeTriggerSkipped || !strings.HasPrefix(reply.Reason, c.wantSkipped) {
+					t.Errorf("reply = %+v, want skipped: %s", reply, c.wantSkipped)
+				}
+				if created != nil {
+					t.Errorf("created PipelineRun %s", created.Name)
+				}
+				return
+			}
+			if reply.Command != parseCommand(c.body).String() || reply.Rule != RuleChatOps {
+				t.Errorf("reply = %+v, want the command %s", reply, c.body)
+			}
+			if c.wantCancelled != nil {
+				if reply.Type != events.TypePipelineRunCancelled || strings.Join(reply.Cancelled, ",") != strings.Join(c.wantCancelled, ",") {
+					t.Errorf("reply = %+v, want %v cancelled", reply, c.wantCancelled)
+				}
+				for _, a := range tektonClient.Actions() {
+					if p, ok := a.(k8stesting.PatchAction); ok && (p.GetName() != "app-build-running" || !strings.Contains(string(p.GetPatch()), v1alpha1.PipelineRunSpecStatusCancelled)) {
+						t.Errorf("patched %s with %s", p.GetName(), p.GetPatch())
+					}
+				}
+				return
+			}
+			if created == nil {
+				t.Fatalf("no PipelineRun created, reply %+v", reply)
+			}
+			if reply.Type != events.TypePipelineRunCreated || reply.Name != created.Name || reply.Namespace != created.Namespace {
+				t.Errorf("reply = %+v, want %s/%s created", reply, created.Namespace, created.Name)
+			}
+			c.wantRun(t, created)
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package trigger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultGitHubAPI is the API of github.com, set --github-api-url for GitHub Enterprise
+const DefaultGitHubAPI = "https://api.github.com"
+
+// githubTimeout bounds a call of the GitHub API
+const githubTimeout = 10 * time.Second
+
+// The reactions acknowledging a command
+const (
+	reactionOK     = "+1"
+	reactionFailed = "-1"
+)
+
+// githubClient calls the few endpoints of the GitHub API the ChatOps commands need
+type githubClient struct {
+	api    string
+	token  string
+	client *http.Client
+}
+
+func newGitHubClient(api, token string) *githubClient {
+	if api == "" {
+		api = DefaultGitHubAPI
+	}
+	return &githubClient{
+		api:    strings.TrimSuffix(api, "/"),
+		token:  token,
+		client: &http.Client{Timeout: githubTimeout},
+	}
+}
+
+// githubPullRequest is what the commands read of a pull request
+type githubPullRequest struct {
+	Merged         bool   `json:"merged"`
+	MergeCommitSha string `json:"merge_commit_sha"`
+	Head           struct {
+		Ref string `json:"ref"`
+		Sha string `json:"sha"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// commit is the merge commit of a merged pull request, the head commit otherwise
+func (pr *githubPullRequest) commit() (string, string) {
+	if pr.Merged && pr.MergeCommitSha != "" {
+		return pr.MergeCommitSha, pr.Base.Ref
+	}
+	return pr.Head.Sha, pr.Head.Ref
+}
+
+// pullRequest gets the pull request number of the owner/name repository
+func (c *githubClient) pullRequest(repo string, number int64) (*githubPullRequest, error) {
+	pr := &githubPullRequest{}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), "", nil, pr); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+// permission is the permission of the user on the owner/name repository: admin, write,
+// read or none
+func (c *githubClient) permission(repo, user string) (string, error) {
+	p := &struct {
+		Permission string `json:"permission"`
+	}{}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/repos/%s/collaborators/%s/permission", repo, user), "", nil, p); err != nil {
+		return "", err
+	}
+	return p.Permission, nil
+}
+
+// defaultBranch returns the head commit and the name of the default branch of the
+// owner/name repository
+func (c *githubClient) defaultBranch(repo string) (string, string, error) {
+	r := &struct {
+		DefaultBranch string `json:"default_branch"`
+	}{}
+	if err := c.do(http.MethodGet, "/repos/"+repo, "", nil, r); err != nil {
+		return "", "", err
+	}
+	b := &struct {
+		Commit struct {
+			Sha string `json:"sha"`
+		} `json:"commit"`
+	}{}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/repos/%s/branches/%s", repo, r.DefaultBranch), "", nil, b); err != nil {
+		return "", "", err
+	}
+	return b.Commit.Sha, r.DefaultBranch, nil
+}
+
+// comment posts a comment to the issue or the pull request number
+func (c *githubClient) comment(repo string, number int64, body string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", repo, number), "",
+		map[string]string{"body": body}, nil)
+}
+
+// react adds a reaction to the comment
+func (c *githubClient) react(repo string, commentID int64, content string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/repos/%s/issues/comments/%d/reactions", repo, commentID),
+		"application/vnd.github.squirrel-girl-preview+json", map[string]string{"content": content}, nil)
+}
+
+func (c *githubClient) do(method, path, accept string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		bts, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(bts)
+	}
+	req, err := http.NewRequest(method, c.api+path, body)
+	if err != nil {
+		return err
+	}
+	if accept == "" {
+		accept = "application/vnd.github.v3+json"
+	}
+	req.Header.Set("Accept", accept)
+	if in != nil {
+		req.Header.Set("Content-Type", ApplicationJSON)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "token "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bts, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(bts)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(bts, out)
+}
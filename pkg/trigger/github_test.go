@@ -0,0 +1,121 @@
+package trigger
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// githubAPI answers the GitHub API calls with the JSON of their "METHOD path", 404 for
+// the others, and records them with their body
+type githubAPI struct {
+	responses map[string]string
+	requests  []string
+	headers   []http.Header
+}
+
+func (s *githubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	call := r.Method + " " + r.URL.Path
+	s.requests = append(s.requests, strings.TrimSpace(call+" "+string(body)))
+	s.headers = append(s.headers, r.Header)
+	resp, ok := s.responses[call]
+	if !ok && r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Not Found"}`))
+		return
+	}
+	w.Write([]byte(resp))
+}
+
+func TestGitHubClient(t *testing.T) {
+	s := &githubAPI{responses: map[string]string{
+		"GET /api/v3/repos/knative-sample/app/pulls/7":                      `{"merged":false,"head":{"ref":"feature","sha":"aaaa1111"},"base":{"ref":"master"}}`,
+		"GET /api/v3/repos/knative-sample/app/pulls/8":                      `{"merged":true,"merge_commit_sha":"cccc3333","head":{"ref":"fix","sha":"aaaa2222"},"base":{"ref":"master"}}`,
+		"GET /api/v3/repos/knative-sample/app/collaborators/bob/permission": `{"permission":"write","user":{"login":"bob"}}`,
+		"GET /api/v3/repos/knative-sample/app":                              `{"full_name":"knative-sample/app","default_branch":"main"}`,
+		"GET /api/v3/repos/knative-sample/app/branches/main":                `{"name":"main","commit":{"sha":"bbbb2222"}}`,
+	}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	// a GitHub Enterprise API is under /api/v3
+	c := newGitHubClient(server.URL+"/api/v3/", "t0ken")
+
+	open, err := c.pullRequest("knative-sample/app", 7)
+	if err != nil {
+		t.Fatalf("pullRequest error:%s", err)
+	}
+	if commit, branch := open.commit(); commit != "aaaa1111" || branch != "feature" {
+		t.Errorf("commit of an open pull request = %s on %s, want its head", commit, branch)
+	}
+	merged, err := c.pullRequest("knative-sample/app", 8)
+	if err != nil {
+		t.Fatalf("pullRequest error:%s", err)
+	}
+	if commit, branch := merged.commit(); commit != "cccc3333" || branch != "master" {
+		t.Errorf("commit of a merged pull request = %s on %s, want its merge commit", commit, branch)
+	}
+	if level, err := c.permission("knative-sample/app", "bob"); err != nil || level != "write" {
+		t.Errorf("permission = %q, %v, want write", level, err)
+	}
+	if commit, branch, err := c.defaultBranch("knative-sample/app"); err != nil || commit != "bbbb2222" || branch != "main" {
+		t.Errorf("defaultBranch = %s, %s, %v, want bbbb2222 on main", commit, branch, err)
+	}
+	if err := c.comment("knative-sample/app", 7, "@bob done"); err != nil {
+		t.Errorf("comment error:%s", err)
+	}
+	if err := c.react("knative-sample/app", 42, reactionOK); err != nil {
+		t.Errorf("react error:%s", err)
+	}
+	_, err = c.pullRequest("knative-sample/app", 9)
+	if err == nil || !strings.Contains(err.Error(), "status 404: {\"message\":\"Not Found\"}") {
+		t.Errorf("pullRequest of a missing pull request error:%v, want the status and the message", err)
+	}
+
+	want := []string{
+		"GET /api/v3/repos/knative-sample/app/pulls/7",
+		"GET /api/v3/repos/knative-sample/app/pulls/8",
+		"GET /api/v3/repos/knative-sample/app/collaborators/bob/permission",
+		"GET /api/v3/repos/knative-sample/app",
+		"GET /api/v3/repos/knative-sample/app/branches/main",
+		`POST /api/v3/repos/knative-sample/app/issues/7/comments {"body":"@bob done"}`,
+		`POST /api/v3/repos/knative-sample/app/issues/comments/42/reactions {"content":"+1"}`,
+		"GET /api/v3/repos/knative-sample/app/pulls/9",
+	}
+	if strings.Join(s.requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests:\n%s\nwant\n%s", strings.Join(s.requests, "\n"), strings.Join(want, "\n"))
+	}
+	for i, h := range s.headers {
+		if h.Get("Authorization") != "token t0ken" {
+			t.Errorf("%s has Authorization %q", s.requests[i], h.Get("Authorization"))
+		}
+	}
+	if accept := s.headers[0].Get("Accept"); accept != "application/vnd.github.v3+json" {
+		t.Errorf("Accept = %s", accept)
+	}
+	if ct := s.headers[5].Get("Content-Type"); ct != ApplicationJSON {
+		t.Errorf("Content-Type of a comment = %s", ct)
+	}
+	// the reactions are a preview of the API
+	if accept := s.headers[6].Get("Accept"); accept != "application/vnd.github.squirrel-girl-preview+json" {
+		t.Errorf("Accept of a reaction = %s", accept)
+	}
+
+	anonymous := newGitHubClient(server.URL+"/api/v3", "")
+	if _, err := anonymous.pullRequest("knative-sample/app", 7); err != nil {
+		t.Fatalf("pullRequest error:%s", err)
+	}
+	if h := s.headers[len(s.headers)-1]; h.Get("Authorization") != "" {
+		t.Errorf("anonymous call has Authorization %q", h.Get("Authorization"))
+	}
+	if c := newGitHubClient("", ""); c.api != DefaultGitHubAPI {
+		t.Errorf("default API = %s, want %s", c.api, DefaultGitHubAPI)
+	}
+}
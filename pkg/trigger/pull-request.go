@@ -7,12 +7,16 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
 	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
 	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	gh "gopkg.in/go-playground/webhooks.v5/github"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
 
 	"fmt"
@@ -22,7 +26,7 @@ import (
 	"k8s.io/api/rbac/v1beta1"
 )
 
-func (dp *Trigger) pullRequestMergedEvent(e cloudevents.Event) error {
+func (dp *Trigger) pullRequestMergedEvent(e cloudevents.Event) (*Reply, error) {
 	payload := &gh.PullRequestPayload{}
 	if e.Data == nil {
 		glog.Infof("cloudevents.Event\n  Type:%s\n  Data is empty", e.Context.GetType())
@@ -39,15 +43,15 @@ func (dp *Trigger) pullRequestMergedEvent(e cloudevents.Event) error {
 	json.Unmarshal(data, payload)
 
 	if payload.Action == "closed" && payload.PullRequest.Merged {
-		return dp.onPullRequestMerged(payload)
+		return dp.onPullRequestMerged(payload, e.ID())
 	}
 
 	glog.Infof("pull request, action: %s merged: %v pull_request url: %s ", payload.Action, payload.PullRequest.Merged, payload.PullRequest.HTMLURL)
 
-	return nil
+	return skipped(e, RulePullRequestMerged, fmt.Sprintf("pull request %s is %s, not merged", payload.PullRequest.HTMLURL, payload.Action)), nil
 }
 
-func (dp *Trigger) onPullRequestMerged(payload *gh.PullRequestPayload) error {
+func (dp *Trigger) onPullRequestMerged(payload *gh.PullRequestPayload, delivery string) (*Reply, error) {
 	glog.Infof("pull request, action: %s merged: %v pull_request url: %s ", payload.Action, payload.PullRequest.Merged, payload.PullRequest.HTMLURL)
 	mergeCommitSha := *payload.PullRequest.MergeCommitSha
 	args := &Args{
@@ -57,19 +61,15 @@ func (dp *Trigger) onPullRequestMerged(payload *gh.PullRequestPayload) error {
 		Branch:        payload.PullRequest.Base.Ref,
 	}
 
-	tmpl, err := template.ParseFiles(dp.TriggerConfig)
+	u, err := dp.buildRun(args)
 	if err != nil {
-		glog.Errorf("Parse TriggerConfig error:%s ", err.Error())
-		return err
+		return nil, err
 	}
 
-	buf := &bytes.Buffer{}
-	tmpl.Execute(buf, args)
-
 	cfg, err := kube.GetKubeconfig()
 	if err != nil {
 		glog.Errorf("get kubeconfig error:%s ", err)
-		return err
+		return nil, err
 	}
 
 	tektonClient, err := tektonclientset.NewForConfig(cfg)
@@ -77,54 +77,146 @@ func (dp *Trigger) onPullRequestMerged(payload *gh.PullRequestPayload) error {
 		glog.Fatalf("Error building Build clientset: %v", err)
 	}
 
-	jsonbts, _ := yaml.YAMLToJSON(buf.Bytes())
+	// the image tag is the build sequence as well
+	sequence := fmt.Sprintf("%v", time.Now().Unix())
+	setImageTag(u, sequence)
+	// name a generateName template now, the provenance and the watcher need the name
+	generated := u.Name == "" && u.GenerateName != ""
+	if generated {
+		u.Name = generateRunName(u.GenerateName)
+	}
+	stampProvenance(u, payload, delivery, sequence)
+	//// bind role
+	//if err := dp.bindServiceRole(fmt.Sprintf("%s-serving-role", u.Name), u.Namespace, u.Spec.ServiceAccountName); err != nil {
+	//	glog.Errorf("bindService Role error:%s ", err)
+	//	return err
+	//}
+
+	// a fixed name is reused by every merge, a generated one is never replaced
+	if !generated {
+		if _, err := tektonClient.TektonV1alpha1().PipelineRuns(u.Namespace).Get(u.Name, metav1.GetOptions{}); err != nil {
+			// The Build resource may not exist.
+			if !errors.IsNotFound(err) {
+				glog.Errorf("get build %s error:%s ", u.Name, err.Error())
+				return nil, err
+			}
+		} else {
+			if err := tektonClient.TektonV1alpha1().PipelineRuns(u.Namespace).Delete(u.Name, &metav1.DeleteOptions{}); err != nil {
+				if !errors.IsNotFound(err) {
+					glog.Errorf("delete build %s error:%s ", u.Name, err.Error())
+					return nil, err
+				}
+			}
+		}
+	}
+
+	created, err := tektonClient.TektonV1alpha1().PipelineRuns(u.Namespace).Create(u)
+	if err != nil {
+		glog.Errorf("create build %s error:%s ", u.Name, err.Error())
+		return nil, err
+	}
+	dp.notifier.Notify(runMessage(notify.KindBuildStarted, created))
+	prov := provenance.FromAnnotations(u.Annotations)
+	reply := &Reply{
+		Type:       events.TypePipelineRunCreated,
+		Subject:    u.Namespace + "/" + u.Name,
+		Event:      delivery,
+		Rule:       RulePullRequestMerged,
+		Name:       u.Name,
+		Namespace:  u.Namespace,
+		Provenance: &prov,
+	}
+	dp.events.Emit(reply.Type, reply.Subject, reply)
+
+	return reply, nil
+}
+
+// buildRun is the PipelineRun of the --trigger-config template building the commit of
+// args, in the default namespace when the template sets none
+func (dp *Trigger) buildRun(args *Args) (*v1alpha1.PipelineRun, error) {
+	tmpl, err := template.ParseFiles(dp.TriggerConfig)
+	if err != nil {
+		glog.Errorf("Parse TriggerConfig error:%s ", err.Error())
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, args); err != nil {
+		glog.Errorf("execute TriggerConfig error:%s ", err)
+		return nil, err
+	}
+
+	jsonbts, err := yaml.YAMLToJSON(buf.Bytes())
+	if err != nil {
+		glog.Errorf("parse Build Object error:%s ", err)
+		return nil, err
+	}
 	u := &v1alpha1.PipelineRun{}
 	if err := yaml.Unmarshal(jsonbts, u); err != nil {
 		glog.Errorf("parse Build Object error:%s ", err.Error())
-		return err
+		return nil, err
 	}
 
 	if u.Namespace == "" {
 		u.Namespace = "default"
 	}
+	return u, nil
+}
+
+// stampProvenance labels the PipelineRun with the commit and the event which triggered it,
+// Tekton copies them to the TaskRuns and their pods. The merge time and the sequence
+// order the builds.
+func stampProvenance(u *v1alpha1.PipelineRun, payload *gh.PullRequestPayload, delivery, sequence string) {
+	prov := provenance.Provenance{
+		Commit:        *payload.PullRequest.MergeCommitSha,
+		Repo:          payload.Repository.HTMLURL,
+		PullRequest:   payload.PullRequest.HTMLURL,
+		PipelineRun:   u.Name,
+		Delivery:      delivery,
+		Author:        payload.PullRequest.User.Login,
+		BuildSequence: sequence,
+	}
+	if payload.PullRequest.MergedAt != nil {
+		prov.CommitTime = payload.PullRequest.MergedAt.UTC().Format(time.RFC3339)
+	}
+	stamp(u, prov)
+}
+
+// stamp sets the labels and the annotations of the provenance on the PipelineRun
+func stamp(u *v1alpha1.PipelineRun, prov provenance.Provenance) {
+	if u.Labels == nil {
+		u.Labels = map[string]string{}
+	}
+	for k, v := range prov.Labels() {
+		u.Labels[k] = v
+	}
+	if u.Annotations == nil {
+		u.Annotations = map[string]string{}
+	}
+	for k, v := range prov.Annotations() {
+		u.Annotations[k] = v
+	}
+}
+
+// generateRunName names a PipelineRun of a generateName template the way the API server
+// would, two merges in the same second get different names
+func generateRunName(generateName string) string {
+	return generateName + utilrand.String(5)
+}
+
+// setImageTag sets the imageTag param to the build sequence
+func setImageTag(u *v1alpha1.PipelineRun, sequence string) {
 	ps := make([]v1alpha1.Param, 0)
-	for _, param := range u.Spec.Params{
+	for _, param := range u.Spec.Params {
 		if param.Name == "imageTag" {
 			param.Value = v1alpha1.ArrayOrString{
-				Type: v1alpha1.ParamTypeString,
-				StringVal: fmt.Sprintf("%v", time.Now().Unix()),
+				Type:      v1alpha1.ParamTypeString,
+				StringVal: sequence,
 			}
 		}
 		ps = append(ps, param)
 	}
 	u.Spec.Params = ps
-	//// bind role
-	//if err := dp.bindServiceRole(fmt.Sprintf("%s-serving-role", u.Name), u.Namespace, u.Spec.ServiceAccountName); err != nil {
-	//	glog.Errorf("bindService Role error:%s ", err)
-	//	return err
-	//}
-
-	if _, err := tektonClient.TektonV1alpha1().PipelineRuns(u.Namespace).Get(u.Name, metav1.GetOptions{}); err != nil {
-		// The Build resource may not exist.
-		if !errors.IsNotFound(err) {
-			glog.Errorf("get build %s error:%s ", u.Name, err.Error())
-			return err
-		}
-	} else {
-		if err := tektonClient.TektonV1alpha1().PipelineRuns(u.Namespace).Delete(u.Name, &metav1.DeleteOptions{}); err != nil {
-			if !errors.IsNotFound(err) {
-				glog.Errorf("delete build %s error:%s ", u.Name, err.Error())
-				return err
-			}
-		}
-	}
-
-	if _, err := tektonClient.TektonV1alpha1().PipelineRuns(u.Namespace).Create(u); err != nil {
-		glog.Errorf("create build %s error:%s ", u.Name, err.Error())
-		return err
-	}
-
-	return nil
 }
 
 func (dp *Trigger) bindServiceRole(name, namespace string, serviceAccount string) error {
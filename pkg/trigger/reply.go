@@ -0,0 +1,72 @@
+package trigger
+
+import (
+	"net/http"
+
+	"github.com/cloudevents/sdk-go/pkg/cloudevents"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+)
+
+// eventSource is the source of the events of the trigger
+const eventSource = "tekton-serving/trigger"
+
+// The rules the GitHub events are matched against
+const (
+	RulePullRequestMerged = "pull_request.merged"
+	RuleApprovalComment   = "issue_comment.approval"
+	RuleChatOps           = "issue_comment.command"
+)
+
+// Reply is what the trigger did on a GitHub event, it is the data of the event replied
+// to the Broker or Channel which delivered it
+type Reply struct {
+	// Type and Subject are the type and the subject of the reply event
+	Type    string `json:"-"`
+	Subject string `json:"-"`
+
+	// Event is the id of the GitHub event
+	Event string `json:"event"`
+	// Rule is the rule the event matched, Reason why the event was skipped and Error
+	// why handling it failed
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Name and Namespace are the PipelineRun created
+	Name       string                 `json:"name,omitempty"`
+	Namespace  string                 `json:"namespace,omitempty"`
+	Provenance *provenance.Provenance `json:"provenance,omitempty"`
+
+	// Approvals are the approval ConfigMaps decided
+	Approvals []string `json:"approvals,omitempty"`
+
+	// Command is the ChatOps command run, Cancelled the PipelineRuns it cancelled
+	Command   string   `json:"command,omitempty"`
+	Cancelled []string `json:"cancelled,omitempty"`
+}
+
+// skipped is the reply to an event the trigger did nothing on
+func skipped(e cloudevents.Event, rule, reason string) *Reply {
+	glog.Infof("skip event %s %s: %s", e.Context.GetType(), e.ID(), reason)
+	return &Reply{Type: events.TypeTriggerSkipped, Subject: e.Context.GetType(), Event: e.ID(), Rule: rule, Reason: reason}
+}
+
+// failed is the reply to an event the trigger failed to handle
+func failed(e cloudevents.Event, err error) *Reply {
+	return &Reply{Type: events.TypeTriggerFailed, Subject: e.Context.GetType(), Event: e.ID(), Error: err.Error()}
+}
+
+// respond sets the reply event of the response
+func respond(resp *cloudevents.EventResponse, reply *Reply) {
+	if reply == nil {
+		return
+	}
+	event, err := events.NewEvent(reply.Type, eventSource, reply.Subject, reply)
+	if err != nil {
+		glog.Errorf("encode reply %s of event %s error:%s", reply.Type, reply.Event, err)
+		return
+	}
+	resp.RespondWith(http.StatusOK, &event)
+}
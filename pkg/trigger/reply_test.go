@@ -0,0 +1,105 @@
+package trigger
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/pkg/cloudevents"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRun(t *testing.T) {
+	ping := cloudevents.New()
+	ping.SetID("2")
+	ping.SetType("dev.knative.source.github.ping")
+	push := cloudevents.New()
+	push.SetID("3")
+	push.SetType("dev.knative.source.github.push")
+	invalid := commentEvent(t, "/approve", "bob", "MEMBER", "alice")
+	invalid.Data = []byte("{")
+
+	cases := []struct {
+		name    string
+		event   cloudevents.Event
+		listErr error
+		want    Reply
+	}{{
+		name:  "ping",
+		event: ping,
+		want:  Reply{Event: "2", Reason: "ping"},
+	}, {
+		name:  "unsupported event",
+		event: push,
+		want:  Reply{Event: "3", Reason: "unsupported event type dev.knative.source.github.push"},
+	}, {
+		name:  "invalid payload",
+		event: invalid,
+		want:  Reply{Event: "1", Error: "unexpected end of JSON input"},
+	}, {
+		name:    "approvals not listed",
+		event:   commentEvent(t, "/approve", "bob", "MEMBER", "alice"),
+		listErr: errors.New("etcdserver: request timed out"),
+		want:    Reply{Event: "1", Error: "etcdserver: request timed out"},
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return c.listErr != nil, nil, c.listErr
+			})
+			dp := &Trigger{watcher: &runWatcher{kube: client}}
+
+			resp := &cloudevents.EventResponse{}
+			if err := dp.run(c.event, resp); err != nil {
+				t.Fatalf("run error:%s, want it replied", err)
+			}
+			if resp.Status != http.StatusOK || resp.Event == nil {
+				t.Fatalf("response = %+v, want a reply event", resp)
+			}
+			e := resp.Event
+			wantType := events.TypeTriggerSkipped
+			if c.want.Error != "" {
+				wantType = events.TypeTriggerFailed
+			}
+			if e.Type() != wantType || e.Source() != eventSource || e.Subject() != c.event.Type() || e.ID() == "" {
+				t.Errorf("reply event = %s, want a %s of %s", e, wantType, c.event.Type())
+			}
+			got := Reply{}
+			if err := e.DataAs(&got); err != nil {
+				t.Fatalf("decode reply error:%s", err)
+			}
+			if got.Event != c.want.Event || got.Reason != c.want.Reason || got.Error != c.want.Error {
+				t.Errorf("reply = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	resp := &cloudevents.EventResponse{}
+	respond(resp, nil)
+	if resp.Event != nil {
+		t.Errorf("response = %+v, want no reply", resp)
+	}
+
+	respond(resp, &Reply{Type: events.TypePipelineRunCancelled, Subject: pullRequestURL, Event: "1",
+		Rule: RuleChatOps, Command: "cancel", Cancelled: []string{"default/app-build-42"}})
+	if resp.Event == nil || resp.Event.Type() != events.TypePipelineRunCancelled || resp.Event.Subject() != pullRequestURL {
+		t.Fatalf("response = %+v, want the cancelled reply", resp)
+	}
+	got := Reply{}
+	if err := resp.Event.DataAs(&got); err != nil {
+		t.Fatalf("decode reply error:%s", err)
+	}
+	if got.Rule != RuleChatOps || got.Command != "cancel" || len(got.Cancelled) != 1 || got.Type != "" {
+		t.Errorf("reply = %+v, want the command and the cancelled runs without the type", got)
+	}
+	// the type and the subject are attributes of the event, not its data
+	if data, _ := resp.Event.DataBytes(); string(data) != `{"event":"1","rule":"issue_comment.command","command":"cancel","cancelled":["default/app-build-42"]}` {
+		t.Errorf("reply data = %s", data)
+	}
+}
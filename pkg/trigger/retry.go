@@ -0,0 +1,233 @@
+package trigger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RetryOfKey is the label of a retry with the name of the PipelineRun the trigger
+	// created first, RetryAttemptKey the number of the retry
+	RetryOfKey      = provenance.GroupName + "/retry-of"
+	RetryAttemptKey = provenance.GroupName + "/retry-attempt"
+)
+
+// defaultRetryReasons match the failures of the pods rather than of the code: evictions,
+// preemptions, lost nodes and image pulls
+var defaultRetryReasons = []string{
+	`(?i)evict`,
+	`(?i)preempt`,
+	`(?i)ImagePullBackOff|ErrImagePull|image ?pull`,
+	`(?i)NodeLost|node .* (is|was) (lost|not ready)`,
+}
+
+// defaultRetryLogPatterns match the registry throttling and the network timeouts in
+// the log of the failed step, such as a Kaniko push timing out
+var defaultRetryLogPatterns = []string{
+	`(?i)toomanyrequests|429 Too Many Requests`,
+	`(?i)i/o timeout|TLS handshake timeout|connection reset by peer`,
+	`(?i)context deadline exceeded|Client\.Timeout exceeded`,
+}
+
+// RetryPolicy is the --retry-config file, the failed PipelineRuns it matches are
+// recreated with a backoff
+type RetryPolicy struct {
+	// MaxRetries is the number of retries of a PipelineRun, 2 by default
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// Backoff is the wait before the first retry, doubled at each retry up to
+	// MaxBackoff. 30s and 10m by default.
+	Backoff    string `json:"backoff,omitempty"`
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+	// Reasons are regexps of the failure reason, LogPatterns of the log of the failed
+	// step. The defaults match the infrastructure failures when both are empty.
+	Reasons     []string `json:"reasons,omitempty"`
+	LogPatterns []string `json:"logPatterns,omitempty"`
+
+	backoff, maxBackoff time.Duration
+	reasons, logs       []*regexp.Regexp
+}
+
+// LoadRetryPolicy reads a RetryPolicy yaml file
+func LoadRetryPolicy(path string) (*RetryPolicy, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &RetryPolicy{}
+	if err := yaml.Unmarshal(bts, p); err != nil {
+		return nil, fmt.Errorf("parse retry config %s error:%s", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("retry config %s: %s", path, err)
+	}
+	return p, nil
+}
+
+func (p *RetryPolicy) compile() error {
+	if p.MaxRetries == 0 {
+		p.MaxRetries = 2
+	}
+	var err error
+	if p.backoff, err = duration(p.Backoff, 30*time.Second); err != nil {
+		return fmt.Errorf("invalid backoff %q: %s", p.Backoff, err)
+	}
+	if p.maxBackoff, err = duration(p.MaxBackoff, 10*time.Minute); err != nil {
+		return fmt.Errorf("invalid maxBackoff %q: %s", p.MaxBackoff, err)
+	}
+	reasons, logs := p.Reasons, p.LogPatterns
+	if len(reasons) == 0 && len(logs) == 0 {
+		reasons, logs = defaultRetryReasons, defaultRetryLogPatterns
+	}
+	if p.reasons, err = compileAll(reasons); err != nil {
+		return err
+	}
+	p.logs, err = compileAll(logs)
+	return err
+}
+
+func duration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	res := []*regexp.Regexp{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// retryable returns the pattern the failure matches, empty when it is not retried
+func (p *RetryPolicy) retryable(f *Failure) string {
+	for _, re := range p.reasons {
+		if re.MatchString(f.Reason) {
+			return re.String()
+		}
+	}
+	for _, re := range p.logs {
+		if re.MatchString(f.Log) {
+			return re.String()
+		}
+	}
+	return ""
+}
+
+// wait is the backoff before the retry, attempt is 1 for the first retry
+func (p *RetryPolicy) wait(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
+
+// retryAttempt is the number of the retry of the PipelineRun, 0 for the first run
+func retryAttempt(pr *v1alpha1.PipelineRun) int {
+	n, _ := strconv.Atoi(pr.Labels[RetryAttemptKey])
+	return n
+}
+
+// retryOf is the name of the PipelineRun the trigger created first
+func retryOf(pr *v1alpha1.PipelineRun) string {
+	if name := pr.Labels[RetryOfKey]; name != "" {
+		return name
+	}
+	return pr.Name
+}
+
+// retryName is the name of a retry, it is the same for every trigger so a retry is
+// created once
+func retryName(original string, attempt int) string {
+	suffix := fmt.Sprintf("-retry-%d", attempt)
+	if len(original)+len(suffix) > 63 {
+		original = original[:63-len(suffix)]
+	}
+	return original + suffix
+}
+
+// newRetry is the PipelineRun retrying a failed one, with its spec and its provenance
+func newRetry(pr *v1alpha1.PipelineRun, attempt int) *v1alpha1.PipelineRun {
+	retry := copyRun(pr, retryName(retryOf(pr), attempt))
+	retry.Labels[RetryOfKey] = retryOf(pr)
+	retry.Labels[RetryAttemptKey] = strconv.Itoa(attempt)
+	return retry
+}
+
+// copyRun is a new PipelineRun named name with the spec, the labels and the annotations
+// of pr, without its Failure
+func copyRun(pr *v1alpha1.PipelineRun, name string) *v1alpha1.PipelineRun {
+	run := &v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   pr.Namespace,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: *pr.Spec.DeepCopy(),
+	}
+	run.Spec.Status = ""
+	for k, v := range pr.Labels {
+		run.Labels[k] = v
+	}
+	for k, v := range pr.Annotations {
+		if k != FailureKey {
+			run.Annotations[k] = v
+		}
+	}
+	run.Labels[provenance.PipelineRunKey] = name
+	run.Annotations[provenance.PipelineRunKey] = name
+	return run
+}
+
+// retry returns the PipelineRun retrying the failed one and the wait before creating
+// it, nil when the policy doesn't match the failure, there is no retry left or the run
+// was cancelled
+func (w *runWatcher) retry(pr *v1alpha1.PipelineRun, f *Failure) (*v1alpha1.PipelineRun, time.Duration) {
+	if w.retryPolicy == nil || pr.IsCancelled() {
+		return nil, 0
+	}
+	attempt := retryAttempt(pr) + 1
+	if attempt > w.retryPolicy.MaxRetries {
+		return nil, 0
+	}
+	pattern := w.retryPolicy.retryable(f)
+	if pattern == "" {
+		return nil, 0
+	}
+	retry, wait := newRetry(pr, attempt), w.retryPolicy.wait(attempt)
+	glog.Infof("retry PipelineRun %s/%s as %s in %s, its failure matches %q", pr.Namespace, pr.Name, retry.Name, wait, pattern)
+	return retry, wait
+}
+
+// createRetry creates the retry after the wait. The failure is notified as final when
+// the retry can't be created.
+func (w *runWatcher) createRetry(pr, retry *v1alpha1.PipelineRun, f *Failure, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		_, err := w.tekton.TektonV1alpha1().PipelineRuns(retry.Namespace).Create(retry)
+		if err == nil || errors.IsAlreadyExists(err) {
+			return
+		}
+		glog.Errorf("create retry %s/%s error:%s", retry.Namespace, retry.Name, err)
+		w.notifyFailure(pr, f)
+	})
+}
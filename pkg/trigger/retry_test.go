@@ -0,0 +1,203 @@
+package trigger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// retryPolicy is the compiled policy of the yaml
+func retryPolicy(t *testing.T, config string) *RetryPolicy {
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatalf("write retry config error:%s", err)
+	}
+	p, err := LoadRetryPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadRetryPolicy error:%s", err)
+	}
+	return p
+}
+
+// pipelineRun is a PipelineRun the trigger created, labeled with its name
+func pipelineRun(name string) *v1alpha1.PipelineRun {
+	return &v1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			Labels:      map[string]string{provenance.PipelineRunKey: name},
+			Annotations: map[string]string{provenance.PipelineRunKey: name, FailureKey: `{"reason":"evicted"}`},
+		},
+		Spec: v1alpha1.PipelineRunSpec{ServiceAccountName: "builder"},
+	}
+}
+
+func TestLoadRetryPolicy(t *testing.T) {
+	p := retryPolicy(t, "")
+	if p.MaxRetries != 2 || p.backoff != 30*time.Second || p.maxBackoff != 10*time.Minute {
+		t.Errorf("default policy = %d retries, backoff %s up to %s", p.MaxRetries, p.backoff, p.maxBackoff)
+	}
+	if len(p.reasons) != len(defaultRetryReasons) || len(p.logs) != len(defaultRetryLogPatterns) {
+		t.Errorf("default policy has %d reasons and %d log patterns", len(p.reasons), len(p.logs))
+	}
+
+	// the reasons of the config replace the defaults
+	p = retryPolicy(t, "maxRetries: 5\nbackoff: 1m\nmaxBackoff: 5m\nreasons: [OOMKilled]\n")
+	if p.MaxRetries != 5 || p.backoff != time.Minute || p.maxBackoff != 5*time.Minute || len(p.reasons) != 1 || len(p.logs) != 0 {
+		t.Errorf("policy = %+v", p)
+	}
+
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		t.Fatalf("create temp dir error:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	for config, wantErr := range map[string]string{
+		"backoff: soon\n":         `invalid backoff "soon"`,
+		"maxBackoff: 10\n":        `invalid maxBackoff "10"`,
+		"logPatterns: ['(']\n":    `invalid pattern "("`,
+		"maxRetries: [1, 2]\n":    "parse retry config",
+		"reasons: evicted: yes\n": "parse retry config",
+	} {
+		path := filepath.Join(dir, "retry.yaml")
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("write retry config error:%s", err)
+		}
+		if _, err := LoadRetryPolicy(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("LoadRetryPolicy(%q) error:%v, want %q", config, err, wantErr)
+		}
+	}
+	if _, err := LoadRetryPolicy(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("LoadRetryPolicy of a missing file passed, want an error")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	defaults := retryPolicy(t, "")
+	custom := retryPolicy(t, "logPatterns: ['(?i)connection refused']\n")
+	cases := []struct {
+		name    string
+		policy  *RetryPolicy
+		failure Failure
+		want    bool
+	}{
+		{name: "evicted", policy: defaults, failure: Failure{Reason: "The node was low on resource: memory. Container step-build was Evicted."}, want: true},
+		{name: "preempted", policy: defaults, failure: Failure{Reason: "Preempted by a pod of a higher priority"}, want: true},
+		{name: "image pull", policy: defaults, failure: Failure{Reason: `failed to pull image: Back-off pulling image "gcr.io/kaniko-project/executor" ImagePullBackOff`}, want: true},
+		{name: "node lost", policy: defaults, failure: Failure{Reason: "node worker-3 was not ready"}, want: true},
+		{name: "registry throttling", policy: defaults, failure: Failure{Reason: "step push exited with code 1", Log: "error pushing image: 429 Too Many Requests"}, want: true},
+		{name: "push timeout", policy: defaults, failure: Failure{Reason: "step push exited with code 1", Log: "dial tcp 10.0.0.1:443: i/o timeout"}, want: true},
+		{name: "failing tests", policy: defaults, failure: Failure{Reason: "step test exited with code 1", Log: "--- FAIL: TestApp (0.01s)"}},
+		{name: "custom log pattern", policy: custom, failure: Failure{Log: "dial tcp: Connection refused"}, want: true},
+		{name: "defaults replaced", policy: custom, failure: Failure{Reason: "Pod was evicted"}},
+	}
+	for _, c := range cases {
+		if got := c.policy.retryable(&c.failure); (got != "") != c.want {
+			t.Errorf("%s: retryable = %q, want a match: %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	p := retryPolicy(t, "backoff: 30s\nmaxBackoff: 5m\n")
+	for attempt, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		4:  4 * time.Minute,
+		5:  5 * time.Minute,
+		40: 5 * time.Minute,
+	} {
+		if got := p.wait(attempt); got != want {
+			t.Errorf("wait(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+	// the max bounds a backoff longer than it
+	p = retryPolicy(t, "backoff: 10m\nmaxBackoff: 1m\n")
+	if got := p.wait(1); got != time.Minute {
+		t.Errorf("wait(1) = %s, want the max backoff 1m", got)
+	}
+}
+
+func TestNewRetry(t *testing.T) {
+	pr := pipelineRun("app-build-x7k2p")
+	pr.Spec.Status = v1alpha1.PipelineRunSpecStatusCancelled
+
+	retry := newRetry(pr, 1)
+	if retry.Name != "app-build-x7k2p-retry-1" || retry.Namespace != "default" {
+		t.Errorf("retry = %s/%s", retry.Namespace, retry.Name)
+	}
+	if retry.Labels[RetryOfKey] != pr.Name || retry.Labels[RetryAttemptKey] != "1" ||
+		retry.Labels[provenance.PipelineRunKey] != retry.Name || retry.Annotations[provenance.PipelineRunKey] != retry.Name {
+		t.Errorf("retry labels %v, annotations %v", retry.Labels, retry.Annotations)
+	}
+	if _, ok := retry.Annotations[FailureKey]; ok {
+		t.Errorf("retry has the failure of %s", pr.Name)
+	}
+	if retry.Spec.ServiceAccountName != "builder" || retry.Spec.Status != "" {
+		t.Errorf("retry spec = %+v, want the spec without its status", retry.Spec)
+	}
+	if pr.Labels[RetryOfKey] != "" || pr.Annotations[FailureKey] == "" {
+		t.Errorf("the failed run is modified: %v %v", pr.Labels, pr.Annotations)
+	}
+
+	// a retry of a retry is named after the first run
+	again := newRetry(retry, retryAttempt(retry)+1)
+	if again.Name != "app-build-x7k2p-retry-2" || again.Labels[RetryOfKey] != pr.Name || retryAttempt(again) != 2 {
+		t.Errorf("second retry = %s, labels %v", again.Name, again.Labels)
+	}
+
+	long := newRetry(pipelineRun(strings.Repeat("a", 63)), 3)
+	if len(long.Name) != 63 || !strings.HasSuffix(long.Name, "-retry-3") {
+		t.Errorf("retry of a long name = %s, want 63 characters", long.Name)
+	}
+}
+
+func TestWatcherRetry(t *testing.T) {
+	evicted := &Failure{Reason: "Pod was evicted"}
+	failed := pipelineRun("app-build-x7k2p")
+	cancelled := pipelineRun("app-build-x7k2p")
+	cancelled.Spec.Status = v1alpha1.PipelineRunSpecStatusCancelled
+	lastRetry := newRetry(failed, 2)
+
+	cases := []struct {
+		name     string
+		policy   *RetryPolicy
+		run      *v1alpha1.PipelineRun
+		failure  *Failure
+		want     string
+		wantWait time.Duration
+	}{
+		{name: "no policy", run: failed, failure: evicted},
+		{name: "retried", policy: retryPolicy(t, ""), run: failed, failure: evicted, want: "app-build-x7k2p-retry-1", wantWait: 30 * time.Second},
+		{name: "retry retried", policy: retryPolicy(t, "maxRetries: 3\n"), run: lastRetry, failure: evicted, want: "app-build-x7k2p-retry-3", wantWait: 2 * time.Minute},
+		{name: "no retry left", policy: retryPolicy(t, ""), run: lastRetry, failure: evicted},
+		{name: "cancelled", policy: retryPolicy(t, ""), run: cancelled, failure: evicted},
+		{name: "not retryable", policy: retryPolicy(t, ""), run: failed, failure: &Failure{Reason: "step test exited with code 1"}},
+	}
+	for _, c := range cases {
+		w := &runWatcher{retryPolicy: c.policy}
+		retry, wait := w.retry(c.run, c.failure)
+		if c.want == "" {
+			if retry != nil {
+				t.Errorf("%s: retried as %s, want no retry", c.name, retry.Name)
+			}
+			continue
+		}
+		if retry == nil || retry.Name != c.want || wait != c.wantWait {
+			t.Errorf("%s: retry = %v in %s, want %s in %s", c.name, retry, wait, c.want, c.wantWait)
+		}
+	}
+}
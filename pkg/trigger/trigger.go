@@ -12,6 +12,9 @@ import (
 
 	"github.com/cloudevents/sdk-go/pkg/cloudevents"
 	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/events"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+	"github.com/knative-sample/tekton-serving/pkg/utils/wait"
 	"github.com/knative/eventing-sources/pkg/kncloudevents"
 	gh "gopkg.in/go-playground/webhooks.v5/github"
 )
@@ -31,6 +34,35 @@ func gitHubEventType(ghEventType gh.Event) string {
 
 type Trigger struct {
 	TriggerConfig string
+
+	// Approvers are the GitHub users allowed to /approve deploys, the owners, members
+	// and collaborators of the repository when empty
+	Approvers []string
+	// AllowSelfApproval lets the author of a pull request /approve its deploys
+	AllowSelfApproval bool
+	// AdminAddress is the address of the approvals http endpoint, disabled when empty
+	AdminAddress string
+	// AdminTokens is the file of the user=token lines authenticating the endpoint
+	AdminTokens string
+	// EventSink is the URL the pipelinerun.created events are sent to, K_SINK when empty
+	EventSink string
+	// NotifyConfig is the file of the routes the builds are notified to, no
+	// notification when empty
+	NotifyConfig string
+	// RetryConfig is the RetryPolicy of the failed PipelineRuns, no retry when empty
+	RetryConfig string
+	// ChatOpsConfig is the file of the ChatOps commands of the pull request comments,
+	// disabled when empty. GitHubAPI is the API acknowledging them.
+	ChatOpsConfig string
+	GitHubAPI     string
+
+	events   *events.Emitter
+	notifier *notify.Notifier
+	watcher  *runWatcher
+
+	retryPolicy *RetryPolicy
+	chatops     *ChatOpsConfig
+	github      *githubClient
 }
 
 type Args struct {
@@ -48,21 +80,65 @@ func (dp *Trigger) Run() error {
 		return err
 	}
 
+	if dp.events, err = events.NewEmitter(dp.EventSink, eventSource); err != nil {
+		glog.Errorf("create the event client of %s error:%s", dp.EventSink, err)
+		return err
+	}
+	if dp.notifier, err = notify.New(dp.NotifyConfig); err != nil {
+		glog.Errorf("load notify config error:%s", err)
+		return err
+	}
+	if dp.RetryConfig != "" {
+		if dp.retryPolicy, err = LoadRetryPolicy(dp.RetryConfig); err != nil {
+			glog.Errorf("load retry config error:%s", err)
+			return err
+		}
+	}
+	if err := dp.newChatOps(); err != nil {
+		glog.Errorf("load chatops config error:%s", err)
+		return err
+	}
+	if dp.watcher, err = dp.startWatcher(wait.NeverStop); err != nil {
+		glog.Errorf("start the PipelineRun watcher error:%s", err)
+		return err
+	}
+
+	if dp.AdminAddress != "" {
+		if err := dp.serveAdmin(); err != nil {
+			glog.Errorf("serve the admin endpoint error:%s", err)
+			return err
+		}
+	}
 	glog.Fatal(c.StartReceiver(context.Background(), dp.run))
 	return nil
 }
 
-func (dp *Trigger) run(e cloudevents.Event) error {
+// run handles a GitHub event and replies an event of what it did. A failure is replied
+// as a trigger.failed event carrying the error rather than returned, the transport
+// drops the reply of a receiver returning an error.
+func (dp *Trigger) run(e cloudevents.Event, resp *cloudevents.EventResponse) error {
+	reply, err := dp.handle(e)
+	if err != nil {
+		glog.Errorf("handle event %s %s error:%s", e.Context.GetType(), e.ID(), err)
+		reply = failed(e, err)
+	}
+	respond(resp, reply)
+	return nil
+}
+
+func (dp *Trigger) handle(e cloudevents.Event) (*Reply, error) {
 	switch e.Context.GetType() {
 	case gitHubEventType(gh.PingEvent):
 		dp.logEvent(e)
+		return skipped(e, "", "ping"), nil
 	case gitHubEventType(gh.PullRequestEvent):
 		return dp.pullRequestMergedEvent(e)
+	case gitHubEventType(gh.IssueCommentEvent):
+		return dp.issueCommentEvent(e)
 	default:
 		glog.Infof("ingore Event: %s ", e.Context.GetType())
+		return skipped(e, "", "unsupported event type "+e.Context.GetType()), nil
 	}
-
-	return nil
 }
 
 func (dp *Trigger) logEvent(e cloudevents.Event) {
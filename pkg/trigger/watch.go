@@ -0,0 +1,357 @@
+package trigger
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/knative-sample/tekton-serving/pkg/notify"
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/knative-sample/tekton-serving/pkg/trace"
+	"github.com/knative-sample/tekton-serving/pkg/utils/kube"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektonclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"github.com/tektoncd/pipeline/pkg/client/informers/externalversions"
+	listers "github.com/tektoncd/pipeline/pkg/client/listers/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+const (
+	// FailureKey is the annotation of the Failure of a failed PipelineRun
+	FailureKey = provenance.GroupName + "/failure"
+
+	// logTailLines and logTailBytes bound the log of the failed step kept in a Failure
+	logTailLines = 40
+	logTailBytes = 4096
+
+	// watchResync is the resync period of the informers
+	watchResync = 10 * time.Minute
+	// notifyWindow is how recent a failure must be to be notified, the runs which
+	// failed while the trigger was down are summarized without a notification
+	notifyWindow = time.Hour
+)
+
+// RunSummary is the status of a PipelineRun the trigger created and its TaskRuns, with
+// where it failed
+type RunSummary struct {
+	trace.Run
+	Namespace  string                `json:"namespace"`
+	Provenance provenance.Provenance `json:"provenance"`
+	Failure    *Failure              `json:"failure,omitempty"`
+}
+
+// Failure is the step a PipelineRun failed in and the tail of its log
+type Failure struct {
+	TaskRun   string `json:"taskRun,omitempty"`
+	Task      string `json:"task,omitempty"`
+	Step      string `json:"step,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Log       string `json:"log,omitempty"`
+	// RetriedBy is the PipelineRun retrying the failed one
+	RetriedBy string `json:"retriedBy,omitempty"`
+}
+
+// runWatcher follows the PipelineRuns and the TaskRuns labeled by the trigger. A failed
+// PipelineRun is annotated with its Failure and notified.
+type runWatcher struct {
+	tekton   tektonclientset.Interface
+	kube     kubernetes.Interface
+	runs     listers.PipelineRunLister
+	taskRuns listers.TaskRunLister
+	notifier *notify.Notifier
+	// retryPolicy recreates the runs failing for infrastructure reasons, nil retries none
+	retryPolicy *RetryPolicy
+
+	mu sync.Mutex
+	// handled are the failed runs summarized since the start, until they are deleted
+	handled map[string]bool
+}
+
+// startWatcher starts the informers and waits for their caches
+func (dp *Trigger) startWatcher(stopCh <-chan struct{}) (*runWatcher, error) {
+	cfg, err := kube.GetKubeconfig()
+	if err != nil {
+		return nil, err
+	}
+	tektonClient, err := tektonclientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newRunWatcher(tektonClient, kubeClient, dp.notifier, dp.retryPolicy, stopCh)
+}
+
+func newRunWatcher(tektonClient tektonclientset.Interface, kubeClient kubernetes.Interface, notifier *notify.Notifier,
+	retryPolicy *RetryPolicy, stopCh <-chan struct{}) (*runWatcher, error) {
+	// Tekton copies the labels of a PipelineRun to its TaskRuns
+	factory := externalversions.NewSharedInformerFactoryWithOptions(tektonClient, watchResync,
+		externalversions.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = provenance.PipelineRunKey
+		}))
+	runs := factory.Tekton().V1alpha1().PipelineRuns()
+	taskRuns := factory.Tekton().V1alpha1().TaskRuns()
+	w := &runWatcher{
+		tekton:      tektonClient,
+		kube:        kubeClient,
+		runs:        runs.Lister(),
+		taskRuns:    taskRuns.Lister(),
+		notifier:    notifier,
+		retryPolicy: retryPolicy,
+		handled:     map[string]bool{},
+	}
+	runsInformer := runs.Informer()
+
+	factory.Start(stopCh)
+	for t, ok := range factory.WaitForCacheSync(stopCh) {
+		if !ok {
+			return nil, fmt.Errorf("sync the cache of %s failed", t)
+		}
+	}
+	// the handler is added once the TaskRuns are synced, the runs listed at the start
+	// are replayed to it and their failures are found in the TaskRuns
+	runsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.onRun,
+		UpdateFunc: func(_, obj interface{}) { w.onRun(obj) },
+		DeleteFunc: w.onRunDeleted,
+	})
+	glog.Infof("watching the PipelineRuns labeled %s", provenance.PipelineRunKey)
+	return w, nil
+}
+
+// onRun summarizes a PipelineRun once it failed, and retries it or notifies its failure
+func (w *runWatcher) onRun(obj interface{}) {
+	pr, ok := obj.(*v1alpha1.PipelineRun)
+	if !ok {
+		return
+	}
+	cond := pr.Status.GetCondition(apis.ConditionSucceeded)
+	if cond == nil || !cond.IsFalse() {
+		return
+	}
+	key := pr.Namespace + "/" + pr.Name
+	w.mu.Lock()
+	if w.handled[key] {
+		w.mu.Unlock()
+		return
+	}
+	w.handled[key] = true
+	w.mu.Unlock()
+
+	if v := pr.Annotations[FailureKey]; v != "" {
+		// summarized before a restart, create the retry the trigger may not have created
+		f := &Failure{}
+		if err := json.Unmarshal([]byte(v), f); err == nil && f.RetriedBy != "" {
+			if _, err := w.runs.PipelineRuns(pr.Namespace).Get(f.RetriedBy); errors.IsNotFound(err) {
+				w.createRetry(pr, newRetry(pr, retryAttempt(pr)+1), f, 0)
+			}
+		}
+		return
+	}
+
+	s := w.summarize(pr)
+	glog.Infof("PipelineRun %s failed in task %s step %s: %s", key, s.Failure.Task, s.Failure.Step, s.Failure.Reason)
+	retry, wait := w.retry(pr, s.Failure)
+	if retry != nil {
+		s.Failure.RetriedBy = retry.Name
+	}
+	if err := w.annotate(pr, s.Failure); err != nil {
+		glog.Errorf("annotate PipelineRun %s error:%s", key, err)
+	}
+	if retry != nil {
+		w.createRetry(pr, retry, s.Failure, wait)
+		return
+	}
+	w.notifyFailure(pr, s.Failure)
+}
+
+// onRunDeleted forgets a deleted PipelineRun, so handled doesn't grow with every run
+func (w *runWatcher) onRunDeleted(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	delete(w.handled, key)
+	w.mu.Unlock()
+}
+
+// notifyFailure notifies the final failure of the PipelineRun, the runs which failed
+// while the trigger was down are not notified
+func (w *runWatcher) notifyFailure(pr *v1alpha1.PipelineRun, f *Failure) {
+	if pr.Status.CompletionTime != nil && time.Since(pr.Status.CompletionTime.Time) > notifyWindow {
+		return
+	}
+	m := runMessage(notify.KindBuildFailed, pr)
+	m.Task, m.Step, m.Reason, m.Log = f.Task, f.Step, f.Reason, f.Log
+	m.Retries = retryAttempt(pr)
+	w.notifier.Notify(m)
+}
+
+// summarize builds the summary of the PipelineRun, the Failure of a failed one is read
+// from its annotation once it is set
+func (w *runWatcher) summarize(pr *v1alpha1.PipelineRun) *RunSummary {
+	s := &RunSummary{
+		Run:        *trace.NewRun(pr.Name, &pr.Status.Status, pr.Status.StartTime, pr.Status.CompletionTime),
+		Namespace:  pr.Namespace,
+		Provenance: provenance.FromAnnotations(pr.Annotations),
+	}
+	if s.Provenance.PipelineRun == "" {
+		s.Provenance.PipelineRun = pr.Name
+	}
+
+	trs, err := w.taskRuns.TaskRuns(pr.Namespace).List(labels.SelectorFromSet(labels.Set{
+		pipeline.GroupName + pipeline.PipelineRunLabelKey: pr.Name,
+	}))
+	if err != nil {
+		glog.Warningf("list TaskRuns of %s/%s error:%s", pr.Namespace, pr.Name, err)
+	}
+	sort.SliceStable(trs, func(i, j int) bool {
+		a, b := trs[i].Status.StartTime, trs[j].Status.StartTime
+		return a != nil && (b == nil || a.Before(b))
+	})
+	for _, tr := range trs {
+		task := trace.NewRun(tr.Name, &tr.Status.Status, tr.Status.StartTime, tr.Status.CompletionTime)
+		task.PipelineTask = tr.Labels[pipeline.GroupName+pipeline.PipelineTaskLabelKey]
+		s.TaskRuns = append(s.TaskRuns, *task)
+	}
+
+	if cond := pr.Status.GetCondition(apis.ConditionSucceeded); cond == nil || !cond.IsFalse() {
+		return s
+	}
+	if v := pr.Annotations[FailureKey]; v != "" {
+		s.Failure = &Failure{}
+		if err := json.Unmarshal([]byte(v), s.Failure); err == nil {
+			return s
+		}
+	}
+	s.Failure = w.failure(pr, trs)
+	return s
+}
+
+// failure finds the first failed TaskRun of the PipelineRun and its failed step, and
+// fetches the tail of the log of the step
+func (w *runWatcher) failure(pr *v1alpha1.PipelineRun, trs []*v1alpha1.TaskRun) *Failure {
+	f := &Failure{}
+	if cond := pr.Status.GetCondition(apis.ConditionSucceeded); cond != nil {
+		f.Reason = cond.Message
+	}
+	for _, tr := range trs {
+		cond := tr.Status.GetCondition(apis.ConditionSucceeded)
+		if cond == nil || !cond.IsFalse() {
+			continue
+		}
+		f.TaskRun = tr.Name
+		f.Task = tr.Labels[pipeline.GroupName+pipeline.PipelineTaskLabelKey]
+		f.Pod = tr.Status.PodName
+		if cond.Message != "" {
+			f.Reason = cond.Message
+		}
+		for _, step := range tr.Status.Steps {
+			if step.Terminated != nil && step.Terminated.ExitCode != 0 {
+				f.Step, f.Container = step.Name, step.ContainerName
+				break
+			}
+		}
+		break
+	}
+	if f.Pod != "" && f.Container != "" {
+		f.Log = w.logTail(pr.Namespace, f.Pod, f.Container)
+	}
+	return f
+}
+
+// logTail returns the last lines of the log of the container, empty once the pod is gone
+func (w *runWatcher) logTail(namespace, pod, container string) string {
+	lines := int64(logTailLines)
+	bts, err := w.kube.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: container,
+		TailLines: &lines,
+	}).DoRaw()
+	if err != nil {
+		glog.Warningf("get log of %s/%s container %s error:%s", namespace, pod, container, err)
+		return ""
+	}
+	if len(bts) > logTailBytes {
+		bts = bts[len(bts)-logTailBytes:]
+	}
+	return strings.TrimRight(string(bts), "\n")
+}
+
+// annotate sets the Failure annotation of the PipelineRun
+func (w *runWatcher) annotate(pr *v1alpha1.PipelineRun, f *Failure) error {
+	value, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{FailureKey: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tekton.TektonV1alpha1().PipelineRuns(pr.Namespace).Patch(pr.Name, types.MergePatchType, patch)
+	return err
+}
+
+// summary is the summary of the PipelineRun, namespace may be empty when the name is
+// not used in several namespaces
+func (w *runWatcher) summary(namespace, name string) (*RunSummary, error) {
+	if namespace != "" {
+		pr, err := w.runs.PipelineRuns(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return w.summarize(pr), nil
+	}
+	prs, err := w.runs.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var found *v1alpha1.PipelineRun
+	for _, pr := range prs {
+		if pr.Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("PipelineRun %s is in namespaces %s and %s, set the namespace", name, found.Namespace, pr.Namespace)
+		}
+		found = pr
+	}
+	if found == nil {
+		return nil, errors.NewNotFound(v1alpha1.Resource("pipelineruns"), name)
+	}
+	return w.summarize(found), nil
+}
+
+// runMessage is the message of the PipelineRun with the provenance the trigger stamped
+func runMessage(kind string, pr *v1alpha1.PipelineRun) *notify.Message {
+	prov := provenance.FromAnnotations(pr.Annotations)
+	return &notify.Message{
+		Kind:        kind,
+		Repo:        prov.Repo,
+		Commit:      prov.Commit,
+		PullRequest: prov.PullRequest,
+		Author:      prov.Author,
+		PipelineRun: pr.Name,
+		Namespace:   pr.Namespace,
+	}
+}
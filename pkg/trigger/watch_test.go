@@ -0,0 +1,120 @@
+package trigger
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/knative-sample/tekton-serving/pkg/provenance"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektonfake "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+// failedRun is a PipelineRun failed in its build task and the TaskRun of the task
+func failedRun(name, message string) (*v1alpha1.PipelineRun, *v1alpha1.TaskRun) {
+	pr := pipelineRun(name)
+	delete(pr.Annotations, FailureKey)
+	pr.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Message: "Tasks Completed: 1 (Failed: 1)"})
+
+	tr := &v1alpha1.TaskRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name + "-build",
+			Labels: map[string]string{
+				provenance.PipelineRunKey:                          name,
+				pipeline.GroupName + pipeline.PipelineRunLabelKey:  name,
+				pipeline.GroupName + pipeline.PipelineTaskLabelKey: "build",
+			},
+		},
+	}
+	tr.Status.PodName = name + "-build-pod"
+	tr.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Message: message})
+	return pr, tr
+}
+
+// eventually polls cond until it holds or a few seconds passed
+func eventually(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatcherRetriesFailedRun(t *testing.T) {
+	pr, tr := failedRun("app-build-x7k2p", "Pod was evicted")
+	tektonClient := tektonfake.NewSimpleClientset(pr, tr)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	w, err := newRunWatcher(tektonClient, fake.NewSimpleClientset(), nil, retryPolicy(t, "backoff: 10ms\n"), stopCh)
+	if err != nil {
+		t.Fatalf("newRunWatcher error:%s", err)
+	}
+
+	runs := tektonClient.TektonV1alpha1().PipelineRuns("default")
+	var retry *v1alpha1.PipelineRun
+	eventually(t, "the retry", func() bool {
+		retry, err = runs.Get("app-build-x7k2p-retry-1", metav1.GetOptions{})
+		return err == nil
+	})
+	if retry.Labels[RetryOfKey] != pr.Name || retry.Labels[RetryAttemptKey] != "1" {
+		t.Errorf("retry labels = %v", retry.Labels)
+	}
+
+	failed, err := runs.Get(pr.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get PipelineRun error:%s", err)
+	}
+	f := &Failure{}
+	if err := json.Unmarshal([]byte(failed.Annotations[FailureKey]), f); err != nil {
+		t.Fatalf("parse failure %q error:%s", failed.Annotations[FailureKey], err)
+	}
+	want := Failure{TaskRun: tr.Name, Task: "build", Pod: tr.Status.PodName, Reason: "Pod was evicted", RetriedBy: retry.Name}
+	if *f != want {
+		t.Errorf("failure = %+v, want %+v", f, want)
+	}
+
+	// the update of the annotation is not summarized again
+	patches := 0
+	for _, a := range tektonClient.Actions() {
+		if a.GetVerb() == "patch" {
+			patches++
+		}
+	}
+	if patches != 1 {
+		t.Errorf("PipelineRun patched %d times, want once", patches)
+	}
+
+	if err := runs.Delete(pr.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete PipelineRun error:%s", err)
+	}
+	eventually(t, "the deleted run to be forgotten", func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return !w.handled["default/"+pr.Name]
+	})
+}
+
+func TestOnRunDeleted(t *testing.T) {
+	pr, _ := failedRun("app-build-x7k2p", "step test exited with code 1")
+	other, _ := failedRun("app-build-b9m4q", "step test exited with code 1")
+	w := &runWatcher{handled: map[string]bool{"default/app-build-x7k2p": true, "default/app-build-b9m4q": true}}
+
+	w.onRunDeleted(pr)
+	if w.handled["default/app-build-x7k2p"] || !w.handled["default/app-build-b9m4q"] {
+		t.Errorf("handled = %v, want the deleted run forgotten", w.handled)
+	}
+	// a run deleted while the watch was down comes as a tombstone
+	w.onRunDeleted(cache.DeletedFinalStateUnknown{Key: "default/app-build-b9m4q", Obj: other})
+	if len(w.handled) != 0 {
+		t.Errorf("handled = %v, want the tombstone forgotten", w.handled)
+	}
+}
@@ -0,0 +1,138 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around a change
+const contextLines = 3
+
+// op is one line of the edit script: ' ' kept, '-' removed from a, '+' added from b
+type op struct {
+	kind byte
+	line string
+	// ai and bi are the numbers of the line in a and b, 0 based
+	ai, bi int
+}
+
+// lines splits a text in lines without the trailing newline
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// script computes the edit script from a to b on their longest common subsequence
+func script(a, b []string) []op {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// Unified returns the unified diff of the texts a and b named from and to, it is empty
+// when they are equal
+func Unified(from, to, a, b string) string {
+	ops := script(lines(a), lines(b))
+
+	// hunks are the ranges of ops with the changes and their context
+	hunks := [][2]int{}
+	for k := 0; k < len(ops); k++ {
+		if ops[k].kind == ' ' {
+			continue
+		}
+		start := k - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(ops) {
+			// extend the hunk up to the last change followed by more than twice the
+			// context of unchanged lines
+			next := end + 1
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end-1 > 2*contextLines {
+				break
+			}
+			end = next
+		}
+		stop := end + contextLines + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		hunks = append(hunks, [2]int{start, stop})
+		k = stop - 1
+	}
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", from, to)
+	for _, h := range hunks {
+		hunk := ops[h[0]:h[1]]
+		aStart, bStart := hunk[0].ai, hunk[0].bi
+		aLen, bLen := 0, 0
+		for _, o := range hunk {
+			if o.kind != '+' {
+				aLen++
+			}
+			if o.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, o := range hunk {
+			out.WriteByte(o.kind)
+			out.WriteString(o.line)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+// hunkRange formats the start and length of a hunk, lines are 1 based and an empty
+// range starts at the line before it
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
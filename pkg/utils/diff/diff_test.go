@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numbers returns the lines 1 to n with the lines of edits replaced, a line replaced by
+// an empty string is removed
+func numbers(n int, edits map[int]string) string {
+	out := &strings.Builder{}
+	for i := 1; i <= n; i++ {
+		line := strconv.Itoa(i)
+		if e, ok := edits[i]; ok {
+			if e == "" {
+				continue
+			}
+			line = e
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
+
+func TestUnified(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		want string
+	}{{
+		name: "equal",
+		a:    numbers(5, nil),
+		b:    numbers(5, nil),
+	}, {
+		name: "changed line with its context",
+		a:    numbers(10, nil),
+		b:    numbers(10, map[int]string{5: "five"}),
+		want: "@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+	}, {
+		name: "distant changes in two hunks",
+		a:    numbers(20, nil),
+		b:    numbers(20, map[int]string{3: "three", 17: ""}),
+		want: "@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+			"@@ -14,7 +14,6 @@\n 14\n 15\n 16\n-17\n 18\n 19\n 20\n",
+	}, {
+		name: "close changes in one hunk",
+		a:    numbers(20, nil),
+		b:    numbers(20, map[int]string{3: "three", 10: "ten"}),
+		want: "@@ -1,13 +1,13 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n 9\n-10\n+ten\n 11\n 12\n 13\n",
+	}, {
+		name: "added at the end",
+		a:    "a\nb\n",
+		b:    "a\nb\nc\n",
+		want: "@@ -1,2 +1,3 @@\n a\n b\n+c\n",
+	}, {
+		name: "created",
+		b:    "x\ny\n",
+		want: "@@ -0,0 +1,2 @@\n+x\n+y\n",
+	}, {
+		name: "deleted",
+		a:    "x\ny\n",
+		want: "@@ -1,2 +0,0 @@\n-x\n-y\n",
+	}, {
+		name: "missing trailing newline",
+		a:    "x\ny",
+		b:    "x\ny\n",
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Unified("a/app.yaml", "b/app.yaml", c.a, c.b)
+			if c.want == "" {
+				if got != "" {
+					t.Errorf("Unified =\n%s\nwant no diff", got)
+				}
+				return
+			}
+			want := "--- a/app.yaml\n+++ b/app.yaml\n" + c.want
+			if got != want {
+				t.Errorf("Unified =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// GetContextConfig creates a *rest.Config for a context of a kubeconfig file. An empty
+// path follows --kubeconfig, then the usual KUBECONFIG and $HOME/.kube/config rules.
+func GetContextConfig(path, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path == "" {
+		path = kubeconfig
+	}
+	rules.ExplicitPath = path
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// GetSecretConfig creates a *rest.Config from a kubeconfig stored under key in a Secret,
+// the current context of the kubeconfig is used when context is empty
+func GetSecretConfig(client kubernetes.Interface, namespace, name, key, context string) (*rest.Config, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no key %s", namespace, name, key)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse kubeconfig of secret %s/%s error:%s", namespace, name, err)
+	}
+	return clientcmd.NewNonInteractiveClientConfig(*config, context, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+}
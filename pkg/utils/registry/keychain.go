@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Auth is the credential of one registry
+type Auth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// dockerConfig is the format of ~/.docker/config.json and of kubernetes.io/dockerconfigjson secrets
+type dockerConfig struct {
+	Auths map[string]Auth `json:"auths"`
+}
+
+// Keychain resolves the credential of a registry from docker config files
+type Keychain struct {
+	auths map[string]Auth
+}
+
+// NewKeychain returns an empty Keychain, every registry is accessed anonymously
+func NewKeychain() *Keychain {
+	return &Keychain{auths: map[string]Auth{}}
+}
+
+// AddDockerConfigJSON adds the auths of a .dockerconfigjson document
+func (k *Keychain) AddDockerConfigJSON(data []byte) error {
+	cfg := &dockerConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+	k.add(cfg.Auths)
+	return nil
+}
+
+// AddDockerCfg adds the auths of a legacy .dockercfg document
+func (k *Keychain) AddDockerCfg(data []byte) error {
+	auths := map[string]Auth{}
+	if err := json.Unmarshal(data, &auths); err != nil {
+		return err
+	}
+	k.add(auths)
+	return nil
+}
+
+// AddDockerConfigFile adds the auths of $DOCKER_CONFIG/config.json or ~/.docker/config.json
+// when the file exists
+func (k *Keychain) AddDockerConfigFile() error {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return k.AddDockerConfigJSON(data)
+}
+
+func (k *Keychain) add(auths map[string]Auth) {
+	for host, auth := range auths {
+		// auths set first win, the same as the kubelet keyring
+		key := normalizeHost(host)
+		if _, ok := k.auths[key]; !ok {
+			k.auths[key] = auth
+		}
+	}
+}
+
+// Resolve returns the username and password of the registry
+func (k *Keychain) Resolve(reg name.Registry) (string, string, bool) {
+	auth, ok := k.auths[normalizeHost(reg.RegistryStr())]
+	if !ok {
+		return "", "", false
+	}
+	if auth.Username != "" || auth.Password != "" {
+		return auth.Username, auth.Password, true
+	}
+	if auth.Auth == "" {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// normalizeHost strips the scheme and path of a docker config key, and maps the
+// docker hub aliases to the registry name used by go-containerregistry
+func normalizeHost(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "docker.io", "registry-1.docker.io", name.DefaultRegistry:
+		return name.DefaultRegistry
+	}
+	return host
+}
@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// manifestAccept are the manifest media types the digest is resolved for, the manifest
+// list comes first so the digest of a multi-arch image is the index digest
+var manifestAccept = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+// Resolver resolves image tags to immutable digests with the registry v2 API
+type Resolver struct {
+	Keychain *Keychain
+	// Insecure are the registries accessed over plain http
+	Insecure []string
+	Client   *http.Client
+}
+
+// NewResolver returns a Resolver using the keychain
+func NewResolver(keychain *Keychain, insecure []string) *Resolver {
+	if keychain == nil {
+		keychain = NewKeychain()
+	}
+	return &Resolver{
+		Keychain: keychain,
+		Insecure: insecure,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Resolve returns the image as repo@sha256:..., an image already pinned to a digest
+// is returned unchanged
+func (r *Resolver) Resolve(image string) (string, error) {
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return "", fmt.Errorf("parse image %s error:%s", image, err)
+	}
+	if _, ok := ref.(name.Digest); ok {
+		return image, nil
+	}
+
+	repo := ref.Context()
+	if r.isInsecure(repo.RegistryStr()) {
+		if repo, err = name.NewRepository(repo.Name(), name.WeakValidation, name.Insecure); err != nil {
+			return "", err
+		}
+	}
+
+	u := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", repo.Registry.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), ref.Identifier())
+	digest, err := r.manifestDigest(repo, u)
+	if err != nil {
+		return "", fmt.Errorf("resolve image %s error:%s", image, err)
+	}
+	return fmt.Sprintf("%s@%s", repo.Name(), digest), nil
+}
+
+func (r *Resolver) isInsecure(registry string) bool {
+	for _, reg := range r.Insecure {
+		if reg == registry {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestDigest asks the registry for the digest of the manifest, the Docker-Content-Digest
+// header of a HEAD request is used when present, otherwise the manifest is fetched and hashed
+func (r *Resolver) manifestDigest(repo name.Repository, u string) (string, error) {
+	resp, err := r.do(repo, http.MethodHead, u)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+			return digest, nil
+		}
+	}
+
+	resp, err = r.do(repo, http.MethodGet, u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(body)), nil
+}
+
+// do sends the request, and answers a 401 challenge once with basic auth or a bearer token
+func (r *Resolver) do(repo name.Repository, method, u string) (*http.Response, error) {
+	resp, err := r.send(method, u, "")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	username, password, ok := r.Keychain.Resolve(repo.Registry)
+	var authorization string
+	switch {
+	case strings.HasPrefix(strings.ToLower(challenge), "basic"):
+		if !ok {
+			return nil, fmt.Errorf("%s %s: unauthorized and no credential for %s", method, u, repo.RegistryStr())
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		authorization = req.Header.Get("Authorization")
+	case strings.HasPrefix(strings.ToLower(challenge), "bearer"):
+		token, err := r.token(challenge, repo.Scope("pull"), username, password, ok)
+		if err != nil {
+			return nil, err
+		}
+		authorization = "Bearer " + token
+	default:
+		return nil, fmt.Errorf("%s %s: unauthorized, unsupported challenge %q", method, u, challenge)
+	}
+
+	return r.send(method, u, authorization)
+}
+
+func (r *Resolver) send(method, u, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestAccept, ","))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return r.Client.Do(req)
+}
+
+// token fetches a bearer token from the realm of the challenge
+func (r *Resolver) token(challenge, scope, username, password string, hasAuth bool) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge without realm: %q", challenge)
+	}
+	tu, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := tu.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	tu.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, tu.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasAuth {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET token %s: %s", realm, resp.Status)
+	}
+
+	tr := &struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(tr); err != nil {
+		return "", err
+	}
+	if tr.Token != "" {
+		return tr.Token, nil
+	}
+	return tr.AccessToken, nil
+}
+
+// parseChallenge parses the parameters of a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	if i := strings.Index(challenge, " "); i >= 0 {
+		challenge = challenge[i+1:]
+	}
+	for _, part := range strings.Split(challenge, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
@@ -0,0 +1,182 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	indexDigest    = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	manifestDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	listType       = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// fakeRegistry serves the manifests of app:v1. It answers the digest of the manifest list
+// when the client accepts it first, like a registry holding a multi-arch image.
+type fakeRegistry struct {
+	// auth is "", "basic" or "bearer"
+	auth string
+	// digestHeader sets Docker-Content-Digest on the manifest responses
+	digestHeader bool
+	// manifest is the body of GET, hashed when there is no digest header
+	manifest string
+
+	srv    *httptest.Server
+	scopes []string
+}
+
+func newFakeRegistry(auth string, digestHeader bool) *fakeRegistry {
+	r := &fakeRegistry{auth: auth, digestHeader: digestHeader, manifest: `{"schemaVersion":2}`}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", r.token)
+	mux.HandleFunc("/v2/", r.manifests)
+	r.srv = httptest.NewServer(mux)
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.srv.URL, "http://")
+}
+
+func (r *fakeRegistry) token(w http.ResponseWriter, req *http.Request) {
+	if user, pass, ok := req.BasicAuth(); !ok || user != "bob" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+	json.NewEncoder(w).Encode(map[string]string{"token": "t0ken"})
+}
+
+func (r *fakeRegistry) manifests(w http.ResponseWriter, req *http.Request) {
+	switch r.auth {
+	case "basic":
+		if user, pass, ok := req.BasicAuth(); !ok || user != "bob" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case "bearer":
+		if req.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	if req.URL.Path != "/v2/team/app/manifests/v1" {
+		http.NotFound(w, req)
+		return
+	}
+	digest := manifestDigest
+	if strings.HasPrefix(req.Header.Get("Accept"), listType) {
+		digest = indexDigest
+	}
+	if r.digestHeader {
+		w.Header().Set("Docker-Content-Digest", digest)
+	}
+	if req.Method == http.MethodGet {
+		w.Write([]byte(r.manifest))
+	}
+}
+
+func keychain(t *testing.T, host string) *Keychain {
+	k := NewKeychain()
+	cfg := fmt.Sprintf(`{"auths":{%q:{"username":"bob","password":"secret"}}}`, "http://"+host)
+	if err := k.AddDockerConfigJSON([]byte(cfg)); err != nil {
+		t.Fatalf("AddDockerConfigJSON error:%s", err)
+	}
+	return k
+}
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		name         string
+		auth         string
+		digestHeader bool
+		creds        bool
+		want         string
+		wantErr      bool
+	}{{
+		name:         "manifest list accepted first",
+		digestHeader: true,
+		want:         indexDigest,
+	}, {
+		name: "hash of the manifest without digest header",
+		want: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(`{"schemaVersion":2}`))),
+	}, {
+		name:         "basic auth",
+		auth:         "basic",
+		digestHeader: true,
+		creds:        true,
+		want:         indexDigest,
+	}, {
+		name:         "bearer token",
+		auth:         "bearer",
+		digestHeader: true,
+		creds:        true,
+		want:         indexDigest,
+	}, {
+		name:         "basic auth without credential",
+		auth:         "basic",
+		digestHeader: true,
+		wantErr:      true,
+	}, {
+		name:         "bearer token without credential",
+		auth:         "bearer",
+		digestHeader: true,
+		wantErr:      true,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reg := newFakeRegistry(c.auth, c.digestHeader)
+			defer reg.srv.Close()
+			k := NewKeychain()
+			if c.creds {
+				k = keychain(t, reg.host())
+			}
+			got, err := NewResolver(k, nil).Resolve(reg.host() + "/team/app:v1")
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Resolve = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve error:%s", err)
+			}
+			if want := reg.host() + "/team/app@" + c.want; got != want {
+				t.Errorf("Resolve = %s, want %s", got, want)
+			}
+			if c.auth == "bearer" {
+				if len(reg.scopes) == 0 {
+					t.Errorf("no token was requested")
+				}
+				for _, s := range reg.scopes {
+					if s != "repository:team/app:pull" {
+						t.Errorf("token scope = %q, want repository:team/app:pull", s)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResolveDigestUnchanged(t *testing.T) {
+	image := "registry.example.com/team/app@" + manifestDigest
+	got, err := NewResolver(nil, nil).Resolve(image)
+	if err != nil || got != image {
+		t.Errorf("Resolve = %s, %v, want %s", got, err, image)
+	}
+}
+
+func TestResolveMissingTag(t *testing.T) {
+	reg := newFakeRegistry("", true)
+	defer reg.srv.Close()
+	if got, err := NewResolver(nil, nil).Resolve(reg.host() + "/team/app:v2"); err == nil {
+		t.Errorf("Resolve = %s, want an error", got)
+	}
+}